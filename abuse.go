@@ -0,0 +1,144 @@
+package godge
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type blockRecord struct {
+	// The number of consecutive failed authentications and rate limit hits since
+	// windowStart.
+	strikes      int
+	windowStart  time.Time
+	blockedUntil time.Time
+}
+
+// stale reports whether the record neither blocks its key nor has strikes in the
+// window of the given duration anymore.
+func (r *blockRecord) stale(now time.Time, d time.Duration) bool {
+	return !r.blockedUntil.After(now) && now.Sub(r.windowStart) > d
+}
+
+// blocks keeps track of the abuses of each key (e.g. a client IP or a username) to
+// block the abusive ones.
+type blocks struct {
+	sync.Mutex
+	m map[string]*blockRecord
+}
+
+// strike records an abuse from the key and blocks it for the given duration once
+// it reaches max strikes within that duration. It returns true if the key got
+// blocked. The stale records of the other keys are dropped.
+func (b *blocks) strike(key string, max int, d time.Duration) bool {
+	b.Lock()
+	defer b.Unlock()
+	now := time.Now()
+	for k, r := range b.m {
+		if r.stale(now, d) {
+			delete(b.m, k)
+		}
+	}
+	r, ok := b.m[key]
+	if !ok {
+		r = &blockRecord{windowStart: now}
+		b.m[key] = r
+	}
+	r.strikes++
+	if r.strikes < max {
+		return false
+	}
+	r.strikes = 0
+	r.windowStart = now
+	r.blockedUntil = now.Add(d)
+	return true
+}
+
+// reset forgets the previous abuses of the key.
+func (b *blocks) reset(key string) {
+	b.Lock()
+	defer b.Unlock()
+	if r, ok := b.m[key]; ok && !r.blockedUntil.After(time.Now()) {
+		delete(b.m, key)
+	}
+}
+
+// blocked reports whether the key is currently blocked. The record of a key whose
+// block expired is dropped.
+func (b *blocks) blocked(key string) bool {
+	b.Lock()
+	defer b.Unlock()
+	r, ok := b.m[key]
+	if !ok || r.blockedUntil.IsZero() {
+		return false
+	}
+	if time.Now().After(r.blockedUntil) {
+		delete(b.m, key)
+		return false
+	}
+	return true
+}
+
+// strikeClient records an abuse (e.g. wrong credentials) from the client of the
+// request if abusive clients are blocked.
+func (s *Server) strikeClient(req *http.Request) {
+	if s.MaxClientStrikes <= 0 {
+		return
+	}
+	ip := clientIP(req, s.TrustForwardedFor)
+	if ip == nil {
+		return
+	}
+	if s.ipBlocks.strike(ip.String(), s.MaxClientStrikes, s.ClientBlockDuration) {
+		log.Printf("Blocked %v for %v after %v failed authentications or rate limit hits", ip, s.ClientBlockDuration, s.MaxClientStrikes)
+	}
+}
+
+// resetClient forgets the abuses of the client of the request after a successful authentication.
+func (s *Server) resetClient(req *http.Request) {
+	if s.MaxClientStrikes <= 0 {
+		return
+	}
+	if ip := clientIP(req, s.TrustForwardedFor); ip != nil {
+		s.ipBlocks.reset(ip.String())
+	}
+}
+
+// strikeAccount records a failed authentication of the user if accounts are locked
+// after failed logins.
+func (s *Server) strikeAccount(username string) {
+	if s.MaxFailedLogins <= 0 {
+		return
+	}
+	if s.accountLocks.strike(username, s.MaxFailedLogins, s.AccountLockDuration) {
+		log.Printf("Locked the account of %v for %v after %v failed authentications", username, s.AccountLockDuration, s.MaxFailedLogins)
+	}
+}
+
+// resetAccount forgets the failed authentications of the user after a successful one.
+func (s *Server) resetAccount(username string) {
+	if s.MaxFailedLogins > 0 {
+		s.accountLocks.reset(username)
+	}
+}
+
+// blockAbusiveClients rejects the requests of the blocked clients with 403 and the
+// requests authenticating as locked accounts with 423.
+func (s *Server) blockAbusiveClients(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if s.MaxClientStrikes > 0 {
+			if ip := clientIP(req, s.TrustForwardedFor); ip != nil && s.ipBlocks.blocked(ip.String()) {
+				httpJSONError(w, "Too many failed attempts, try again later", http.StatusForbidden)
+				return
+			}
+		}
+		if s.MaxFailedLogins > 0 {
+			if username, _, ok := req.BasicAuth(); ok && s.accountLocks.blocked(username) {
+				httpJSONError(w, "The account is locked after too many failed logins, try again later", http.StatusLocked)
+				return
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,92 @@
+package godge
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// login sends an authenticated request from the client IP through the abuse checks.
+func (s *testServer) login(username, password, ip string) int {
+	req := httptest.NewRequest(http.MethodGet, "/submissions", nil)
+	req.RemoteAddr = ip + ":1234"
+	req.SetBasicAuth(username, password)
+	w := httptest.NewRecorder()
+	s.blockAbusiveClients(http.HandlerFunc(s.submissionsHTTPHandler)).ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestClientBlockedAfterStrikes(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	s.MaxClientStrikes = 3
+	s.ClientBlockDuration = 100 * time.Millisecond
+
+	for i := 0; i < s.MaxClientStrikes; i++ {
+		if code := s.login(testUsername, "wrong", "10.0.0.1"); code != http.StatusUnauthorized {
+			t.Fatalf("Login with a wrong password returned %v, want %v", code, http.StatusUnauthorized)
+		}
+	}
+	if code := s.login(testUsername, testPassword, "10.0.0.1"); code != http.StatusForbidden {
+		t.Errorf("Login from a blocked client returned %v, want %v", code, http.StatusForbidden)
+	}
+	if code := s.login(testUsername, testPassword, "10.0.0.2"); code != http.StatusOK {
+		t.Errorf("Login from another client returned %v, want %v", code, http.StatusOK)
+	}
+	time.Sleep(s.ClientBlockDuration + 50*time.Millisecond)
+	if code := s.login(testUsername, testPassword, "10.0.0.1"); code != http.StatusOK {
+		t.Errorf("Login after the cooldown returned %v, want %v", code, http.StatusOK)
+	}
+}
+
+func TestClientStrikesExpire(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	s.MaxClientStrikes = 2
+	s.ClientBlockDuration = 100 * time.Millisecond
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		s.login(testUsername, "wrong", ip)
+	}
+	time.Sleep(s.ClientBlockDuration + 50*time.Millisecond)
+	// The previous strike of the client is out of the window.
+	s.login(testUsername, "wrong", "10.0.0.1")
+	if code := s.login(testUsername, testPassword, "10.0.0.1"); code != http.StatusOK {
+		t.Errorf("Login after an expired strike returned %v, want %v", code, http.StatusOK)
+	}
+	s.login(testUsername, "wrong", "10.0.0.4")
+	s.ipBlocks.Lock()
+	defer s.ipBlocks.Unlock()
+	if len(s.ipBlocks.m) != 1 {
+		t.Errorf("Got %v client records, want only the recent one", len(s.ipBlocks.m))
+	}
+}
+
+func TestAccountLockedAfterFailedLogins(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	s.MaxFailedLogins = 2
+	s.AccountLockDuration = 100 * time.Millisecond
+
+	for i := 0; i < s.MaxFailedLogins; i++ {
+		s.login(testUsername, "wrong", "10.0.0.1")
+	}
+	if code := s.login(testUsername, testPassword, "10.0.0.2"); code != http.StatusLocked {
+		t.Errorf("Login to a locked account returned %v, want %v", code, http.StatusLocked)
+	}
+	time.Sleep(s.AccountLockDuration + 50*time.Millisecond)
+	if code := s.login(testUsername, testPassword, "10.0.0.2"); code != http.StatusOK {
+		t.Errorf("Login after the lock returned %v, want %v", code, http.StatusOK)
+	}
+	// The successful login reset the count.
+	s.login(testUsername, "wrong", "10.0.0.1")
+	if code := s.login(testUsername, testPassword, "10.0.0.2"); code != http.StatusOK {
+		t.Errorf("Login after a single failure returned %v, want %v", code, http.StatusOK)
+	}
+	s.accountLocks.Lock()
+	defer s.accountLocks.Unlock()
+	if len(s.accountLocks.m) != 0 {
+		t.Errorf("Got %v account records, want none", len(s.accountLocks.m))
+	}
+}
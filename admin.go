@@ -0,0 +1,336 @@
+package godge
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// authenticateAdmin makes sure that the request is sent by one of the server's
+// admins. Impersonation tokens are not accepted, so they are scoped to the users'
+// endpoints. It writes the error response and returns false otherwise.
+func (s *Server) authenticateAdmin(w http.ResponseWriter, req *http.Request) (*user, bool) {
+	u, ok := s.authenticateBasic(req)
+	if !ok {
+		httpJSONError(w, "Wrong username or password", http.StatusUnauthorized)
+		return nil, false
+	}
+	if !containsString(s.Admins, u.Username) {
+		httpJSONError(w, "Only admins are allowed", http.StatusForbidden)
+		return nil, false
+	}
+	return u, true
+}
+
+// TaskStateRequest represents the request to move a task to another state.
+type TaskStateRequest struct {
+	State TaskState `json:"state"`
+}
+
+// Dispatches the admin requests of a specific task (e.g. /admin/tasks/<name>/state).
+func (s *Server) adminTaskHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	parts := splitPath(req.URL.Path, "/admin/tasks/")
+	if len(parts) == 2 && parts[1] == "state" {
+		s.taskStateHTTPHandler(w, req, parts[0])
+		return
+	}
+	if len(parts) == 2 && parts[1] == "points" {
+		s.taskPointsHTTPHandler(w, req, parts[0])
+		return
+	}
+	httpJSONError(w, "Not found", http.StatusNotFound)
+}
+
+// Handles moving a task to another state.
+func (s *Server) taskStateHTTPHandler(w http.ResponseWriter, req *http.Request, taskName string) {
+	if req.Method != http.MethodPost {
+		httpMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	u, ok := s.authenticateAdmin(w, req)
+	if !ok {
+		return
+	}
+
+	var sreq TaskStateRequest
+	if err := json.NewDecoder(req.Body).Decode(&sreq); err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to decode request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := s.tasks.get(taskName); !ok {
+		httpJSONError(w, fmt.Sprintf("Task %v not found", taskName), http.StatusNotFound)
+		return
+	}
+	if err := s.tasks.update(taskName, func(t *Task) error {
+		return t.transition(sreq.State)
+	}); err != nil {
+		httpJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Task %v moved to %v by %v", taskName, sreq.State, u.Username)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// TaskPointsRequest represents the request to change the points a task is worth.
+type TaskPointsRequest struct {
+	Points int `json:"points"`
+}
+
+// Handles changing the points a task is worth. The scores are derived from the
+// stored fractions of the points, so the scoreboard reflects the change without
+// judging the submissions again.
+func (s *Server) taskPointsHTTPHandler(w http.ResponseWriter, req *http.Request, taskName string) {
+	if req.Method != http.MethodPost {
+		httpMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	u, ok := s.authenticateAdmin(w, req)
+	if !ok {
+		return
+	}
+
+	var preq TaskPointsRequest
+	if err := json.NewDecoder(req.Body).Decode(&preq); err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to decode request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if preq.Points < 1 {
+		httpJSONError(w, "The points must be at least 1", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.tasks.update(taskName, func(t *Task) error {
+		t.Points = preq.Points
+		return nil
+	}); err != nil {
+		httpJSONError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Printf("Task %v is now worth %v points, set by %v", taskName, preq.Points, u.Username)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Dispatches the admin requests of a specific submission (e.g. /admin/submissions/<id>/source).
+func (s *Server) adminSubmissionHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	parts := splitPath(req.URL.Path, "/admin/submissions/")
+	if len(parts) == 2 && parts[1] == "source" {
+		s.submissionSourceHTTPHandler(w, req, parts[0])
+		return
+	}
+	if len(parts) == 2 && parts[1] == "logs" {
+		s.submissionLogsHTTPHandler(w, req, parts[0])
+		return
+	}
+	httpJSONError(w, "Not found", http.StatusNotFound)
+}
+
+// Handles downloading the source archive of a submission.
+func (s *Server) submissionSourceHTTPHandler(w http.ResponseWriter, req *http.Request, id string) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if _, ok := s.authenticateAdmin(w, req); !ok {
+		return
+	}
+
+	source, err := getSubmissionSource(s.db, id)
+	if err == sql.ErrNoRows {
+		httpJSONError(w, fmt.Sprintf("Submission %v not found", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to fetch submission source: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".zip"))
+	w.WriteHeader(http.StatusOK)
+	w.Write(source)
+}
+
+// Handles reading the logs of a submission. The logs of the current container of
+// a running submission are streamed until it dies, otherwise the saved logs of the
+// submission's last container are returned.
+func (s *Server) submissionLogsHTTPHandler(w http.ResponseWriter, req *http.Request, id string) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	admin, ok := s.authenticateAdmin(w, req)
+	if !ok {
+		return
+	}
+
+	if sub, ok := s.runningSubmissions.get(id); ok && sub.Executor.containerID() != "" {
+		if !s.acquireStream(w, admin.Username) {
+			return
+		}
+		defer s.userStreams.release(admin.Username)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		// The logs are streamed through a bounded buffer so that a slow client is
+		// disconnected instead of holding the stream from the docker daemon.
+		sb := newStreamBuffer(s.StreamBufferBytes, s.StreamWriteTimeout)
+		ctx, cancel := context.WithCancel(req.Context())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			err := s.docker().Logs(docker.LogsOptions{
+				Context:      ctx,
+				Container:    sub.Executor.containerID(),
+				OutputStream: sb,
+				ErrorStream:  sb,
+				Stdout:       true,
+				Stderr:       true,
+				Follow:       true,
+				Tail:         "all",
+			})
+			if err != nil && err != errSlowClient {
+				log.Printf("Failed to stream the logs of submission %v: %v", id, err)
+			}
+			sb.close(nil)
+		}()
+		err := sb.consume(newFlushWriter(w))
+		cancel()
+		<-done
+		if err == errSlowClient {
+			log.Printf("Disconnected %v from the logs of submission %v: %v", admin.Username, id, err)
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+
+	stdout, stderr, err := getSubmissionLogs(s.db, id)
+	if err == sql.ErrNoRows {
+		httpJSONError(w, fmt.Sprintf("Logs of submission %v not found", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to fetch submission logs: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, stdout)
+	fmt.Fprint(w, stderr)
+}
+
+// BudgetRequest represents the request to override the submission budget of a user.
+// Zero means unlimited.
+type BudgetRequest struct {
+	Budget int `json:"budget"`
+}
+
+// Handles overriding the submission budget of a user.
+func (s *Server) budgetHTTPHandler(w http.ResponseWriter, req *http.Request, username string) {
+	if req.Method != http.MethodPost {
+		httpMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	admin, ok := s.authenticateAdmin(w, req)
+	if !ok {
+		return
+	}
+
+	var breq BudgetRequest
+	if err := json.NewDecoder(req.Body).Decode(&breq); err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to decode request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if breq.Budget < 0 {
+		httpJSONError(w, "The budget can't be negative", http.StatusBadRequest)
+		return
+	}
+	if _, err := userQ.find(s.db, username); err == sql.ErrNoRows {
+		httpJSONError(w, fmt.Sprintf("User %v not found", username), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to fetch user: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := setSubmissionBudget(s.db, username, breq.Budget); err != nil {
+		httpJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Submission budget of %v set to %v by %v", username, breq.Budget, admin.Username)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Handles the requests of the aggregated stats of a user.
+func (s *Server) userStatsHTTPHandler(w http.ResponseWriter, req *http.Request, username string) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if _, ok := s.authenticateAdmin(w, req); !ok {
+		return
+	}
+
+	if _, err := userQ.find(s.db, username); err == sql.ErrNoRows {
+		httpJSONError(w, fmt.Sprintf("User %v not found", username), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to fetch user: %v", err), http.StatusInternalServerError)
+		return
+	}
+	stats, err := getUserStats(s.db, username)
+	if err != nil {
+		httpJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		httpJSONError(w, "Failed to encode stats", http.StatusInternalServerError)
+		return
+	}
+}
+
+// WorkersRequest represents the request to change the number of workers
+// processing the submissions. It's also the response of the workers endpoint.
+type WorkersRequest struct {
+	Count int `json:"count"`
+}
+
+// Handles reading and scaling the number of workers.
+func (s *Server) workersHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		httpMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return
+	}
+	u, ok := s.authenticateAdmin(w, req)
+	if !ok {
+		return
+	}
+
+	if req.Method == http.MethodPost {
+		var wreq WorkersRequest
+		if err := json.NewDecoder(req.Body).Decode(&wreq); err != nil {
+			httpJSONError(w, fmt.Sprintf("Failed to decode request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if wreq.Count < 1 {
+			httpJSONError(w, "The number of workers must be at least 1", http.StatusBadRequest)
+			return
+		}
+		s.workers.set(wreq.Count, s.processSubmissions)
+		log.Printf("Workers scaled to %v by %v", wreq.Count, u.Username)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(WorkersRequest{Count: s.workers.size()}); err != nil {
+		httpJSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,326 @@
+package godge
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestWorkersScaledUpAndDown(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.Admins = []string{testUsername}
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	for _, count := range []int{4, 2} {
+		var resp WorkersRequest
+		body := []byte(fmt.Sprintf(`{"count": %v}`, count))
+		if code := s.do(t, s.workersHTTPHandler, http.MethodPost, "/admin/workers", body, nil, &resp); code != http.StatusOK {
+			t.Fatalf("Scaling the workers returned %v, want %v", code, http.StatusOK)
+		}
+		if resp.Count != count || s.workers.size() != count {
+			t.Errorf("Got %v workers (%v running), want %v", resp.Count, s.workers.size(), count)
+		}
+		var sresp SubmissionResponse
+		if code := s.do(t, s.submitHTTPHandler, http.MethodPost, "/submit", submission(t, "echo"), nil, &sresp); code != http.StatusOK || !sresp.Passed {
+			t.Errorf("Submit with %v workers returned %v %+v, want a passed submission", count, code, sresp)
+		}
+	}
+	if code := s.do(t, s.workersHTTPHandler, http.MethodPost, "/admin/workers", []byte(`{"count": 0}`), nil, nil); code != http.StatusBadRequest {
+		t.Errorf("Scaling to no workers returned %v, want %v", code, http.StatusBadRequest)
+	}
+}
+
+func TestSubmissionsAcceptedByTaskState(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.Admins = []string{testUsername}
+	if err := s.RegisterTask(Task{Name: "echo", State: TaskDraft, Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	for _, c := range []struct {
+		state TaskState
+		want  int
+	}{
+		{TaskDraft, http.StatusForbidden},
+		{TaskOpen, http.StatusOK},
+		{TaskClosed, http.StatusForbidden},
+	} {
+		if c.state != TaskDraft {
+			body := []byte(fmt.Sprintf(`{"state": %q}`, c.state))
+			if code := s.do(t, s.adminTaskHTTPHandler, http.MethodPost, "/admin/tasks/echo/state", body, nil, nil); code != http.StatusOK {
+				t.Fatalf("Moving the task to %v returned %v, want %v", c.state, code, http.StatusOK)
+			}
+		}
+		if code, _ := s.submit(t, testUsername, submission(t, "echo")); code != c.want {
+			t.Errorf("Submit to the %v task returned %v, want %v", c.state, code, c.want)
+		}
+	}
+	if code := s.do(t, s.adminTaskHTTPHandler, http.MethodPost, "/admin/tasks/echo/state", []byte(`{"state": "draft"}`), nil, nil); code != http.StatusBadRequest {
+		t.Errorf("Moving the closed task back to draft returned %v, want %v", code, http.StatusBadRequest)
+	}
+}
+
+func TestAdminDownloadsSubmissionSource(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.addUser(t, "bob")
+	s.Admins = []string{testUsername}
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	code, resp := s.submit(t, "bob", submission(t, "echo"))
+	if code != http.StatusOK {
+		t.Fatalf("Submit returned %v, want %v", code, http.StatusOK)
+	}
+	s.submissionsOf(t, "bob", 1, "")
+
+	url := "/admin/submissions/" + resp.ID + "/source"
+	w := serve(s.adminSubmissionHTTPHandler, testUsername, http.MethodGet, url, nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Downloading the source returned %v, want %v", w.Code, http.StatusOK)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("Failed to open the source archive: %v", err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "main.go" {
+		t.Fatalf("Got the source archive files %v, want main.go", zr.File)
+	}
+	f, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatalf("Failed to open main.go: %v", err)
+	}
+	defer f.Close()
+	if src, err := ioutil.ReadAll(f); err != nil || string(src) != "package main\n\nfunc main() {}\n" {
+		t.Errorf("Got the source %q (%v), want the submitted main.go", src, err)
+	}
+
+	if w := serve(s.adminSubmissionHTTPHandler, "bob", http.MethodGet, url, nil, nil); w.Code != http.StatusForbidden {
+		t.Errorf("Downloading the source as a regular user returned %v, want %v", w.Code, http.StatusForbidden)
+	}
+	if w := serve(s.adminSubmissionHTTPHandler, testUsername, http.MethodGet, "/admin/submissions/missing/source", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Downloading the source of a missing submission returned %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestAdminReadsSubmissionLogs(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.addUser(t, "bob")
+	s.Admins = []string{testUsername}
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	code, resp := s.submit(t, "bob", submission(t, "echo"))
+	if code != http.StatusOK {
+		t.Fatalf("Submit returned %v, want %v", code, http.StatusOK)
+	}
+
+	url := "/admin/submissions/" + resp.ID + "/logs"
+	w := serve(s.adminSubmissionHTTPHandler, testUsername, http.MethodGet, url, nil, nil)
+	if w.Code != http.StatusOK || w.Body.String() != "hello" {
+		t.Errorf("Reading the logs returned %v %q, want %v %q", w.Code, w.Body.String(), http.StatusOK, "hello")
+	}
+	if w := serve(s.adminSubmissionHTTPHandler, "bob", http.MethodGet, url, nil, nil); w.Code != http.StatusForbidden {
+		t.Errorf("Reading the logs as a regular user returned %v, want %v", w.Code, http.StatusForbidden)
+	}
+	if w := serve(s.adminSubmissionHTTPHandler, testUsername, http.MethodGet, "/admin/submissions/missing/logs", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Reading the logs of a missing submission returned %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestAdminUserStats(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.Admins = []string{testUsername}
+	s.addUser(t, "bob")
+	s.addUser(t, "carl")
+	for _, task := range []Task{
+		{Name: "echo", Tests: []Test{outputTest("hello", "hello")}},
+		{Name: "bye", Tests: []Test{outputTest("bye", "bye")}},
+	} {
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+	// bob solves echo twice and fails bye.
+	for _, task := range []string{"echo", "bye", "echo"} {
+		if code, _ := s.submit(t, "bob", submission(t, task)); code != http.StatusOK {
+			t.Fatalf("Submit to %v returned %v, want %v", task, code, http.StatusOK)
+		}
+	}
+	s.submissionsOf(t, "bob", 3, "")
+
+	var stats UserStatsResponse
+	if code := s.do(t, s.adminUserHTTPHandler, http.MethodGet, "/admin/users/bob/stats", nil, nil, &stats); code != http.StatusOK {
+		t.Fatalf("User stats returned %v, want %v", code, http.StatusOK)
+	}
+	if stats.Username != "bob" || stats.Submissions != 3 || stats.Solves != 1 || stats.Failures != 1 || !reflect.DeepEqual(stats.Languages, map[string]int{"go": 3}) || stats.LastActive == nil {
+		t.Errorf("Got the stats %+v of bob, want 3 go submissions, a solve and a failure", stats)
+	}
+
+	stats = UserStatsResponse{}
+	if code := s.do(t, s.adminUserHTTPHandler, http.MethodGet, "/admin/users/carl/stats", nil, nil, &stats); code != http.StatusOK {
+		t.Fatalf("User stats returned %v, want %v", code, http.StatusOK)
+	}
+	if want := (UserStatsResponse{Username: "carl", Languages: map[string]int{}}); !reflect.DeepEqual(stats, want) {
+		t.Errorf("Got the stats %+v of a user without submissions, want %+v", stats, want)
+	}
+
+	if code := s.do(t, s.adminUserHTTPHandler, http.MethodGet, "/admin/users/dan/stats", nil, nil, nil); code != http.StatusNotFound {
+		t.Errorf("Stats of an unknown user returned %v, want %v", code, http.StatusNotFound)
+	}
+	if code := s.doAs(t, "bob", s.adminUserHTTPHandler, http.MethodGet, "/admin/users/bob/stats", nil, nil, nil); code != http.StatusForbidden {
+		t.Errorf("Stats requested by a non admin returned %v, want %v", code, http.StatusForbidden)
+	}
+}
+
+func TestAdminTaskPointsUpdateTotals(t *testing.T) {
+	dc := &fakeDocker{stdout: "hello"}
+	s := newTestServer(t, dc)
+	defer s.close()
+	s.Admins = []string{testUsername}
+	for _, task := range []Task{
+		{Name: "echo", Tests: []Test{outputTest("hello", "hello")}},
+		{Name: "half", PartialCredit: true, Tests: []Test{outputTest("hello", "hello"), outputTest("bye", "bye")}},
+	} {
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+	for _, task := range []string{"echo", "half"} {
+		if code, _ := s.submit(t, testUsername, submission(t, task)); code != http.StatusOK {
+			t.Fatalf("Submit to %v returned %v, want %v", task, code, http.StatusOK)
+		}
+	}
+	s.submissions(t, 2, "")
+	if score := s.scoreboardOf(t, "").Rows[0].Score; score != 2 {
+		t.Fatalf("Got the score %v, want a point for echo and half of the 2 points of half", score)
+	}
+	containers := dc.createdContainers()
+
+	if code := s.do(t, s.adminTaskHTTPHandler, http.MethodPost, "/admin/tasks/half/points", []byte(`{"points": 10}`), nil, nil); code != http.StatusOK {
+		t.Fatalf("Changing the points returned %v, want %v", code, http.StatusOK)
+	}
+	resp := s.scoreboardOf(t, "")
+	if cell := resp.Rows[0].Cells[1]; cell.Points != 5 || cell.MaxPoints != 10 {
+		t.Errorf("Got the cell %+v of half, want 5 of the 10 points", cell)
+	}
+	if score := resp.Rows[0].Score; score != 6 {
+		t.Errorf("Got the score %v after the change, want 6", score)
+	}
+	if got := dc.createdContainers(); got != containers || s.count(t, "scoreboard") != 2 {
+		t.Errorf("Got %v containers and %v submissions after the change, want the submissions not judged again", got, s.count(t, "scoreboard"))
+	}
+
+	for _, c := range []struct {
+		url, body string
+		want      int
+	}{
+		{"/admin/tasks/half/points", `{"points": 0}`, http.StatusBadRequest},
+		{"/admin/tasks/nope/points", `{"points": 3}`, http.StatusNotFound},
+	} {
+		if code := s.do(t, s.adminTaskHTTPHandler, http.MethodPost, c.url, []byte(c.body), nil, nil); code != c.want {
+			t.Errorf("Changing the points at %v to %v returned %v, want %v", c.url, c.body, code, c.want)
+		}
+	}
+}
+
+func TestExportContest(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.addUser(t, "bob")
+	s.Admins = []string{testUsername}
+	for _, task := range []Task{
+		{Name: "echo", Tests: []Test{outputTest("hello", "hello")}},
+		{Name: "bye", Tests: []Test{outputTest("bye", "bye")}},
+	} {
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+	if code, _ := s.submit(t, "bob", submission(t, "echo")); code != http.StatusOK {
+		t.Fatalf("Submit returned %v, want %v", code, http.StatusOK)
+	}
+	if code, _ := s.submit(t, "bob", submission(t, "bye")); code != http.StatusOK {
+		t.Fatalf("Submit returned %v, want %v", code, http.StatusOK)
+	}
+	s.submissionsOf(t, "bob", 2, "")
+
+	w := serve(s.exportHTTPHandler, testUsername, http.MethodGet, "/admin/export?sources=true", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Export returned %v, want %v", w.Code, http.StatusOK)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read the archive: %v", err)
+	}
+	entries := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %v: %v", f.Name, err)
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("Failed to read %v: %v", f.Name, err)
+		}
+		entries[f.Name] = content
+	}
+	decode := func(name string, v interface{}) {
+		content, ok := entries[name]
+		if !ok {
+			t.Fatalf("The archive has no %v", name)
+		}
+		if err := json.Unmarshal(content, v); err != nil {
+			t.Fatalf("Failed to parse %v: %v", name, err)
+		}
+	}
+
+	var tasks []Task
+	decode("tasks.json", &tasks)
+	if len(tasks) != 2 || tasks[0].Name != "bye" || tasks[1].Name != "echo" {
+		t.Errorf("Got tasks %+v, want bye and echo", tasks)
+	}
+	var users []string
+	decode("users.json", &users)
+	if want := []string{testUsername, "bob"}; !reflect.DeepEqual(users, want) {
+		t.Errorf("Got users %v, want %v", users, want)
+	}
+	var scoreboard ScoreboardResponse
+	decode("scoreboard.json", &scoreboard)
+	if want := []string{"bob", testUsername}; !reflect.DeepEqual(scoreboard.Users, want) {
+		t.Errorf("Got the scoreboard users %v, want %v", scoreboard.Users, want)
+	}
+	var subs []ExportedSubmission
+	decode("submissions.json", &subs)
+	if len(subs) != 2 {
+		t.Fatalf("Got submissions %+v, want 2", subs)
+	}
+	for i, want := range []struct{ task, verdict string }{{"echo", passedVerdict}, {"bye", failedVerdict}} {
+		if subs[i].Username != "bob" || subs[i].TaskName != want.task || subs[i].Verdict != want.verdict {
+			t.Errorf("Got submission %+v, want bob's %v submission to %v", subs[i], want.verdict, want.task)
+		}
+		source, ok := entries["sources/"+subs[i].ID+".zip"]
+		if !ok {
+			t.Errorf("The archive has no source of %v", subs[i].ID)
+			continue
+		}
+		if _, err := zip.NewReader(bytes.NewReader(source), int64(len(source))); err != nil {
+			t.Errorf("Failed to read the source of %v: %v", subs[i].ID, err)
+		}
+	}
+	if len(entries) != 6 {
+		t.Errorf("Got %v entries in the archive, want 6", len(entries))
+	}
+}
@@ -3,15 +3,25 @@ package godge
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
 	"sync"
+	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
 )
 
 // Executor is used to interact with the submission.
 type Executor interface {
-	setDockerClient(*docker.Client)
+	setDockerClient(dockerAPI)
+	configure(executorConfig)
 	containerID() string
+	// Returns the submitted source (e.g. the zip archive of the package).
+	source() []byte
 	// Excutes the submitted code with the provided arguments.
 	Execute(args []string) error
 	// Reads a certain file from the container's workspace.
@@ -26,62 +36,320 @@ type Executor interface {
 	StartEvent() chan struct{}
 	// A channels that gets signaled when the container dies.
 	DieEvent() chan struct{}
+	// Returns the exit code of the last exited execution.
+	exitCode() int
+	// Returns the resources used by all the executions of the submission so far.
+	ResourceUsage() ResourceUsage
+	// Releases the resources held by the executor once the submission is judged.
+	cleanup()
+}
+
+// ResourceUsage represents the resources used while executing a submission. It's
+// exposed to be used by the command line client.
+type ResourceUsage struct {
+	// The maximum memory used by any of the executions in bytes.
+	MaxMemoryBytes uint64 `json:"maxMemoryBytes" xml:"maxMemoryBytes"`
+	// The total CPU time used by all the executions.
+	CPUTime time.Duration `json:"cpuTime" xml:"cpuTime"`
+}
+
+// executorConfig holds the server and task specific configuration of the executors.
+type executorConfig struct {
+	// The image used to run the submission. If empty, the executor's default image is used.
+	image string
+	// The log driver of the containers. The executor's default is used if nil.
+	logConfig *docker.LogConfig
+	// Extra host config fields applied on top of the executor's own host config.
+	hostConfig *docker.HostConfig
+	// A command run in a separate container sharing the workspace before the execution.
+	setup []string
+	// The maximum size of the compiler output. Unlimited if zero.
+	maxCompileOutputBytes int64
+	// The resource limits of the containers.
+	limits Limits
+	// The tmpfs mounts of the containers by path, with their mount options (e.g. "rw,noexec").
+	tmpfs map[string]string
 }
 
 type baseExecutor struct {
-	dockerClient *docker.Client
-	container    *docker.Container
+	dockerClient dockerAPI
+	config       executorConfig
 	workDir      string
 	stoppedOnce  sync.Once
-	startEvent   chan struct{}
-	dieEvent     chan struct{}
+
+	// Guards the fields below which are read by the docker events forwarder. They
+	// are only written by the executing goroutine, which reads them without locking.
+	containerMu sync.RWMutex
+	container   *docker.Container
+	startEvent  chan struct{}
+	dieEvent    chan struct{}
+
+	// Closed to stop watching the stats of the current container.
+	statsDone chan bool
+	// Guards the fields below which are updated by the stats watchers.
+	usageMu        sync.Mutex
+	maxMemoryBytes uint64
+	// The CPU time in nanoseconds used by each of the executions' containers.
+	cpuUsage map[string]uint64
+
+	// The temp dirs on the host staging the files of the executions. They are unique
+	// to the submission and removed once it's judged.
+	tmpDirs []string
+
+	// The containers killed (and removed) because they exceeded the time limit.
+	timedOutMu sync.Mutex
+	timedOut   map[string]bool
+
+	// The ID and the final state of the last exited container.
+	exitedID    string
+	exitedState docker.State
+}
+
+// errTimeLimitExceeded is returned when reading the output of an execution that
+// exceeded the task's time limit.
+var errTimeLimitExceeded = fmt.Errorf("time limit exceeded")
+
+// errMemoryLimitExceeded is returned when reading the output of an execution that
+// was killed for exceeding the task's memory limit.
+var errMemoryLimitExceeded = fmt.Errorf("memory limit exceeded")
+
+// errCompilationFailed is returned when reading the output of an execution whose
+// submission failed to compile. The compiler output is in the stderr.
+var errCompilationFailed = fmt.Errorf("compilation failed")
+
+// errCompileOutputTooLarge is returned when reading the output of an execution whose
+// compiler output exceeded the server's MaxCompileOutputBytes.
+var errCompileOutputTooLarge = fmt.Errorf("compile output too large")
+
+// The files created in the container by the executors' scripts when the submission
+// fails to compile, and when its compiler output is too large.
+const (
+	compileFailedMarker   = "/tmp/godge-compile-failed"
+	compileTooLargeMarker = "/tmp/godge-compile-too-large"
+)
+
+// checkExit returns the error describing why the current container exited
+// abnormally (e.g. errMemoryLimitExceeded), if it exited.
+func (b *baseExecutor) checkExit() error {
+	if b.container == nil {
+		return nil
+	}
+	id := b.container.ID
+	if b.exitedID != id {
+		c, err := b.dockerClient.InspectContainer(id)
+		if err != nil {
+			return infraErrorf("failed to inspect container: %v", err)
+		}
+		if c.State.Running {
+			return nil
+		}
+		b.exitedID, b.exitedState = id, c.State
+	}
+	if b.exitedState.OOMKilled {
+		return errMemoryLimitExceeded
+	}
+	if b.exitedState.ExitCode != 0 {
+		if b.hasFile(compileTooLargeMarker) {
+			return errCompileOutputTooLarge
+		}
+		if b.hasFile(compileFailedMarker) {
+			return errCompilationFailed
+		}
+	}
+	return nil
+}
+
+// hasFile reports whether the file exists in the current container.
+func (b *baseExecutor) hasFile(path string) bool {
+	err := b.dockerClient.DownloadFromContainer(b.container.ID, docker.DownloadFromContainerOptions{
+		OutputStream: ioutil.Discard,
+		Path:         path,
+	})
+	return err == nil
+}
+
+// exitCode returns the exit code of the last exited container.
+func (b *baseExecutor) exitCode() int {
+	return b.exitedState.ExitCode
 }
 
 // init must be called as the first statement for any executor.
 func (b *baseExecutor) init() {
+	b.stopWatchingStats()
+	b.containerMu.Lock()
 	b.container = nil
 	b.startEvent = make(chan struct{}, 10)
 	b.dieEvent = make(chan struct{}, 10)
+	b.containerMu.Unlock()
 	b.stoppedOnce = sync.Once{}
 }
 
+// setContainer sets the current container of the executor.
+func (b *baseExecutor) setContainer(c *docker.Container) {
+	b.containerMu.Lock()
+	defer b.containerMu.Unlock()
+	b.container = c
+}
+
 // StartEvent returns a channel that gets signaled when the container starts.
 func (b *baseExecutor) StartEvent() chan struct{} {
+	b.containerMu.RLock()
+	defer b.containerMu.RUnlock()
 	return b.startEvent
 }
 
 // DieEvent returns a channel that gets signaled when the container dies.
 func (b *baseExecutor) DieEvent() chan struct{} {
+	b.containerMu.RLock()
+	defer b.containerMu.RUnlock()
 	return b.dieEvent
 }
 
 func (b *baseExecutor) containerID() string {
+	b.containerMu.RLock()
+	defer b.containerMu.RUnlock()
 	if b.container == nil {
 		return ""
 	}
 	return b.container.ID
 }
 
-func (b *baseExecutor) setDockerClient(d *docker.Client) {
+func (b *baseExecutor) setDockerClient(d dockerAPI) {
 	b.dockerClient = d
 }
 
+func (b *baseExecutor) configure(c executorConfig) {
+	b.config = c
+}
+
+// image returns the configured image or def if there is none.
+func (b *baseExecutor) image(def string) string {
+	if b.config.image != "" {
+		return b.config.image
+	}
+	return def
+}
+
+// verifyImageDigest makes sure that the container is running the image pinned by
+// digest (e.g. golang@sha256:...). Images that are not pinned are not verified.
+func (b *baseExecutor) verifyImageDigest(image string) error {
+	i := strings.Index(image, "@")
+	if i < 0 {
+		return nil
+	}
+	digest := image[i+1:]
+	img, err := b.dockerClient.InspectImage(image)
+	if err != nil {
+		return infraErrorf("failed to inspect image %v: %v", image, err)
+	}
+	var pinned bool
+	for _, d := range img.RepoDigests {
+		if strings.HasSuffix(d, "@"+digest) {
+			pinned = true
+		}
+	}
+	if !pinned || img.ID != b.container.Image {
+		return infraErrorf("container image %v doesn't match the pinned digest %v", b.container.Image, digest)
+	}
+	return nil
+}
+
+// validateImage returns an error if the image is pinned by an invalid digest.
+func validateImage(image string) error {
+	i := strings.Index(image, "@")
+	if i < 0 {
+		return nil
+	}
+	if !imageDigestRegexp.MatchString(image[i+1:]) {
+		return fmt.Errorf("invalid image digest %v, expected sha256:<64 hex chars>", image[i+1:])
+	}
+	return nil
+}
+
+var imageDigestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// hostConfig returns the given host config after applying the configured log driver,
+// limits, tmpfs mounts and overrides. Slices (e.g. Binds) are appended to, other fields are replaced.
+func (b *baseExecutor) hostConfig(hc *docker.HostConfig) *docker.HostConfig {
+	if b.config.logConfig != nil {
+		hc.LogConfig = *b.config.logConfig
+	}
+	if b.config.limits.Memory > 0 {
+		hc.Memory = b.config.limits.Memory
+	}
+	if b.config.limits.CPUShares > 0 {
+		hc.CPUShares = b.config.limits.CPUShares
+	}
+	if b.config.limits.Pids > 0 {
+		hc.PidsLimit = b.config.limits.Pids
+	}
+	if len(b.config.tmpfs) > 0 {
+		hc.Tmpfs = b.config.tmpfs
+	}
+	if b.config.hostConfig == nil {
+		return hc
+	}
+	dst := reflect.ValueOf(hc).Elem()
+	src := reflect.ValueOf(b.config.hostConfig).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		f := src.Field(i)
+		if isZeroValue(f) {
+			continue
+		}
+		if f.Kind() == reflect.Slice {
+			dst.Field(i).Set(reflect.AppendSlice(dst.Field(i), f))
+			continue
+		}
+		dst.Field(i).Set(f)
+	}
+	return hc
+}
+
+// validateHostConfig returns an error if any of the set fields of the host config
+// is not in the allowed list.
+func validateHostConfig(hc *docker.HostConfig, allowed []string) error {
+	v := reflect.ValueOf(hc).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		if !isZeroValue(v.Field(i)) && !containsString(allowed, name) {
+			return fmt.Errorf("host config field %v is not allowed", name)
+		}
+	}
+	return nil
+}
+
+func isZeroValue(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
 // ReadFileFromContainer reads a certain file from the container's workspace. The path
 // is relative to the container's workdir.
 func (b *baseExecutor) ReadFileFromContainer(path string) (string, error) {
+	if b.isTimedOut() {
+		return "", errTimeLimitExceeded
+	}
+	if err := b.checkExit(); err != nil {
+		return "", err
+	}
 	buf := new(bytes.Buffer)
 	option := docker.DownloadFromContainerOptions{
 		OutputStream: buf,
 		Path:         fmt.Sprintf("%v/%v", b.workDir, path),
 	}
 	if err := b.dockerClient.DownloadFromContainer(b.container.ID, option); err != nil {
-		return "", fmt.Errorf("failed to read file from container: %v", err)
+		return "", infraErrorf("failed to read file from container: %v", err)
 	}
 	return string(buf.Bytes()), nil
 }
 
 // Stdout returns the content of the stdout of the container.
 func (b *baseExecutor) Stdout() (string, error) {
+	if b.isTimedOut() {
+		return "", errTimeLimitExceeded
+	}
+	if err := b.checkExit(); err != nil {
+		return "", err
+	}
 	buf := new(bytes.Buffer)
 	option := docker.LogsOptions{
 		OutputStream: buf,
@@ -90,13 +358,16 @@ func (b *baseExecutor) Stdout() (string, error) {
 		Tail:         "all",
 	}
 	if err := b.dockerClient.Logs(option); err != nil {
-		return "", fmt.Errorf("failed to read stdout from container: %v", err)
+		return "", infraErrorf("failed to read stdout from container: %v", err)
 	}
 	return string(buf.Bytes()), nil
 }
 
 // Stderr returns the content of the stderr of the container.
 func (b *baseExecutor) Stderr() (string, error) {
+	if b.isTimedOut() {
+		return "", errTimeLimitExceeded
+	}
 	buf := new(bytes.Buffer)
 	option := docker.LogsOptions{
 		ErrorStream: buf,
@@ -105,17 +376,203 @@ func (b *baseExecutor) Stderr() (string, error) {
 		Tail:        "all",
 	}
 	if err := b.dockerClient.Logs(option); err != nil {
-		return "", fmt.Errorf("failed to read stderr from container: %v", err)
+		return "", infraErrorf("failed to read stderr from container: %v", err)
 	}
 	return string(buf.Bytes()), nil
 }
 
+// watchStats records the resource usage of the current container until it's removed
+// or stopWatchingStats is called. It must be called after the container is started.
+func (b *baseExecutor) watchStats() {
+	id := b.container.ID
+	stats := make(chan *docker.Stats)
+	b.statsDone = make(chan bool)
+	go func() {
+		for st := range stats {
+			b.usageMu.Lock()
+			if st.MemoryStats.MaxUsage > b.maxMemoryBytes {
+				b.maxMemoryBytes = st.MemoryStats.MaxUsage
+			}
+			if b.cpuUsage == nil {
+				b.cpuUsage = make(map[string]uint64)
+			}
+			// Stopped containers report zero usage, so only keep the highest value.
+			if st.CPUStats.CPUUsage.TotalUsage > b.cpuUsage[id] {
+				b.cpuUsage[id] = st.CPUStats.CPUUsage.TotalUsage
+			}
+			b.usageMu.Unlock()
+		}
+	}()
+	go b.dockerClient.Stats(docker.StatsOptions{
+		ID:     id,
+		Stats:  stats,
+		Stream: true,
+		Done:   b.statsDone,
+	})
+}
+
+func (b *baseExecutor) stopWatchingStats() {
+	if b.statsDone != nil {
+		close(b.statsDone)
+		b.statsDone = nil
+	}
+}
+
+// enforceTimeout kills and removes the current container if it's still running when
+// the time limit of the task elapses. It must be called after the container is started.
+func (b *baseExecutor) enforceTimeout() {
+	timeout := b.config.limits.Timeout
+	if timeout <= 0 {
+		return
+	}
+	id := b.container.ID
+	dc := b.dockerClient
+	exited := make(chan struct{})
+	go func() {
+		dc.WaitContainer(id)
+		close(exited)
+	}()
+	go func() {
+		select {
+		case <-exited:
+			return
+		case <-time.After(timeout):
+		}
+		// Mark the container before killing it, so that the output is reported as
+		// timed out once the test receives the die event.
+		b.timedOutMu.Lock()
+		if b.timedOut == nil {
+			b.timedOut = make(map[string]bool)
+		}
+		b.timedOut[id] = true
+		b.timedOutMu.Unlock()
+		if err := dc.KillContainer(docker.KillContainerOptions{ID: id}); err != nil {
+			log.Printf("Failed to kill timed out container %v: %v", id, err)
+		}
+		if err := dc.RemoveContainer(docker.RemoveContainerOptions{ID: id, Force: true}); err != nil {
+			log.Printf("Failed to remove timed out container %v: %v", id, err)
+		}
+	}()
+}
+
+// isTimedOut reports whether the current container was killed because of the time limit.
+func (b *baseExecutor) isTimedOut() bool {
+	if b.container == nil {
+		return false
+	}
+	b.timedOutMu.Lock()
+	defer b.timedOutMu.Unlock()
+	return b.timedOut[b.container.ID]
+}
+
+// runSetup runs the configured setup command to completion in a separate container
+// using the same image and host config as the submission's container. The setup
+// is killed once the time limit of the task elapses. Nothing is run if there is no
+// setup command.
+func (b *baseExecutor) runSetup(image, workDir string, hc *docker.HostConfig) error {
+	if len(b.config.setup) == 0 {
+		return nil
+	}
+	c, err := b.dockerClient.CreateContainer(docker.CreateContainerOptions{
+		Name: randomString(20),
+		Config: &docker.Config{
+			Image:      image,
+			Cmd:        b.config.setup,
+			WorkingDir: workDir,
+			Labels:     containerLabels,
+		},
+		HostConfig: hc,
+	})
+	if err != nil {
+		return infraErrorf("failed to create setup container: %v", err)
+	}
+	defer b.dockerClient.RemoveContainer(docker.RemoveContainerOptions{ID: c.ID, Force: true})
+	if err := b.dockerClient.StartContainer(c.ID, nil); err != nil {
+		return infraErrorf("failed to start setup container: %v", err)
+	}
+	code, err := b.waitSetup(c.ID)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return &environmentError{err: fmt.Errorf("setup command %v exited with code %v", b.config.setup, code)}
+	}
+	return nil
+}
+
+// waitSetup waits for the setup container to exit and returns its exit code. It's
+// killed if it's still running when the time limit of the task elapses.
+func (b *baseExecutor) waitSetup(id string) (int, error) {
+	type exit struct {
+		code int
+		err  error
+	}
+	// Buffered so that the waiting goroutine exits once the killed container exits.
+	exited := make(chan exit, 1)
+	go func() {
+		code, err := b.dockerClient.WaitContainer(id)
+		exited <- exit{code, err}
+	}()
+	var timeout <-chan time.Time
+	if b.config.limits.Timeout > 0 {
+		timeout = time.After(b.config.limits.Timeout)
+	}
+	select {
+	case e := <-exited:
+		if e.err != nil {
+			return 0, infraErrorf("failed to wait for setup container: %v", e.err)
+		}
+		return e.code, nil
+	case <-timeout:
+		if err := b.dockerClient.KillContainer(docker.KillContainerOptions{ID: id}); err != nil {
+			log.Printf("Failed to kill timed out setup container %v: %v", id, err)
+		}
+		return 0, &environmentError{err: fmt.Errorf("setup command %v exceeded the time limit of %v", b.config.setup, b.config.limits.Timeout)}
+	}
+}
+
+// ResourceUsage returns the resources used by all the executions of the submission so far.
+func (b *baseExecutor) ResourceUsage() ResourceUsage {
+	b.usageMu.Lock()
+	defer b.usageMu.Unlock()
+	var cpu uint64
+	for _, c := range b.cpuUsage {
+		cpu += c
+	}
+	return ResourceUsage{
+		MaxMemoryBytes: b.maxMemoryBytes,
+		CPUTime:        time.Duration(cpu),
+	}
+}
+
+func (b *baseExecutor) cleanup() {
+	b.stopWatchingStats()
+	for _, d := range b.tmpDirs {
+		if err := os.RemoveAll(d); err != nil {
+			log.Printf("Failed to remove tmp dir %v: %v", d, err)
+		}
+	}
+	b.tmpDirs = nil
+}
+
+// unzipToTmpDir extracts the archive to a new temp dir that's removed on cleanup.
+func (b *baseExecutor) unzipToTmpDir(archive []byte) (string, error) {
+	dir, err := unzipToTmpDir(archive)
+	if dir != "" {
+		b.tmpDirs = append(b.tmpDirs, dir)
+	}
+	return dir, err
+}
+
 // Stop stops the running binary.
 func (b *baseExecutor) Stop() error {
 	var err error
 	b.stoppedOnce.Do(func() {
+		if b.isTimedOut() {
+			return
+		}
 		if err = b.dockerClient.StopContainer(b.container.ID, 2); err != nil {
-			err = fmt.Errorf("failed to stop container: %v", err)
+			err = infraErrorf("failed to stop container: %v", err)
 			return
 		}
 	})
@@ -0,0 +1,420 @@
+package godge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestConcurrentSubmissionsUseIsolatedTmpDirs(t *testing.T) {
+	dc := &fakeDocker{stdout: "hello"}
+	s := newTestServer(t, dc)
+	defer s.close()
+	s.workers.set(4, s.processSubmissions)
+	const n = 8
+	var mu sync.Mutex
+	var contents [][]os.FileInfo
+	for i := 0; i < n; i++ {
+		test := outputTest("hello", "hello")
+		run := test.Func
+		// The workspace is inspected while the submission is judged, before it's
+		// cleaned up.
+		test.Func = func(sub *Submission) error {
+			if err := run(sub); err != nil {
+				return err
+			}
+			files, err := ioutil.ReadDir(sub.Executor.(*GoExecutor).tmpDirs[0])
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			contents = append(contents, files)
+			mu.Unlock()
+			return nil
+		}
+		if err := s.RegisterTask(Task{Name: fmt.Sprintf("echo%v", i), Tests: []Test{test}}); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var resp SubmissionResponse
+			if code := s.do(t, s.submitHTTPHandler, http.MethodPost, "/submit", submission(t, fmt.Sprintf("echo%v", i)), nil, &resp); code != http.StatusOK || !resp.Passed {
+				t.Errorf("Submit returned %v %+v, want a passed submission", code, resp)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	dirs := make(map[string]bool)
+	for _, d := range dc.boundDirs() {
+		if dirs[d] {
+			t.Errorf("Tmp dir %v is shared between submissions", d)
+		}
+		dirs[d] = true
+		if _, err := os.Stat(d); !os.IsNotExist(err) {
+			t.Errorf("Tmp dir %v wasn't removed once judged: %v", d, err)
+		}
+	}
+	if len(dirs) != n {
+		t.Errorf("Got %v tmp dirs, want one per submission: %v", len(dirs), n)
+	}
+	if len(contents) != n {
+		t.Errorf("Inspected %v workspaces, want %v", len(contents), n)
+	}
+	for _, files := range contents {
+		if len(files) != 1 || files[0].Name() != "main.go" {
+			t.Errorf("Got a workspace of %v files, want only the submission's main.go", len(files))
+		}
+	}
+}
+
+// fileTest is a test executing the submission and comparing the content of the
+// file in its workspace.
+func fileTest(name, path, want string) Test {
+	return Test{
+		Name: name,
+		Func: func(sub *Submission) error {
+			if err := sub.Executor.Execute(nil); err != nil {
+				return err
+			}
+			got, err := sub.Executor.ReadFileFromContainer(path)
+			if err != nil {
+				return err
+			}
+			if got != want {
+				return fmt.Errorf("want: %v, got: %v", want, got)
+			}
+			return nil
+		},
+	}
+}
+
+func TestSetupPreparesWorkspace(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{run: runOnHost})
+	defer s.close()
+	for _, task := range []Task{
+		{Name: "seeded", Setup: []string{"sh", "-c", "echo 42 > input.txt"}, Tests: []Test{fileTest("input", "input.txt", "42\n")}},
+		{Name: "broken", Setup: []string{"sh", "-c", "exit 3"}, Tests: []Test{fileTest("input", "input.txt", "42\n")}},
+	} {
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+
+	if code, resp := s.submit(t, testUsername, submission(t, "seeded")); code != http.StatusOK || !resp.Passed {
+		t.Errorf("Submit with a working setup returned %v %+v, want a passed submission", code, resp)
+	}
+	if code, resp := s.submit(t, testUsername, submission(t, "broken")); code != http.StatusOK || resp.Passed || !strings.Contains(resp.Error, "environment error") {
+		t.Errorf("Submit with a failing setup returned %v %+v, want an environment error", code, resp)
+	}
+	subs := s.submissions(t, 2, "")
+	if len(subs) != 2 || subs[0].Verdict != environmentErrorVerdict || subs[1].Verdict != passedVerdict {
+		t.Errorf("Got submissions %+v, want an environment error after a pass", subs)
+	}
+}
+
+func TestSetupKilledAfterTimeout(t *testing.T) {
+	dc := &fakeDocker{hang: true}
+	s := newTestServer(t, dc)
+	defer s.close()
+	task := Task{
+		Name:   "slow",
+		Setup:  []string{"sleep", "1000"},
+		Limits: Limits{Timeout: 50 * time.Millisecond},
+		Tests:  []Test{fileTest("input", "input.txt", "42\n")},
+	}
+	if err := s.RegisterTask(task); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	code, resp := s.submit(t, testUsername, submission(t, "slow"))
+	if code != http.StatusOK || !strings.Contains(resp.Error, "exceeded the time limit") {
+		t.Errorf("Submit with a hanging setup returned %v %+v, want a setup time limit error", code, resp)
+	}
+	if subs := s.submissions(t, 1, ""); subs[0].Verdict != environmentErrorVerdict {
+		t.Errorf("Got verdict %v, want %v", subs[0].Verdict, environmentErrorVerdict)
+	}
+	if killed, removed := dc.killedAndRemoved(); len(killed) != 1 || len(removed) != 1 {
+		t.Errorf("Killed %v and removed %v, want the setup container killed and removed", killed, removed)
+	}
+}
+
+func TestTaskHostConfigOverrides(t *testing.T) {
+	dc := &fakeDocker{stdout: "hello"}
+	s := newTestServer(t, dc)
+	defer s.close()
+	s.AllowedHostConfigFields = []string{"CapAdd"}
+	task := Task{
+		Name:       "net",
+		HostConfig: &docker.HostConfig{CapAdd: []string{"NET_ADMIN"}},
+		Tests:      []Test{outputTest("hello", "hello")},
+	}
+	if err := s.RegisterTask(task); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	if code, resp := s.submit(t, testUsername, submission(t, "net")); code != http.StatusOK || !resp.Passed {
+		t.Fatalf("Submit returned %v %+v, want a passed submission", code, resp)
+	}
+	opts := dc.createOptions()
+	if len(opts) != 1 || len(opts[0].HostConfig.CapAdd) != 1 || opts[0].HostConfig.CapAdd[0] != "NET_ADMIN" {
+		t.Errorf("Got the create options %+v, want the NET_ADMIN capability added", opts)
+	}
+	if len(opts) == 1 && len(opts[0].HostConfig.Binds) != 1 {
+		t.Errorf("Got the binds %v, want the workspace bind kept", opts[0].HostConfig.Binds)
+	}
+
+	task = Task{
+		Name:       "privileged",
+		HostConfig: &docker.HostConfig{Privileged: true},
+		Tests:      []Test{outputTest("hello", "hello")},
+	}
+	if err := s.RegisterTask(task); err == nil || !strings.Contains(err.Error(), "Privileged is not allowed") {
+		t.Errorf("Registering a task with a disallowed host config field returned %v, want an error", err)
+	}
+}
+
+// containerStats returns the stats of a container using the memory and CPU time.
+func containerStats(memory uint64, cpu time.Duration) docker.Stats {
+	var st docker.Stats
+	st.MemoryStats.MaxUsage = memory
+	st.CPUStats.CPUUsage.TotalUsage = uint64(cpu)
+	return st
+}
+
+func TestResourceUsageRecordedFromStats(t *testing.T) {
+	dc := &fakeDocker{stdout: "hello", stats: []docker.Stats{
+		containerStats(100, 10*time.Millisecond),
+		containerStats(300, 50*time.Millisecond),
+		// Stopped containers report zero usage.
+		containerStats(0, 0),
+	}}
+	s := newTestServer(t, dc)
+	defer s.close()
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	code, resp := s.submit(t, testUsername, submission(t, "echo"))
+	if code != http.StatusOK || !resp.Passed {
+		t.Fatalf("Submit returned %v %+v, want a passed submission", code, resp)
+	}
+	if want := (ResourceUsage{MaxMemoryBytes: 300, CPUTime: 50 * time.Millisecond}); resp.ResourceUsage != want {
+		t.Errorf("Got the resource usage %+v, want %+v", resp.ResourceUsage, want)
+	}
+}
+
+func TestPinnedImageDigestVerified(t *testing.T) {
+	const (
+		digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+		image  = "golang@" + digest
+	)
+	for _, c := range []struct {
+		name    string
+		image   docker.Image
+		verdict string
+	}{
+		{"matching", docker.Image{ID: image, RepoDigests: []string{image}}, passedVerdict},
+		{"other id", docker.Image{ID: "sha256:other", RepoDigests: []string{image}}, infraErrorVerdict},
+		{"other digest", docker.Image{ID: image, RepoDigests: []string{"golang@sha256:other"}}, infraErrorVerdict},
+	} {
+		s := newTestServer(t, &fakeDocker{stdout: "hello", images: map[string]docker.Image{image: c.image}})
+		if err := s.RegisterTask(Task{Name: "echo", Image: image, Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+		code, resp := s.submit(t, testUsername, submission(t, "echo"))
+		if code != http.StatusOK {
+			t.Fatalf("Submit with the %v image returned %v, want %v", c.name, code, http.StatusOK)
+		}
+		if c.verdict == infraErrorVerdict && !strings.Contains(resp.Error, "doesn't match the pinned digest") {
+			t.Errorf("Submit with the %v image returned %+v, want a digest mismatch", c.name, resp)
+		}
+		if subs := s.submissions(t, 1, ""); subs[0].Verdict != c.verdict {
+			t.Errorf("Got the verdict %v with the %v image, want %v", subs[0].Verdict, c.name, c.verdict)
+		}
+		s.close()
+	}
+}
+
+func TestContainersUseLogConfig(t *testing.T) {
+	logConfig := &docker.LogConfig{Type: "json-file", Config: map[string]string{"max-size": "1m"}}
+	for _, want := range []*docker.LogConfig{nil, logConfig} {
+		dc := &fakeDocker{stdout: "hello"}
+		s := newTestServer(t, dc)
+		s.LogConfig = want
+		if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+		if code, resp := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK || !resp.Passed {
+			t.Fatalf("Submit returned %v %+v, want a passed submission", code, resp)
+		}
+		if want == nil {
+			want = &docker.LogConfig{}
+		}
+		if opts := dc.createOptions(); len(opts) != 1 || !reflect.DeepEqual(opts[0].HostConfig.LogConfig, *want) {
+			t.Errorf("Got the create options %+v, want the log config %+v", opts, *want)
+		}
+		s.close()
+	}
+}
+
+func TestCompileOutputTooLarge(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		marker string
+		err    string
+	}{
+		// The build script of the image marks the failed builds, and the ones whose
+		// output exceeds the limit.
+		{"failed", compileFailedMarker, errCompilationFailed.Error()},
+		{"huge", compileTooLargeMarker, errCompileOutputTooLarge.Error()},
+	} {
+		dc := &fakeDocker{exitCode: 1, files: map[string]string{c.marker: ""}}
+		s := newTestServer(t, dc)
+		s.MaxCompileOutputBytes = 1024
+		s.DetailedVerdicts = true
+		if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+		code, resp := s.submit(t, testUsername, submission(t, "echo"))
+		if code != http.StatusOK || resp.Passed || !strings.Contains(resp.Error, c.err) || resp.Category != compileErrorCategory {
+			t.Errorf("Submit with the %v build returned %v %+v, want a %q compile error", c.name, code, resp, c.err)
+		}
+		if opts := dc.createOptions(); len(opts) != 1 || !strings.Contains(opts[0].Config.Cmd[2], "-gt 1024 ]") {
+			t.Errorf("Got the create options %+v, want the compile output limit in the build script", opts)
+		}
+		s.close()
+	}
+}
+
+// dieTest is a test executing the submission and reading its stdout once the
+// container dies, or after wait if it doesn't. The IDs of the containers killed
+// meanwhile are sent to killed.
+func dieTest(dc *fakeDocker, wait time.Duration, killed chan<- []string) Test {
+	return Test{
+		Name: "die",
+		Func: func(sub *Submission) error {
+			if err := sub.Executor.Execute(nil); err != nil {
+				return err
+			}
+			select {
+			case <-sub.Executor.DieEvent():
+			case <-time.After(wait):
+			}
+			k, _ := dc.killedAndRemoved()
+			killed <- k
+			_, err := sub.Executor.Stdout()
+			return err
+		},
+	}
+}
+
+func TestTimeLimitKillsHangingContainers(t *testing.T) {
+	dc := &fakeDocker{stdout: "hello", hang: true}
+	s := newTestServer(t, dc)
+	defer s.close()
+	killed := make(chan []string, 2)
+	for _, task := range []Task{
+		{Name: "limited", Limits: Limits{Timeout: 50 * time.Millisecond}, Tests: []Test{dieTest(dc, 5*time.Second, killed)}},
+		{Name: "unlimited", Tests: []Test{dieTest(dc, 200*time.Millisecond, killed)}},
+	} {
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+
+	code, resp := s.submit(t, testUsername, submission(t, "limited"))
+	if code != http.StatusOK || resp.Passed || !strings.Contains(resp.Error, errTimeLimitExceeded.Error()) {
+		t.Errorf("Submit of a hanging submission returned %v %+v, want %q", code, resp, errTimeLimitExceeded)
+	}
+	if k := <-killed; len(k) != 1 {
+		t.Errorf("Killed %v, want the hanging container killed", k)
+	}
+	waitFor(t, "the timed out container to be removed", func() bool {
+		_, removed := dc.killedAndRemoved()
+		for _, id := range removed {
+			if id == "container-1" {
+				return true
+			}
+		}
+		return false
+	})
+
+	// Without limits the container keeps running until the test is done with it.
+	code, resp = s.submit(t, testUsername, submission(t, "unlimited"))
+	if code != http.StatusOK || !resp.Passed {
+		t.Errorf("Submit without limits returned %v %+v, want a passed submission", code, resp)
+	}
+	if k := <-killed; len(k) != 1 {
+		t.Errorf("Killed %v while the unlimited submission ran, want only the timed out container", k)
+	}
+}
+
+func TestContainersUsePidsLimit(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		server int64
+		task   int64
+		want   int64
+	}{
+		{"default", 0, 0, 0},
+		{"server", 64, 0, 64},
+		{"task", 64, 16, 16},
+	} {
+		dc := &fakeDocker{stdout: "hello"}
+		s := newTestServer(t, dc)
+		// The containers aren't limited by default.
+		if c.server > 0 {
+			s.PidsLimit = c.server
+		}
+		if err := s.RegisterTask(Task{Name: "echo", Limits: Limits{Pids: c.task}, Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+		if code, resp := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK || !resp.Passed {
+			t.Fatalf("Submit returned %v %+v, want a passed submission", code, resp)
+		}
+		if opts := dc.createOptions(); len(opts) != 1 || opts[0].HostConfig.PidsLimit != c.want {
+			t.Errorf("Got the create options %+v with the %v limit, want a pids limit of %v", opts, c.name, c.want)
+		}
+		s.close()
+	}
+}
+
+func TestContainersMountTmpfs(t *testing.T) {
+	for _, c := range []struct {
+		name           string
+		path           string
+		noExec, noSuid bool
+		want           map[string]string
+	}{
+		{"unset", "", true, true, nil},
+		{"writable", "/scratch", false, false, map[string]string{"/scratch": "rw"}},
+		{"hardened", "/scratch", true, true, map[string]string{"/scratch": "rw,noexec,nosuid"}},
+	} {
+		dc := &fakeDocker{stdout: "hello"}
+		s := newTestServer(t, dc)
+		s.TmpfsPath, s.TmpfsNoExec, s.TmpfsNoSuid = c.path, c.noExec, c.noSuid
+		if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+		if code, resp := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK || !resp.Passed {
+			t.Fatalf("Submit returned %v %+v, want a passed submission", code, resp)
+		}
+		if opts := dc.createOptions(); len(opts) != 1 || !reflect.DeepEqual(opts[0].HostConfig.Tmpfs, c.want) {
+			t.Errorf("Got the create options %+v with the %v tmpfs, want the tmpfs mounts %v", opts, c.name, c.want)
+		}
+		s.close()
+	}
+}
@@ -0,0 +1,46 @@
+package godge
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// The siteverify endpoints of the supported CAPTCHA providers.
+const (
+	HCaptchaVerifyURL  = "https://hcaptcha.com/siteverify"
+	ReCaptchaVerifyURL = "https://www.google.com/recaptcha/api/siteverify"
+)
+
+var captchaClient = &http.Client{Timeout: 10 * time.Second}
+
+// SiteVerifyCaptcha returns a CAPTCHA verifier, to be used as the server's
+// VerifyCaptcha, checking the tokens against the siteverify endpoint of a
+// provider (e.g. HCaptchaVerifyURL) using the given secret.
+func SiteVerifyCaptcha(verifyURL, secret string) func(token, remoteIP string) error {
+	return func(token, remoteIP string) error {
+		form := url.Values{"secret": {secret}, "response": {token}}
+		if remoteIP != "" {
+			form.Set("remoteip", remoteIP)
+		}
+		resp, err := captchaClient.PostForm(verifyURL, form)
+		if err != nil {
+			return fmt.Errorf("failed to verify captcha: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var res struct {
+			Success    bool     `json:"success"`
+			ErrorCodes []string `json:"error-codes"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			return fmt.Errorf("failed to decode captcha verification: %v", err)
+		}
+		if !res.Success {
+			return fmt.Errorf("captcha rejected: %v", res.ErrorCodes)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,61 @@
+package godge
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// bufferedResponseWriter buffers the response so that it can be compressed once
+// its size is known.
+type bufferedResponseWriter struct {
+	w      http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) Header() http.Header {
+	return b.w.Header()
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	return b.buf.Write(p)
+}
+
+func (b *bufferedResponseWriter) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// gzipHandler compresses the responses of the handler that are at least minBytes
+// long if the client accepts gzip.
+func gzipHandler(h http.HandlerFunc, minBytes int) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
+			h(w, req)
+			return
+		}
+		bw := &bufferedResponseWriter{w: w}
+		h(bw, req)
+		if bw.status == 0 {
+			bw.status = http.StatusOK
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		if bw.buf.Len() < minBytes || w.Header().Get("Content-Encoding") != "" {
+			w.WriteHeader(bw.status)
+			w.Write(bw.buf.Bytes())
+			return
+		}
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(bw.buf.Bytes()))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.WriteHeader(bw.status)
+		gz := gzip.NewWriter(w)
+		gz.Write(bw.buf.Bytes())
+		gz.Close()
+	}
+}
@@ -0,0 +1,45 @@
+package godge
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestResponsesCompressedForGzipClients(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	// Between the sizes of the empty scoreboard page and of the sizes histogram.
+	s.CompressionMinBytes = 256
+	h := s.handler()
+
+	gzipped := http.Header{"Accept-Encoding": {"gzip, deflate"}}
+	w := serve(h.ServeHTTP, "", http.MethodGet, "/scoreboard", nil, gzipped)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Scoreboard returned %v with Content-Encoding %q, want %v with gzip", w.Code, w.Header().Get("Content-Encoding"), http.StatusOK)
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("Failed to read the compressed scoreboard: %v", err)
+	}
+	body, err := ioutil.ReadAll(zr)
+	if err != nil || !strings.Contains(string(body), "Scoreboard!") {
+		t.Errorf("Got the decompressed scoreboard %q (%v), want the scoreboard page", body, err)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/html" {
+		t.Errorf("Got the Content-Type %q, want text/html", ct)
+	}
+
+	w = serve(h.ServeHTTP, "", http.MethodGet, "/scoreboard", nil, nil)
+	if w.Header().Get("Content-Encoding") != "" || !strings.Contains(w.Body.String(), "Scoreboard!") {
+		t.Errorf("Got the scoreboard %q with Content-Encoding %q, want it uncompressed", w.Body.String(), w.Header().Get("Content-Encoding"))
+	}
+
+	// The responses below CompressionMinBytes are not worth compressing.
+	w = serve(h.ServeHTTP, "", http.MethodGet, "/stats/sizes", nil, gzipped)
+	if w.Header().Get("Content-Encoding") != "" || !strings.Contains(w.Body.String(), `"buckets"`) {
+		t.Errorf("Got the sizes %q with Content-Encoding %q, want them uncompressed", w.Body.String(), w.Header().Get("Content-Encoding"))
+	}
+}
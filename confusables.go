@@ -0,0 +1,94 @@
+package godge
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+)
+
+// confusables maps the lowercase runes commonly used to impersonate other
+// characters to the ASCII character they look like.
+var confusables = map[rune]string{
+	// Digits.
+	'0': "o",
+	'1': "l",
+	// Latin.
+	'ı': "i",
+	'ł': "l",
+	'ß': "ss",
+	// Cyrillic.
+	'а': "a",
+	'в': "b",
+	'е': "e",
+	'ё': "e",
+	'к': "k",
+	'м': "m",
+	'н': "h",
+	'о': "o",
+	'р': "p",
+	'с': "c",
+	'т': "t",
+	'у': "y",
+	'х': "x",
+	'ѕ': "s",
+	'і': "i",
+	'ї': "i",
+	'ј': "j",
+	'ԁ': "d",
+	'һ': "h",
+	'ӏ': "l",
+	'ԛ': "q",
+	'ԝ': "w",
+	// Greek.
+	'α': "a",
+	'β': "b",
+	'ε': "e",
+	'η': "n",
+	'ι': "i",
+	'κ': "k",
+	'ν': "v",
+	'ο': "o",
+	'ρ': "p",
+	'τ': "t",
+	'υ': "u",
+	'χ': "x",
+}
+
+// usernameSkeleton returns the form of the username used to detect look-alike
+// usernames. Usernames differing only in case or by confusable characters
+// (e.g. a Cyrillic "о" instead of a Latin "o") have the same skeleton.
+func usernameSkeleton(username string) string {
+	b := new(bytes.Buffer)
+	for _, r := range strings.ToLower(username) {
+		// Fullwidth forms of the ASCII characters.
+		if r >= '！' && r <= '～' {
+			r = unicode.ToLower(r - '！' + '!')
+		}
+		// Combining marks (e.g. accents) stacked over the characters.
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if s, ok := confusables[r]; ok {
+			b.WriteString(s)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	// "rn" renders like "m" in most fonts.
+	return strings.Replace(b.String(), "rn", "m", -1)
+}
+
+// confusableUsername returns a registered username looking like the given one, if any.
+func (s *Server) confusableUsername(username string) (string, bool, error) {
+	usernames, err := userQ.usernames(s.db)
+	if err != nil {
+		return "", false, err
+	}
+	skel := usernameSkeleton(username)
+	for _, u := range usernames {
+		if usernameSkeleton(u) == skel {
+			return u, true, nil
+		}
+	}
+	return "", false, nil
+}
@@ -0,0 +1,208 @@
+package godge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ContestPhase is the phase of the contest according to the server's StartAt,
+// FreezeAt and EndAt.
+type ContestPhase string
+
+const (
+	// ContestBefore is the phase before StartAt.
+	ContestBefore ContestPhase = "before"
+	// ContestRunning is the phase between StartAt and FreezeAt (or EndAt).
+	ContestRunning ContestPhase = "running"
+	// ContestFrozen is the phase between FreezeAt and EndAt, in which the results
+	// of the new submissions are hidden from the scoreboard and the activity.
+	ContestFrozen ContestPhase = "frozen"
+	// ContestEnded is the phase after EndAt.
+	ContestEnded ContestPhase = "ended"
+)
+
+// contestWindow returns the StartAt, FreezeAt and EndAt of the contest, which can
+// change when the contest goes live.
+func (s *Server) contestWindow() (startAt, freezeAt, endAt time.Time) {
+	s.windowMu.RLock()
+	defer s.windowMu.RUnlock()
+	return s.StartAt, s.FreezeAt, s.EndAt
+}
+
+// phase returns the phase of the contest at the given time.
+func (s *Server) phase(now time.Time) ContestPhase {
+	startAt, freezeAt, endAt := s.contestWindow()
+	switch {
+	case !startAt.IsZero() && now.Before(startAt):
+		return ContestBefore
+	case !endAt.IsZero() && !now.Before(endAt):
+		return ContestEnded
+	case !freezeAt.IsZero() && !now.Before(freezeAt):
+		return ContestFrozen
+	default:
+		return ContestRunning
+	}
+}
+
+// ContestStateResponse is the response of the contest state request. The times
+// are null if not set. It's exposed to be used by the command line client.
+type ContestStateResponse struct {
+	Phase    ContestPhase `json:"phase"`
+	Now      time.Time    `json:"now"`
+	StartAt  *time.Time   `json:"startAt"`
+	FreezeAt *time.Time   `json:"freezeAt"`
+	EndAt    *time.Time   `json:"endAt"`
+}
+
+// optionalTime returns nil for the zero time.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// contestState returns the state of the contest at the given time.
+func (s *Server) contestState(now time.Time) ContestStateResponse {
+	startAt, freezeAt, endAt := s.contestWindow()
+	return ContestStateResponse{
+		Phase:    s.phase(now),
+		Now:      now,
+		StartAt:  optionalTime(startAt),
+		FreezeAt: optionalTime(freezeAt),
+		EndAt:    optionalTime(endAt),
+	}
+}
+
+// Handles the requests of the current phase of the contest.
+func (s *Server) contestStateHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(s.contestState(time.Now())); err != nil {
+		httpJSONError(w, "Failed to encode contest state", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GoLiveRequest represents the request to end the practice and start the official
+// contest. StartAt defaults to now, and the contest is never frozen or never ends
+// if FreezeAt or EndAt are not set.
+type GoLiveRequest struct {
+	StartAt  time.Time `json:"startAt"`
+	FreezeAt time.Time `json:"freezeAt"`
+	EndAt    time.Time `json:"endAt"`
+}
+
+// The tables holding the results of the submissions and the solve events still to
+// be delivered, cleared when the contest goes live.
+var resultTables = []string{"scoreboard", "submission_sources", "submission_logs", "submission_diffs", "rank_snapshots", "webhook_deliveries"}
+
+// clearResults deletes the results of all the submissions, keeping the users.
+func clearResults(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	for _, t := range resultTables {
+		if _, err := tx.Exec("DELETE FROM " + t); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to clear %v: %v", t, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// Handles switching from the practice to the official contest: the practice results
+// are archived to the PracticeArchiveDir (if set) and cleared, and the contest window
+// is replaced by the official one. With sequential submission IDs, it's rejected
+// while submissions are being judged as the official ones would reuse their IDs.
+// Otherwise, the submissions being judged meanwhile may end up in the official
+// results.
+func (s *Server) goLiveHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		httpMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	u, ok := s.authenticateAdmin(w, req)
+	if !ok {
+		return
+	}
+
+	var greq GoLiveRequest
+	if err := json.NewDecoder(req.Body).Decode(&greq); err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to decode request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if greq.StartAt.IsZero() {
+		greq.StartAt = now
+	}
+	if !greq.EndAt.IsZero() && !greq.EndAt.After(greq.StartAt) {
+		httpJSONError(w, "The contest must end after it starts", http.StatusBadRequest)
+		return
+	}
+	if !greq.FreezeAt.IsZero() && (greq.FreezeAt.Before(greq.StartAt) || !greq.EndAt.IsZero() && greq.FreezeAt.After(greq.EndAt)) {
+		httpJSONError(w, "The contest must be frozen while it's running", http.StatusBadRequest)
+		return
+	}
+
+	idle, err := s.submissionIDs.restart(func() error {
+		return s.clearPractice(now)
+	})
+	if !idle {
+		httpJSONError(w, "Submissions are being judged, retry once they're done", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		httpJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.windowMu.Lock()
+	s.StartAt, s.FreezeAt, s.EndAt = greq.StartAt, greq.FreezeAt, greq.EndAt
+	s.windowMu.Unlock()
+	log.Printf("The contest went live, set by %v", u.Username)
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(s.contestState(now)); err != nil {
+		httpJSONError(w, "Failed to encode contest state", http.StatusInternalServerError)
+		return
+	}
+}
+
+// clearPractice archives the practice results to the PracticeArchiveDir (if set)
+// and clears them.
+func (s *Server) clearPractice(now time.Time) error {
+	if s.PracticeArchiveDir != "" {
+		buf := new(bytes.Buffer)
+		if err := s.exportContest(buf, true); err != nil {
+			return fmt.Errorf("failed to archive the practice: %v", err)
+		}
+		path := filepath.Join(s.PracticeArchiveDir, fmt.Sprintf("practice-%v.zip", now.Unix()))
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+			return fmt.Errorf("failed to archive the practice: %v", err)
+		}
+		log.Printf("Archived the practice to %v", path)
+	}
+	if err := clearResults(s.db); err != nil {
+		return fmt.Errorf("failed to clear the practice results: %v", err)
+	}
+	// The cached verdicts would spare the practice solutions from being judged again.
+	s.verdicts.clear()
+	return nil
+}
@@ -0,0 +1,156 @@
+package godge
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContestStatePhases(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	now := time.Now()
+	for _, c := range []struct {
+		startAt, freezeAt, endAt time.Time
+		want                     ContestPhase
+	}{
+		{time.Time{}, time.Time{}, time.Time{}, ContestRunning},
+		{now.Add(time.Hour), now.Add(2 * time.Hour), now.Add(3 * time.Hour), ContestBefore},
+		{now.Add(-time.Hour), now.Add(time.Hour), now.Add(2 * time.Hour), ContestRunning},
+		{now.Add(-2 * time.Hour), now.Add(-time.Hour), now.Add(time.Hour), ContestFrozen},
+		{now.Add(-3 * time.Hour), now.Add(-2 * time.Hour), now.Add(-time.Hour), ContestEnded},
+		{now.Add(-time.Hour), time.Time{}, now.Add(-time.Minute), ContestEnded},
+	} {
+		s.StartAt, s.FreezeAt, s.EndAt = c.startAt, c.freezeAt, c.endAt
+		var resp ContestStateResponse
+		if code := s.do(t, s.contestStateHTTPHandler, http.MethodGet, "/contest/state", nil, nil, &resp); code != http.StatusOK {
+			t.Fatalf("Contest state returned %v, want %v", code, http.StatusOK)
+		}
+		if resp.Phase != c.want {
+			t.Errorf("Got phase %v for the window %v - %v - %v, want %v", resp.Phase, c.startAt, c.freezeAt, c.endAt, c.want)
+		}
+		if (resp.EndAt == nil) != c.endAt.IsZero() || resp.EndAt != nil && !resp.EndAt.Equal(c.endAt) {
+			t.Errorf("Got end %v, want %v", resp.EndAt, c.endAt)
+		}
+	}
+}
+
+func TestGoLiveClearsPractice(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.Admins = []string{testUsername}
+	s.SequentialSubmissionIDs = true
+	s.WebhookURL = "http://example.com/hook"
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	if code, resp := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK || !resp.Passed {
+		t.Fatalf("Practice submit returned %v %+v, want a passed submission", code, resp)
+	}
+	waitFor(t, "the solve event", func() bool { return s.count(t, "webhook_deliveries") == 1 })
+
+	startAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	endAt := startAt.Add(2 * time.Hour)
+	body := []byte(fmt.Sprintf(`{"startAt": %q, "endAt": %q}`, startAt.Format(time.RFC3339), endAt.Format(time.RFC3339)))
+	var resp ContestStateResponse
+	if code := s.do(t, s.goLiveHTTPHandler, http.MethodPost, "/admin/contest/golive", body, nil, &resp); code != http.StatusOK {
+		t.Fatalf("Going live returned %v, want %v", code, http.StatusOK)
+	}
+	if resp.Phase != ContestBefore || resp.StartAt == nil || !resp.StartAt.Equal(startAt) || resp.EndAt == nil || !resp.EndAt.Equal(endAt) {
+		t.Errorf("Got state %+v, want the official window %v - %v before it starts", resp, startAt, endAt)
+	}
+	for _, table := range resultTables {
+		if n := s.count(t, table); n != 0 {
+			t.Errorf("Got %v rows in %v, want the practice ones cleared", n, table)
+		}
+	}
+	if id := s.submissionIDs.next(); id != "1" {
+		t.Errorf("Got the official submission ID %v, want 1", id)
+	}
+}
+
+func TestGoLiveRejectedWhileJudging(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	s.Admins = []string{testUsername}
+	s.SequentialSubmissionIDs = true
+	s.submissionIDs.next()
+
+	if code := s.do(t, s.goLiveHTTPHandler, http.MethodPost, "/admin/contest/golive", []byte(`{}`), nil, nil); code != http.StatusConflict {
+		t.Errorf("Going live while judging returned %v, want %v", code, http.StatusConflict)
+	}
+	s.submissionIDs.done()
+	if code := s.do(t, s.goLiveHTTPHandler, http.MethodPost, "/admin/contest/golive", []byte(`{}`), nil, nil); code != http.StatusOK {
+		t.Errorf("Going live once judged returned %v, want %v", code, http.StatusOK)
+	}
+}
+
+func TestTaskWindowsOverrideContest(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	now := time.Now()
+	s.StartAt, s.EndAt = now.Add(-time.Hour), now.Add(time.Hour)
+	for _, task := range []Task{
+		{Name: "regular"},
+		{Name: "later", OpenAt: now.Add(30 * time.Minute)},
+		{Name: "earlier", CloseAt: now.Add(-time.Minute)},
+		{Name: "extended", CloseAt: now.Add(2 * time.Hour)},
+	} {
+		task.Tests = []Test{outputTest("hello", "hello")}
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+
+	for _, c := range []struct {
+		task   string
+		ended  bool
+		want   int
+		reason string
+	}{
+		{"regular", false, http.StatusOK, ""},
+		{"later", false, http.StatusForbidden, "task later opens for submissions at"},
+		{"earlier", false, http.StatusForbidden, "task earlier closed for submissions at"},
+		{"regular", true, http.StatusForbidden, "task regular closed for submissions at"},
+		{"extended", true, http.StatusOK, ""},
+	} {
+		if c.ended && !s.EndAt.Before(time.Now()) {
+			// Wait for the accepted submission to be reported before ending the contest.
+			s.submissions(t, 1, "")
+			s.EndAt = time.Now().Add(-time.Minute)
+		}
+		w := serve(s.submitHTTPHandler, testUsername, http.MethodPost, "/submit", submission(t, c.task), nil)
+		if w.Code != c.want || c.reason != "" && !strings.HasPrefix(errorOf(t, w), c.reason) {
+			t.Errorf("Submit to %v (contest ended: %v) returned %v %v, want %v %v", c.task, c.ended, w.Code, w.Body.String(), c.want, c.reason)
+		}
+	}
+}
+
+func TestPrerequisitesSolvedInPreviousContest(t *testing.T) {
+	dc := &fakeDocker{stdout: "hello"}
+	previous := newTestServer(t, dc)
+	previous.addUser(t, "bob")
+	if err := previous.RegisterTask(Task{Name: "basics", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	if code, resp := previous.submit(t, testUsername, submission(t, "basics")); code != http.StatusOK || !resp.Passed {
+		t.Fatalf("Submit to the previous contest returned %v %+v, want a passed submission", code, resp)
+	}
+	previous.submissions(t, 1, "")
+	previous.workers.set(0, previous.processSubmissions)
+
+	s := startTestServer(t, dc, previous.dir, previous.db)
+	defer s.close()
+	if err := s.RegisterTask(Task{Name: "advanced", Prerequisites: []string{"basics"}, Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	if code, resp := s.submit(t, testUsername, submission(t, "advanced")); code != http.StatusOK || !resp.Passed {
+		t.Errorf("Submit after solving the prerequisite returned %v %+v, want a passed submission", code, resp)
+	}
+	w := serve(s.submitHTTPHandler, "bob", http.MethodPost, "/submit", submission(t, "advanced"), nil)
+	if want := "Task advanced requires solving basics first"; w.Code != http.StatusForbidden || errorOf(t, w) != want {
+		t.Errorf("Submit without the prerequisite returned %v %v, want %v %v", w.Code, w.Body.String(), http.StatusForbidden, want)
+	}
+}
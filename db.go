@@ -1,21 +1,146 @@
 package godge
 
+import (
+	"database/sql"
+	"fmt"
+)
+
+// column is a column added to a table after its creation. Databases created by
+// older versions of the server are migrated by adding the missing columns.
+type column struct {
+	table      string
+	name       string
+	definition string
+	// An optional statement filling the column of the existing rows.
+	backfill string
+}
+
+var addedColumns = []column{
+	{table: "users", name: "email", definition: "varchar(255) DEFAULT ''"},
+	{table: "users", name: "verified", definition: "BOOLEAN DEFAULT 1"},
+	{table: "users", name: "verification_token", definition: "varchar(255) DEFAULT ''"},
+	{table: "scoreboard", name: "submission_id", definition: "varchar(255) DEFAULT ''"},
+	{table: "scoreboard", name: "tags", definition: "varchar(255) DEFAULT ''"},
+	{
+		table:      "scoreboard",
+		name:       "score",
+		definition: "REAL DEFAULT 0",
+		backfill:   "UPDATE scoreboard SET score=1 WHERE verdict='" + passedVerdict + "'",
+	},
+	{table: "scoreboard", name: "passed_tests", definition: "INTEGER DEFAULT 0"},
+	{table: "scoreboard", name: "total_tests", definition: "INTEGER DEFAULT 0"},
+	{table: "scoreboard", name: "language", definition: "varchar(255) DEFAULT ''"},
+	{table: "scoreboard", name: "frozen", definition: "BOOLEAN DEFAULT 0"},
+	{table: "scoreboard", name: "seed", definition: "INTEGER DEFAULT 0"},
+	{table: "scoreboard", name: "category", definition: "varchar(255) DEFAULT ''"},
+}
+
+// migrateDB adds the columns missing from the tables of an older database.
+func (s *Server) migrateDB() error {
+	existing := make(map[string]map[string]bool)
+	for _, c := range addedColumns {
+		if existing[c.table] == nil {
+			var cols []struct {
+				CID     int            `db:"cid"`
+				Name    string         `db:"name"`
+				Type    string         `db:"type"`
+				NotNull bool           `db:"notnull"`
+				Default sql.NullString `db:"dflt_value"`
+				PK      int            `db:"pk"`
+			}
+			if err := s.db.Select(&cols, "PRAGMA table_info("+c.table+")"); err != nil {
+				return fmt.Errorf("failed to get the columns of %v: %v", c.table, err)
+			}
+			existing[c.table] = make(map[string]bool)
+			for _, col := range cols {
+				existing[c.table][col.Name] = true
+			}
+		}
+		if existing[c.table][c.name] {
+			continue
+		}
+		if _, err := s.db.Exec(fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v %v", c.table, c.name, c.definition)); err != nil {
+			return fmt.Errorf("failed to add column %v to %v: %v", c.name, c.table, err)
+		}
+		if c.backfill != "" {
+			if _, err := s.db.Exec(c.backfill); err != nil {
+				return fmt.Errorf("failed to fill column %v of %v: %v", c.name, c.table, err)
+			}
+		}
+	}
+	return nil
+}
+
 func (s *Server) initDB() error {
 	const schema = `
 	CREATE TABLE IF NOT EXISTS users (
     id INTEGER PRIMARY KEY,
     username varchar(255),
-    password varchar(255)
+    password varchar(255),
+		email varchar(255) DEFAULT '',
+		verified BOOLEAN DEFAULT 1,
+		verification_token varchar(255) DEFAULT ''
 	);
 
 	CREATE TABLE IF NOT EXISTS scoreboard (
 		id INTEGER PRIMARY KEY,
+		submission_id varchar(255),
 		username INTEGER,
 		task_name varchar(255),
 		verdict varchar(255),
-		submitted_at DATETIME
+		tags varchar(255),
+		submitted_at DATETIME,
+		score REAL,
+		passed_tests INTEGER,
+		total_tests INTEGER,
+		language varchar(255),
+		frozen BOOLEAN DEFAULT 0,
+		seed INTEGER DEFAULT 0,
+		category varchar(255) DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS submission_sources (
+		submission_id varchar(255) PRIMARY KEY,
+		source BLOB
+	);
+
+	CREATE TABLE IF NOT EXISTS submission_logs (
+		submission_id varchar(255) PRIMARY KEY,
+		stdout TEXT,
+		stderr TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS submission_diffs (
+		id INTEGER PRIMARY KEY,
+		submission_id varchar(255),
+		case_name varchar(255),
+		diff TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS submission_budgets (
+		username varchar(255) PRIMARY KEY,
+		budget INTEGER
+	);
+
+	CREATE TABLE IF NOT EXISTS rank_snapshots (
+		id INTEGER PRIMARY KEY,
+		username varchar(255),
+		rank INTEGER,
+		score INTEGER,
+		taken_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY,
+		url varchar(255),
+		payload TEXT,
+		attempts INTEGER,
+		next_attempt_at DATETIME,
+		created_at DATETIME
 	);
 	`
-	_, err := s.db.Exec(schema)
-	return err
+	if _, err := s.db.Exec(schema); err != nil {
+		return err
+	}
+	return s.migrateDB()
 }
@@ -0,0 +1,104 @@
+package godge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// baselineSchema is the schema of the databases created by the first version of the server.
+const baselineSchema = `
+	CREATE TABLE users (
+		id INTEGER PRIMARY KEY,
+		username varchar(255),
+		password varchar(255)
+	);
+
+	CREATE TABLE scoreboard (
+		id INTEGER PRIMARY KEY,
+		username INTEGER,
+		task_name varchar(255),
+		verdict varchar(255),
+		submitted_at DATETIME
+	);
+`
+
+func TestMigrateBaselineDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godge-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	db, err := sqlx.Connect("sqlite3", filepath.Join(dir, "godge.db"))
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	password, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	solvedAt := time.Now().Add(-time.Hour)
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{baselineSchema, nil},
+		{"INSERT INTO users (username, password) VALUES (?,?)", []interface{}{"bob", string(password)}},
+		{"INSERT INTO scoreboard (username, task_name, verdict, submitted_at) VALUES (?,?,?,?)", []interface{}{"bob", "bye", failedVerdict, solvedAt.Add(-time.Minute)}},
+		{"INSERT INTO scoreboard (username, task_name, verdict, submitted_at) VALUES (?,?,?,?)", []interface{}{"bob", "echo", passedVerdict, solvedAt}},
+	} {
+		if _, err := db.Exec(stmt.query, stmt.args...); err != nil {
+			t.Fatalf("Failed to fill the baseline database: %v", err)
+		}
+	}
+
+	s := startTestServer(t, &fakeDocker{stdout: "hello"}, dir, db)
+	defer s.close()
+	for _, c := range addedColumns {
+		if _, err := db.Exec(fmt.Sprintf("SELECT %v FROM %v", c.name, c.table)); err != nil {
+			t.Errorf("Failed to select the column %v of %v, want it added: %v", c.name, c.table, err)
+		}
+	}
+	var scores []float64
+	if err := db.Select(&scores, "SELECT score FROM scoreboard ORDER BY id"); err != nil {
+		t.Fatalf("Failed to get the scores: %v", err)
+	}
+	if len(scores) != 2 || scores[0] != 0 || scores[1] != 1 {
+		t.Errorf("Got the scores %v, want 0 for the failed submission and 1 for the passed one", scores)
+	}
+	// Migrating again is a no-op.
+	if err := s.initDB(); err != nil {
+		t.Errorf("Migrating the migrated database failed: %v", err)
+	}
+
+	u, err := userQ.find(db, "bob")
+	if err != nil || !u.Verified || u.Email != "" {
+		t.Fatalf("Got the migrated user %+v (%v), want a verified user without email", u, err)
+	}
+	for _, task := range []Task{
+		{Name: "echo", Tests: []Test{outputTest("hello", "hello")}},
+		{Name: "bye", Tests: []Test{outputTest("hello", "hello")}},
+	} {
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+	if code, resp := s.submit(t, "bob", submission(t, "bye")); code != http.StatusOK || !resp.Passed {
+		t.Errorf("Submit of the migrated user returned %v %+v, want a passed submission", code, resp)
+	}
+	subs := s.submissionsOf(t, "bob", 3, "")
+	if len(subs) != 3 || subs[0].TaskName != "bye" || subs[1].ID != "" || !subs[1].SubmittedAt.Equal(solvedAt) {
+		t.Errorf("Got the submissions %+v, want the new one after the migrated ones", subs)
+	}
+	s.waitForAttempts(t, "bob", 2)
+	row := s.scoreboardOf(t, "").Rows[0]
+	if row.Username != "bob" || row.Score != 2 || row.Cells[1].Status != succeededStatus || row.Cells[1].SolvedAt == nil {
+		t.Errorf("Got the row %+v, want both tasks solved by bob", row)
+	}
+}
@@ -0,0 +1,51 @@
+package godge
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// taskCrashes counts the consecutive internal errors of the tasks' tests.
+type taskCrashes struct {
+	sync.Mutex
+	m map[string]int
+}
+
+// record updates the count of the task given the result of one of its submissions
+// and returns the number of consecutive internal errors.
+func (c *taskCrashes) record(task string, err error) int {
+	c.Lock()
+	defer c.Unlock()
+	if !isInternalError(err) {
+		delete(c.m, task)
+		return 0
+	}
+	c.m[task]++
+	return c.m[task]
+}
+
+// demoteCrashingTask closes the task once its tests crash for MaxTaskInternalErrors
+// consecutive submissions, so that a broken task doesn't penalize everyone, and alerts the admins.
+func (s *Server) demoteCrashingTask(task string, err error) {
+	n := s.taskCrashes.record(task, err)
+	if s.MaxTaskInternalErrors <= 0 || n < s.MaxTaskInternalErrors {
+		return
+	}
+	if err := s.tasks.update(task, func(t *Task) error {
+		return t.transition(TaskClosed)
+	}); err != nil {
+		log.Printf("Failed to close crashing task %v: %v", task, err)
+		return
+	}
+	s.taskCrashes.record(task, nil)
+	s.alert(fmt.Sprintf("Task %v was closed after %v consecutive internal errors, the last one: %v", task, n, err))
+}
+
+// alert notifies the admins through the server's Alert hook.
+func (s *Server) alert(msg string) {
+	log.Print(msg)
+	if s.Alert != nil {
+		s.Alert(msg)
+	}
+}
@@ -0,0 +1,57 @@
+package godge
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCrashingTaskClosed(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.MaxTaskInternalErrors = 3
+	var mu sync.Mutex
+	var alerts []string
+	s.Alert = func(msg string) {
+		mu.Lock()
+		defer mu.Unlock()
+		alerts = append(alerts, msg)
+	}
+	alerted := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), alerts...)
+	}
+	if err := s.RegisterTask(Task{Name: "crash", Image: crashImage, Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	state := func() TaskState {
+		task, _ := s.tasks.get("crash")
+		return task.state()
+	}
+
+	for i := 1; i <= s.MaxTaskInternalErrors; i++ {
+		if state() != TaskOpen {
+			t.Fatalf("Got the task %v after %v internal errors, want it open", state(), i-1)
+		}
+		if code, resp := s.submit(t, testUsername, submission(t, "crash")); code != http.StatusOK || !strings.Contains(resp.Error, "internal error") {
+			t.Fatalf("Submit returned %v %+v, want an internal error", code, resp)
+		}
+		s.submissions(t, i, "")
+		if i < s.MaxTaskInternalErrors && len(alerted()) != 0 {
+			t.Errorf("Got the alerts %v after %v internal errors, want none", alerted(), i)
+		}
+	}
+	// The admins are alerted once the task is closed.
+	waitFor(t, "the admins to be alerted", func() bool { return len(alerted()) > 0 })
+	if got := alerted(); len(got) != 1 || !strings.Contains(got[0], "Task crash was closed after 3 consecutive internal errors") {
+		t.Errorf("Got the alerts %v, want one about closing the task", got)
+	}
+	if state() != TaskClosed {
+		t.Errorf("Got the task %v, want it closed", state())
+	}
+	if code, _ := s.submit(t, testUsername, submission(t, "crash")); code == http.StatusOK {
+		t.Errorf("Submit to the closed task returned %v, want it rejected", code)
+	}
+}
@@ -0,0 +1,65 @@
+package godge
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// CaseDiff is the diff between the expected and the actual output of a failed
+// sample case. It's exposed to be used by the command line client.
+type CaseDiff struct {
+	Case string `json:"case"`
+	Diff string `json:"diff"`
+}
+
+// unifiedDiff returns a unified diff, with a single hunk holding all the lines,
+// turning want into got.
+func unifiedDiff(want, got string) string {
+	a, b := strings.Split(want, "\n"), strings.Split(got, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "--- want")
+	fmt.Fprintln(buf, "+++ got")
+	fmt.Fprintf(buf, "@@ -1,%v +1,%v @@\n", len(a), len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			fmt.Fprintf(buf, " %v\n", a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			fmt.Fprintf(buf, "-%v\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(buf, "+%v\n", b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		fmt.Fprintf(buf, "-%v\n", a[i])
+	}
+	for ; j < len(b); j++ {
+		fmt.Fprintf(buf, "+%v\n", b[j])
+	}
+	return buf.String()
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package godge
+
+import "syscall"
+
+// freeDiskBytes returns the disk space available to unprivileged users in the
+// filesystem of the given path.
+func freeDiskBytes(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,8 @@
+package godge
+
+import "errors"
+
+// freeDiskBytes is not supported on windows.
+func freeDiskBytes(path string) (uint64, error) {
+	return 0, errors.New("checking the free disk space is not supported on windows")
+}
@@ -1,4 +1,5 @@
-/*Package godge is used to build online judges for workshops and meetups. You
+/*
+Package godge is used to build online judges for workshops and meetups. You
 define the tasks of the workshop along with the tests for each task. Users
 submit their submission with the command line client which then get judged and
 displayed on the scoreboard.
@@ -0,0 +1,196 @@
+package godge
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// dockerAPI is the part of the docker client's API used by the server and the
+// executors. It's implemented by *docker.Client.
+type dockerAPI interface {
+	Ping() error
+	AddEventListener(listener chan<- *docker.APIEvents) error
+	PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error
+	InspectImage(name string) (*docker.Image, error)
+	ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
+	CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error)
+	InspectContainer(id string) (*docker.Container, error)
+	StartContainer(id string, hostConfig *docker.HostConfig) error
+	WaitContainer(id string) (int, error)
+	StopContainer(id string, timeout uint) error
+	KillContainer(opts docker.KillContainerOptions) error
+	RemoveContainer(opts docker.RemoveContainerOptions) error
+	DownloadFromContainer(id string, opts docker.DownloadFromContainerOptions) error
+	Logs(opts docker.LogsOptions) error
+	Stats(opts docker.StatsOptions) error
+}
+
+// docker returns the current client of the docker daemon.
+func (s *Server) docker() dockerAPI {
+	s.dockerMu.RLock()
+	defer s.dockerMu.RUnlock()
+	return s.dockerClient
+}
+
+// monitorDocker periodically checks the docker daemon, see checkDocker.
+func (s *Server) monitorDocker() {
+	for range time.Tick(s.DockerHealthCheckInterval) {
+		s.checkDocker()
+	}
+}
+
+// checkDocker pings the docker daemon and replaces the client with a new one if the
+// ping fails, so that the server recovers from daemon restarts.
+func (s *Server) checkDocker() {
+	err := s.docker().Ping()
+	if err == nil {
+		return
+	}
+	log.Printf("Docker daemon health check failed, reconnecting: %v", err)
+	dc, err := s.newDockerClient()
+	if err != nil {
+		log.Printf("Failed to reconnect to docker daemon: %v", err)
+		return
+	}
+	if err := dc.Ping(); err != nil {
+		log.Printf("Failed to reconnect to docker daemon: %v", err)
+		return
+	}
+	s.dockerMu.Lock()
+	s.dockerClient = dc
+	s.dockerMu.Unlock()
+	log.Printf("Reconnected to docker daemon")
+}
+
+// repullImages periodically pulls the floating images (see floatingImages) so that
+// the submissions are judged with the latest runtimes.
+func (s *Server) repullImages() {
+	for range time.Tick(s.ImageRepullInterval) {
+		s.repullFloatingImages()
+	}
+}
+
+// repullFloatingImages pulls each of the floating images once. The images failing
+// to be pulled are kept until the next attempt.
+func (s *Server) repullFloatingImages() {
+	for _, image := range s.floatingImages() {
+		if err := s.pullImage(image); err != nil {
+			log.Printf("Failed to re-pull image %v: %v", image, err)
+			continue
+		}
+		log.Printf("Re-pulled image %v", image)
+	}
+}
+
+// pullImage pulls the image from its registry, defaulting to the latest tag.
+func (s *Server) pullImage(image string) error {
+	repo, tag := docker.ParseRepositoryTag(image)
+	if tag == "" {
+		tag = "latest"
+	}
+	return s.docker().PullImage(docker.PullImageOptions{Repository: repo, Tag: tag}, docker.AuthConfiguration{})
+}
+
+// PullPolicy defines whether the images missing from the docker daemon are pulled
+// before judging the submissions using them.
+type PullPolicy string
+
+const (
+	// PullNever rejects the submissions whose image is missing.
+	PullNever PullPolicy = "never"
+	// PullIfNotPresent pulls the missing images, and rejects the submissions whose
+	// image can't be pulled.
+	PullIfNotPresent PullPolicy = "ifNotPresent"
+)
+
+// ensureImage returns an error if the image is missing from the docker daemon and
+// can't be pulled under the server's ImagePullPolicy, so that the submissions whose
+// runtime is unavailable are rejected before being queued.
+func (s *Server) ensureImage(image string) error {
+	_, err := s.docker().InspectImage(image)
+	if err == nil {
+		return nil
+	}
+	if err != docker.ErrNoSuchImage {
+		return fmt.Errorf("failed to inspect image %v: %v", image, err)
+	}
+	if s.ImagePullPolicy != PullIfNotPresent {
+		return fmt.Errorf("image %v is not available", image)
+	}
+	if err := s.pullImage(image); err != nil {
+		return fmt.Errorf("failed to pull image %v: %v", image, err)
+	}
+	log.Printf("Pulled missing image %v", image)
+	return nil
+}
+
+// floatingImages returns the images of the languages and the tasks that are
+// tagged latest (explicitly or by omitting the tag). Images pinned by digest and
+// images with other tags are assumed not to change.
+func (s *Server) floatingImages() []string {
+	images := make([]string, 0, len(s.LanguageImages))
+	for _, image := range s.LanguageImages {
+		images = append(images, image)
+	}
+	for _, t := range s.tasks.tasks() {
+		if t.Image != "" {
+			images = append(images, t.Image)
+		}
+	}
+	var ret []string
+	seen := make(map[string]bool)
+	for _, image := range images {
+		if seen[image] || strings.Contains(image, "@") {
+			continue
+		}
+		seen[image] = true
+		if _, tag := docker.ParseRepositoryTag(image); tag == "" || tag == "latest" {
+			ret = append(ret, image)
+		}
+	}
+	return ret
+}
+
+// The label set on all the containers created by the judge.
+const containerLabel = "godge"
+
+var containerLabels = map[string]string{containerLabel: "true"}
+
+// sweepOrphanContainers periodically removes the containers created by the judge
+// that are older than OrphanContainerMaxAge, in case they were leaked (e.g. after a crash).
+func (s *Server) sweepOrphanContainers() {
+	for range time.Tick(s.OrphanSweepInterval) {
+		if err := s.removeOrphanContainers(time.Now().Add(-s.OrphanContainerMaxAge)); err != nil {
+			log.Printf("Failed to sweep orphan containers: %v", err)
+		}
+	}
+}
+
+// removeOrphanContainers removes the judge's containers created before the given
+// time, except the ones of the submissions being judged.
+func (s *Server) removeOrphanContainers(before time.Time) error {
+	dc := s.docker()
+	cs, err := dc.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"label": {containerLabel}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %v", err)
+	}
+	running := s.runningSubmissions.containerIDs()
+	for _, c := range cs {
+		if running[c.ID] || !time.Unix(c.Created, 0).Before(before) {
+			continue
+		}
+		if err := dc.RemoveContainer(docker.RemoveContainerOptions{ID: c.ID, Force: true}); err != nil {
+			log.Printf("Failed to remove orphan container %v: %v", c.ID, err)
+			continue
+		}
+		log.Printf("Removed orphan container %v", c.ID)
+	}
+	return nil
+}
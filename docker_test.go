@@ -0,0 +1,122 @@
+package godge
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestDockerReconnectedAfterFailedPing(t *testing.T) {
+	down := fmt.Errorf("daemon restarted")
+	s := newTestServer(t, &fakeDocker{createErr: down, pingErr: down})
+	defer s.close()
+	reconnected := &fakeDocker{stdout: "hello"}
+	s.newDockerClient = func() (dockerAPI, error) {
+		return reconnected, nil
+	}
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	s.checkDocker()
+	if s.docker() != reconnected {
+		t.Fatalf("The docker client wasn't replaced after the failed ping")
+	}
+	if code, resp := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK || !resp.Passed {
+		t.Errorf("Submit after reconnecting returned %v %+v, want a passed submission", code, resp)
+	}
+}
+
+func TestDockerKeptAfterSuccessfulPing(t *testing.T) {
+	dc := &fakeDocker{}
+	s := newTestServer(t, dc)
+	defer s.close()
+	s.newDockerClient = func() (dockerAPI, error) {
+		t.Errorf("Reconnected to the healthy docker daemon")
+		return nil, fmt.Errorf("unexpected reconnection")
+	}
+
+	s.checkDocker()
+	if s.docker() != dc {
+		t.Errorf("The healthy docker client was replaced")
+	}
+}
+
+func TestOrphanContainersRemoved(t *testing.T) {
+	now := time.Now()
+	dc := &fakeDocker{listed: []docker.APIContainers{
+		{ID: "stale", Created: now.Add(-2 * time.Hour).Unix()},
+		{ID: "fresh", Created: now.Add(-time.Minute).Unix()},
+		{ID: "running", Created: now.Add(-2 * time.Hour).Unix()},
+	}}
+	s := newTestServer(t, dc)
+	defer s.close()
+	e := &GoExecutor{}
+	e.setContainer(&docker.Container{ID: "running"})
+	s.runningSubmissions.set("judged", &Submission{id: "judged", Executor: e})
+
+	if err := s.removeOrphanContainers(now.Add(-s.OrphanContainerMaxAge)); err != nil {
+		t.Fatalf("Failed to remove the orphan containers: %v", err)
+	}
+	if _, removed := dc.killedAndRemoved(); !reflect.DeepEqual(removed, []string{"stale"}) {
+		t.Errorf("Removed the containers %v, want only the stale one", removed)
+	}
+}
+
+func TestOnlyFloatingImagesRepulled(t *testing.T) {
+	dc := &fakeDocker{}
+	s := newTestServer(t, dc)
+	defer s.close()
+	s.LanguageImages = map[string]string{"go": "golang"}
+	for _, task := range []Task{
+		{Name: "latest", Image: "python:latest"},
+		{Name: "same", Image: "golang"},
+		{Name: "tagged", Image: "golang:1.8"},
+		{Name: "pinned", Image: "alpine@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+	} {
+		task.Tests = []Test{outputTest("hello", "hello")}
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task %v: %v", task.Name, err)
+		}
+	}
+
+	s.repullFloatingImages()
+	pulled := dc.pulledImages()
+	sort.Strings(pulled)
+	if want := []string{"golang:latest", "python:latest"}; !reflect.DeepEqual(pulled, want) {
+		t.Errorf("Got the images %v re-pulled, want %v", pulled, want)
+	}
+}
+
+func TestMissingImageRejectedBeforeQueueing(t *testing.T) {
+	dc := &fakeDocker{stdout: "hello", missing: map[string]bool{"judge:v1": true}}
+	s := newTestServer(t, dc)
+	defer s.close()
+	if err := s.RegisterTask(Task{Name: "echo", Image: "judge:v1", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	// The images are never pulled by default.
+	if code, _ := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusServiceUnavailable {
+		t.Errorf("Submit with a missing image returned %v, want %v", code, http.StatusServiceUnavailable)
+	}
+	if n, pulled := dc.createdContainers(), dc.pulledImages(); n != 0 || len(pulled) != 0 {
+		t.Errorf("Got %v containers and the images %v pulled, want the submission rejected before being judged", n, pulled)
+	}
+	if n := s.count(t, "scoreboard"); n != 0 {
+		t.Errorf("Got %v stored submissions, want none", n)
+	}
+
+	s.ImagePullPolicy = PullIfNotPresent
+	if code, resp := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK || !resp.Passed {
+		t.Errorf("Submit pulling the missing image returned %v %+v, want a passed submission", code, resp)
+	}
+	if pulled := dc.pulledImages(); !reflect.DeepEqual(pulled, []string{"judge:v1"}) {
+		t.Errorf("Got the images %v pulled, want the missing judge:v1", pulled)
+	}
+}
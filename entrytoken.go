@@ -0,0 +1,97 @@
+package godge
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EntryTokenHeader is the header carrying the entry token of a submission when
+// the server requires entry tokens. It's exposed to be used by the command line client.
+const EntryTokenHeader = "X-Godge-Entry-Token"
+
+// signEntryToken returns the entry token of the user for the contest, valid until
+// the expiry. The token is the expiry in unix seconds followed by the HMAC-SHA256
+// of the user, the contest and the expiry, e.g. 1489341898.3f2a...
+func signEntryToken(key []byte, username, contest string, expiresAt time.Time) string {
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	return expiry + "." + entryTokenMAC(key, username, contest, expiry)
+}
+
+func entryTokenMAC(key []byte, username, contest, expiry string) string {
+	mac := hmac.New(sha256.New, key)
+	// The fields are separated by NUL bytes so that they can't be shifted into
+	// each other.
+	fmt.Fprintf(mac, "%v\x00%v\x00%v", username, contest, expiry)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyEntryToken returns an error if the token wasn't signed with the key for
+// the user and the contest or if it expired at the given time.
+func verifyEntryToken(key []byte, username, contest, token string, now time.Time) error {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return errors.New("malformed entry token")
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(entryTokenMAC(key, username, contest, parts[0]))) {
+		return errors.New("invalid entry token")
+	}
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return errors.New("malformed entry token")
+	}
+	if !now.Before(time.Unix(expiry, 0)) {
+		return errors.New("expired entry token")
+	}
+	return nil
+}
+
+// EntryTokenResponse is the response of the registration and of the entry token
+// request when the server requires entry tokens. It's exposed to be used by the
+// command line client.
+type EntryTokenResponse struct {
+	// Sent in the EntryTokenHeader of the submissions.
+	EntryToken string    `json:"entryToken"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// issueEntryToken signs a new entry token of the user valid for EntryTokenTTL.
+func (s *Server) issueEntryToken(username string) EntryTokenResponse {
+	expiresAt := time.Now().Add(s.EntryTokenTTL)
+	return EntryTokenResponse{
+		EntryToken: signEntryToken(s.EntryTokenKey, username, s.ContestID, expiresAt),
+		ExpiresAt:  expiresAt,
+	}
+}
+
+// Handles issuing a new entry token to the authenticated user, e.g. when the previous
+// one expired or the user registered before the server required entry tokens. The
+// password is required, impersonation tokens are not accepted.
+func (s *Server) entryTokenHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		httpMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if len(s.EntryTokenKey) == 0 {
+		httpJSONError(w, "The server doesn't require entry tokens", http.StatusNotFound)
+		return
+	}
+	u, ok := s.authenticateBasic(req)
+	if !ok {
+		httpJSONError(w, "Wrong username or password", http.StatusUnauthorized)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(s.issueEntryToken(u.Username)); err != nil {
+		httpJSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,59 @@
+package godge
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEntryTokens(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.EntryTokenKey = []byte("entry token key")
+	s.ContestID = "contest"
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	w := serve(s.handler().ServeHTTP, "", http.MethodPost, "/register", []byte(`{"username": "bob", "password": "secret"}`), nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Register returned %v, want %v", w.Code, http.StatusCreated)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Register returned Cache-Control %q, want no-store", got)
+	}
+	var registered EntryTokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &registered); err != nil {
+		t.Fatalf("Failed to decode the register response: %v", err)
+	}
+	var reissued EntryTokenResponse
+	if code := s.do(t, s.entryTokenHTTPHandler, http.MethodPost, "/me/entry-token", nil, nil, &reissued); code != http.StatusOK {
+		t.Fatalf("Reissuing the entry token returned %v, want %v", code, http.StatusOK)
+	}
+
+	expired := signEntryToken(s.EntryTokenKey, testUsername, s.ContestID, time.Now().Add(-time.Minute))
+	// The last hex digit of the MAC is changed.
+	tampered := reissued.EntryToken[:len(reissued.EntryToken)-1] + "0"
+	if tampered == reissued.EntryToken {
+		tampered = tampered[:len(tampered)-1] + "1"
+	}
+	for _, c := range []struct {
+		name     string
+		username string
+		token    string
+		want     int
+	}{
+		{"registered", "bob", registered.EntryToken, http.StatusOK},
+		{"reissued", testUsername, reissued.EntryToken, http.StatusOK},
+		{"expired", testUsername, expired, http.StatusForbidden},
+		{"tampered", testUsername, tampered, http.StatusForbidden},
+		{"other user's", testUsername, registered.EntryToken, http.StatusForbidden},
+		{"missing", testUsername, "", http.StatusForbidden},
+	} {
+		header := http.Header{EntryTokenHeader: {c.token}}
+		if code := s.doAs(t, c.username, s.submitHTTPHandler, http.MethodPost, "/submit", submission(t, "echo"), header, nil); code != c.want {
+			t.Errorf("Submit with the %v entry token returned %v, want %v", c.name, code, c.want)
+		}
+	}
+}
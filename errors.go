@@ -17,3 +17,61 @@ func (e Errors) ErrorOrNil() error {
 	}
 	return nil
 }
+
+// InfrastructureError is an error caused by the judge's infrastructure (e.g. the
+// docker daemon) rather than by the submission itself. Tests can return it to
+// signal that the submission should be retried instead of failed.
+type InfrastructureError struct {
+	Err error
+}
+
+// Error returns the error string.
+func (e *InfrastructureError) Error() string {
+	return fmt.Sprintf("infrastructure error: %v", e.Err)
+}
+
+func infraErrorf(format string, a ...interface{}) error {
+	return &InfrastructureError{Err: fmt.Errorf(format, a...)}
+}
+
+func isInfrastructureError(err error) bool {
+	_, ok := err.(*InfrastructureError)
+	return ok
+}
+
+// internalError is returned when the judge itself (e.g. a test or an executor)
+// panics while judging a submission.
+type internalError struct {
+	recovered interface{}
+}
+
+func (e *internalError) Error() string {
+	return fmt.Sprintf("internal error: %v", e.recovered)
+}
+
+func isInternalError(err error) bool {
+	_, ok := err.(*internalError)
+	return ok
+}
+
+// environmentError is returned when the environment of a task can't be prepared
+// (e.g. its setup command fails).
+type environmentError struct {
+	err error
+}
+
+func (e *environmentError) Error() string {
+	return fmt.Sprintf("environment error: %v", e.err)
+}
+
+func isEnvironmentError(err error) bool {
+	_, ok := err.(*environmentError)
+	return ok
+}
+
+// isJudgeError returns true if the error is caused by the judge rather than by
+// the submission. Such errors abort the execution and the submission is neither
+// passed nor failed.
+func isJudgeError(err error) bool {
+	return isInfrastructureError(err) || isInternalError(err) || isEnvironmentError(err)
+}
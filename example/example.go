@@ -65,7 +65,9 @@ func main() {
 		log.Fatal(err)
 	}
 	for _, t := range tasks {
-		server.RegisterTask(t)
+		if err := server.RegisterTask(t); err != nil {
+			log.Fatal(err)
+		}
 	}
 	log.Fatal(server.Start())
 }
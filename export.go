@@ -0,0 +1,145 @@
+package godge
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ExportedSubmission is a judged submission in the contest archive. It's exposed
+// to be used by the command line client.
+type ExportedSubmission struct {
+	ID          string    `json:"id" db:"submission_id"`
+	Username    string    `json:"username" db:"username"`
+	TaskName    string    `json:"taskName" db:"task_name"`
+	Verdict     string    `json:"verdict" db:"verdict"`
+	Score       float64   `json:"score" db:"score"`
+	SubmittedAt time.Time `json:"submittedAt" db:"submitted_at"`
+}
+
+// getAllSubmissions returns the judged submissions of all the users from the oldest to the newest.
+func getAllSubmissions(db *sqlx.DB) ([]ExportedSubmission, error) {
+	ret := []ExportedSubmission{}
+	err := db.Select(&ret, "SELECT COALESCE(submission_id, '') AS submission_id, username, task_name, verdict, COALESCE(score, 0) AS score, submitted_at FROM scoreboard WHERE verdict IN (?,?) ORDER BY id", passedVerdict, failedVerdict)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get submissions: %v", err)
+	}
+	return ret, nil
+}
+
+// writeJSONEntry adds a file holding the JSON encoding of v to the archive.
+func writeJSONEntry(zw *zip.Writer, name string, v interface{}) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create %v: %v", name, err)
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("failed to encode %v: %v", name, err)
+	}
+	return nil
+}
+
+// exportContest writes the archive of the contest: the tasks, the users, the
+// final (unfrozen) standings and the judged submissions, with their source
+// archives under sources/ if withSources is true.
+func (s *Server) exportContest(buf *bytes.Buffer, withSources bool) error {
+	zw := zip.NewWriter(buf)
+
+	ts := s.tasks.tasks()
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Name < ts[j].Name })
+	if err := writeJSONEntry(zw, "tasks.json", ts); err != nil {
+		return err
+	}
+
+	us, err := userQ.usernames(s.db)
+	if err != nil {
+		return fmt.Errorf("failed to fetch users: %v", err)
+	}
+	sort.Strings(us)
+	if us == nil {
+		us = []string{}
+	}
+	if err := writeJSONEntry(zw, "users.json", us); err != nil {
+		return err
+	}
+
+	names := s.tasks.names()
+	sort.Strings(names)
+	scoreboard, err := buildScoreboard(s.db, us, names, s.tasks.points(), s.TieBreaker, false)
+	if err != nil {
+		return err
+	}
+	if err := writeJSONEntry(zw, "scoreboard.json", scoreboard.response()); err != nil {
+		return err
+	}
+
+	subs, err := getAllSubmissions(s.db)
+	if err != nil {
+		return err
+	}
+	if err := writeJSONEntry(zw, "submissions.json", subs); err != nil {
+		return err
+	}
+
+	if withSources {
+		for _, sub := range subs {
+			if sub.ID == "" {
+				continue
+			}
+			source, err := getSubmissionSource(s.db, sub.ID)
+			if err != nil {
+				return fmt.Errorf("failed to fetch the source of %v: %v", sub.ID, err)
+			}
+			f, err := zw.Create("sources/" + sub.ID + ".zip")
+			if err != nil {
+				return fmt.Errorf("failed to create the source of %v: %v", sub.ID, err)
+			}
+			if _, err := f.Write(source); err != nil {
+				return fmt.Errorf("failed to write the source of %v: %v", sub.ID, err)
+			}
+		}
+	}
+	return zw.Close()
+}
+
+// Handles exporting the archive of the contest. The source archives of the
+// submissions are included if the "sources" param is true.
+func (s *Server) exportHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if _, ok := s.authenticateAdmin(w, req); !ok {
+		return
+	}
+	var withSources bool
+	if v := req.URL.Query().Get("sources"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			httpJSONError(w, fmt.Sprintf("Invalid sources: %v", v), http.StatusBadRequest)
+			return
+		}
+		withSources = b
+	}
+
+	// The archive is buffered so that failures can still be reported.
+	buf := new(bytes.Buffer)
+	if err := s.exportContest(buf, withSources); err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to export contest: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="godge-export.zip"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
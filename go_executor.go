@@ -7,6 +7,9 @@ import (
 	docker "github.com/fsouza/go-dockerclient"
 )
 
+// The image used to build and run Go submissions by default.
+const defaultGoImage = "golang:1.8"
+
 // GoExecutor implements the Executor interface. It's used in the submit request
 // when the language is Go. You won't deal with the GoExecutor directly, it's only
 // exposed to be used by the command line client.
@@ -16,6 +19,10 @@ type GoExecutor struct {
 	PackageArchive []byte `json:"packageArchive"`
 }
 
+func (g *GoExecutor) source() []byte {
+	return g.PackageArchive
+}
+
 // Execute executes the Go main package submitted with the given arguments.
 func (g *GoExecutor) Execute(args []string) error {
 	g.init()
@@ -24,39 +31,64 @@ func (g *GoExecutor) Execute(args []string) error {
 		panic("Docker client must be set for go executor")
 	}
 
-	pdir, err := unzipToTmpDir(g.PackageArchive)
+	pdir, err := g.unzipToTmpDir(g.PackageArchive)
 	if err != nil {
 		return fmt.Errorf("failed to unzip package: %v", err)
 	}
 
+	// The compiler output is only printed to stderr if the build fails, and it's
+	// replaced by an error if it exceeds the configured limit.
 	cmd := []string{"/bin/bash", "-c", fmt.Sprintf(`
 	set -e;
 	go-wrapper download > /dev/null 2>&1;
-	go-wrapper install > /dev/null 2>&1;
-	app %v;`, strings.Join(args, " "))}
+	status=0;
+	go-wrapper install > /tmp/godge-compile.log 2>&1 || status=$?;
+	if [ %v -gt 0 ] && [ $(stat -c %%s /tmp/godge-compile.log) -gt %v ]; then
+		touch %v;
+		echo "compile output too large" >&2;
+		exit 1;
+	fi;
+	if [ $status -ne 0 ]; then
+		touch %v;
+		cat /tmp/godge-compile.log >&2;
+		exit $status;
+	fi;
+	app %v;`, g.config.maxCompileOutputBytes, g.config.maxCompileOutputBytes, compileTooLargeMarker, compileFailedMarker, strings.Join(args, " "))}
 	cmd = append(cmd, args...)
 	wdir := "/go/src/app"
 	g.workDir = wdir
+	image := g.image(defaultGoImage)
+	hostConfig := g.hostConfig(&docker.HostConfig{
+		Binds: []string{
+			fmt.Sprintf("%v:%v", pdir, wdir),
+		},
+	})
+	if err := g.runSetup(image, wdir, hostConfig); err != nil {
+		return err
+	}
 	option := docker.CreateContainerOptions{
 		Name: randomString(20),
 		Config: &docker.Config{
-			Image:      "golang:1.8",
+			Image:      image,
 			Cmd:        cmd,
 			WorkingDir: wdir,
+			Labels:     containerLabels,
 		},
-		HostConfig: &docker.HostConfig{
-			Binds: []string{
-				fmt.Sprintf("%v:%v", pdir, wdir),
-			},
-		},
+		HostConfig: hostConfig,
 	}
 
-	g.container, err = g.dockerClient.CreateContainer(option)
+	container, err := g.dockerClient.CreateContainer(option)
 	if err != nil {
-		return fmt.Errorf("failed to create container: %v", err)
+		return infraErrorf("failed to create container: %v", err)
+	}
+	g.setContainer(container)
+	if err := g.verifyImageDigest(image); err != nil {
+		return err
 	}
 	if err := g.dockerClient.StartContainer(g.container.ID, nil); err != nil {
-		return fmt.Errorf("failed to start container: %v", err)
+		return infraErrorf("failed to start container: %v", err)
 	}
+	g.watchStats()
+	g.enforceTimeout()
 	return nil
 }
@@ -0,0 +1,65 @@
+package godge
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// RankSnapshot is the rank and the score of a user on the scoreboard at some
+// point in time. It's exposed to be used by the command line client.
+type RankSnapshot struct {
+	Rank    int       `json:"rank" db:"rank"`
+	Score   int       `json:"score" db:"score"`
+	TakenAt time.Time `json:"takenAt" db:"taken_at"`
+}
+
+// snapshotRanks periodically saves the rank and the score of all the users.
+func (s *Server) snapshotRanks() {
+	for now := range time.Tick(s.RankSnapshotInterval) {
+		if err := s.takeRankSnapshot(now); err != nil {
+			log.Printf("Failed to snapshot the ranks: %v", err)
+		}
+	}
+}
+
+// takeRankSnapshot saves the rank and the score of all the users on the public
+// scoreboard, which hides the results judged while it's frozen.
+func (s *Server) takeRankSnapshot(now time.Time) error {
+	ts := s.tasks.names()
+	sort.Strings(ts)
+	us, err := userQ.usernames(s.db)
+	if err != nil {
+		return fmt.Errorf("failed to fetch users: %v", err)
+	}
+	sort.Strings(us)
+	scoreboard, err := buildScoreboard(s.db, us, ts, s.tasks.points(), s.TieBreaker, s.phase(now) == ContestFrozen)
+	if err != nil {
+		return err
+	}
+	for i, r := range scoreboard.Rows {
+		if err := saveRankSnapshot(s.db, r.Username, RankSnapshot{Rank: i + 1, Score: r.score(), TakenAt: now}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveRankSnapshot(db *sqlx.DB, user string, rs RankSnapshot) error {
+	if _, err := db.Exec("INSERT INTO rank_snapshots (username, rank, score, taken_at) VALUES (?,?,?,?)", user, rs.Rank, rs.Score, rs.TakenAt); err != nil {
+		return fmt.Errorf("failed to save rank snapshot: %v", err)
+	}
+	return nil
+}
+
+// getRankHistory returns the rank snapshots of the user from the oldest to the newest.
+func getRankHistory(db *sqlx.DB, user string) ([]RankSnapshot, error) {
+	ret := []RankSnapshot{}
+	if err := db.Select(&ret, "SELECT rank, score, taken_at FROM rank_snapshots WHERE username=? ORDER BY id", user); err != nil {
+		return nil, fmt.Errorf("failed to get rank history: %v", err)
+	}
+	return ret, nil
+}
@@ -0,0 +1,181 @@
+package godge
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type impersonation struct {
+	// Identifies the impersonation without revealing its token, e.g. to revoke it.
+	id        string
+	username  string
+	admin     string
+	expiresAt time.Time
+}
+
+// impersonations holds the tokens issued by the admins to act as other users.
+type impersonations struct {
+	sync.Mutex
+	m map[string]impersonation
+}
+
+// issue creates a new token allowing the admin to act as the user until it expires.
+func (i *impersonations) issue(admin, username string, ttl time.Duration) (impersonation, string, error) {
+	token, err := secureToken(32)
+	if err != nil {
+		return impersonation{}, "", err
+	}
+	id, err := secureToken(8)
+	if err != nil {
+		return impersonation{}, "", err
+	}
+	i.Lock()
+	defer i.Unlock()
+	now := time.Now()
+	for k, v := range i.m {
+		if now.After(v.expiresAt) {
+			delete(i.m, k)
+		}
+	}
+	imp := impersonation{id: id, username: username, admin: admin, expiresAt: now.Add(ttl)}
+	i.m[token] = imp
+	return imp, token, nil
+}
+
+// get returns the impersonation of the token if it's still valid.
+func (i *impersonations) get(token string) (impersonation, bool) {
+	i.Lock()
+	defer i.Unlock()
+	v, ok := i.m[token]
+	if !ok || time.Now().After(v.expiresAt) {
+		return impersonation{}, false
+	}
+	return v, true
+}
+
+// revoke invalidates the token of the impersonation with the given ID and reports
+// whether it existed.
+func (i *impersonations) revoke(id string) (impersonation, bool) {
+	i.Lock()
+	defer i.Unlock()
+	for token, v := range i.m {
+		if v.id == id {
+			delete(i.m, token)
+			return v, true
+		}
+	}
+	return impersonation{}, false
+}
+
+// authenticateImpersonation returns the user impersonated using the bearer token of
+// the request. It returns false if the request doesn't carry a valid token.
+func (s *Server) authenticateImpersonation(req *http.Request) (*user, bool) {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, false
+	}
+	imp, ok := s.impersonations.get(strings.TrimPrefix(auth, "Bearer "))
+	if !ok {
+		return nil, false
+	}
+	u, err := userQ.find(s.db, imp.username)
+	if err != nil {
+		return nil, false
+	}
+	log.Printf("%v %v by %v impersonated by %v", req.Method, req.URL.Path, imp.username, imp.admin)
+	return u, true
+}
+
+// ImpersonationResponse is the response of the impersonation request. The token is
+// sent as a bearer token in the Authorization header to act as the user. The ID
+// revokes the impersonation through /admin/impersonations/<id>, so that the token
+// itself never appears in the URLs.
+type ImpersonationResponse struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Dispatches the admin requests of a specific user (e.g. /admin/users/<name>/impersonate).
+func (s *Server) adminUserHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	parts := splitPath(req.URL.Path, "/admin/users/")
+	if len(parts) == 2 && parts[1] == "impersonate" {
+		s.impersonateHTTPHandler(w, req, parts[0])
+		return
+	}
+	if len(parts) == 2 && parts[1] == "budget" {
+		s.budgetHTTPHandler(w, req, parts[0])
+		return
+	}
+	if len(parts) == 2 && parts[1] == "stats" {
+		s.userStatsHTTPHandler(w, req, parts[0])
+		return
+	}
+	httpJSONError(w, "Not found", http.StatusNotFound)
+}
+
+// Handles issuing an impersonation token of a user.
+func (s *Server) impersonateHTTPHandler(w http.ResponseWriter, req *http.Request, username string) {
+	if req.Method != http.MethodPost {
+		httpMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	admin, ok := s.authenticateAdmin(w, req)
+	if !ok {
+		return
+	}
+
+	if _, err := userQ.find(s.db, username); err == sql.ErrNoRows {
+		httpJSONError(w, fmt.Sprintf("User %v not found", username), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to fetch user: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	imp, token, err := s.impersonations.issue(admin.Username, username, s.ImpersonationTTL)
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to issue impersonation token: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Admin %v started impersonating %v until %v (impersonation %v)", admin.Username, username, imp.expiresAt, imp.id)
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(ImpersonationResponse{ID: imp.id, Token: token, ExpiresAt: imp.expiresAt}); err != nil {
+		httpJSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Handles revoking an impersonation token by the ID of the impersonation (e.g.
+// DELETE /admin/impersonations/<id>).
+func (s *Server) revokeImpersonationHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		httpMethodNotAllowed(w, http.MethodDelete)
+		return
+	}
+	admin, ok := s.authenticateAdmin(w, req)
+	if !ok {
+		return
+	}
+	parts := splitPath(req.URL.Path, "/admin/impersonations/")
+	if len(parts) != 1 {
+		httpJSONError(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	imp, ok := s.impersonations.revoke(parts[0])
+	if !ok {
+		httpJSONError(w, "Impersonation not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Admin %v revoked the impersonation of %v by %v", admin.Username, imp.username, imp.admin)
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,62 @@
+package godge
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestImpersonation(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	s.Admins = []string{"admin"}
+	s.addUser(t, "admin")
+	logs := new(bytes.Buffer)
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	var imp ImpersonationResponse
+	if code := s.doAs(t, "admin", s.adminUserHTTPHandler, http.MethodPost, "/admin/users/alice/impersonate", nil, nil, &imp); code != http.StatusOK {
+		t.Fatalf("Impersonating returned %v, want %v", code, http.StatusOK)
+	}
+	if !strings.Contains(logs.String(), "Admin admin started impersonating alice") {
+		t.Errorf("Got logs %q, want the impersonation logged", logs.String())
+	}
+	if strings.Contains(logs.String(), imp.Token) {
+		t.Errorf("The impersonation token was logged")
+	}
+	if code := s.doAs(t, testUsername, s.adminUserHTTPHandler, http.MethodPost, "/admin/users/admin/impersonate", nil, nil, nil); code != http.StatusForbidden {
+		t.Errorf("Impersonating as a non admin returned %v, want %v", code, http.StatusForbidden)
+	}
+
+	bearer := http.Header{"Authorization": {"Bearer " + imp.Token}}
+	w := serve(s.nonceHTTPHandler, "", http.MethodPost, "/nonce", nil, bearer)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Request with the impersonation token returned %v, want %v", w.Code, http.StatusOK)
+	}
+	var nonce NonceResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &nonce); err != nil {
+		t.Fatalf("Failed to decode nonce: %v", err)
+	}
+	if !s.nonces.consume(testUsername, nonce.Nonce) {
+		t.Errorf("The impersonation token didn't act as %v", testUsername)
+	}
+	// The impersonation token can't change the user's password.
+	if w := serve(s.passwordHTTPHandler, "", http.MethodPost, "/me/password", []byte(`{"password": "new password"}`), bearer); w.Code != http.StatusUnauthorized {
+		t.Errorf("Changing the password with the impersonation token returned %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	if code := s.doAs(t, "admin", s.revokeImpersonationHTTPHandler, http.MethodDelete, "/admin/impersonations/"+imp.ID, nil, nil, nil); code != http.StatusOK {
+		t.Fatalf("Revoking returned %v, want %v", code, http.StatusOK)
+	}
+	if w := serve(s.nonceHTTPHandler, "", http.MethodPost, "/nonce", nil, bearer); w.Code != http.StatusUnauthorized {
+		t.Errorf("Request with the revoked token returned %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+	if code := s.doAs(t, "admin", s.revokeImpersonationHTTPHandler, http.MethodDelete, "/admin/impersonations/"+imp.Token, nil, nil, nil); code != http.StatusNotFound {
+		t.Errorf("Revoking by token returned %v, want %v", code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,194 @@
+package godge
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// StreakResponse is the response of the streak request. It's exposed to be used by
+// the command line client.
+type StreakResponse struct {
+	// The number of consecutive days, ending today or yesterday, in which the user solved a task.
+	Current int `json:"current"`
+	// The longest number of consecutive days in which the user solved a task.
+	Longest int `json:"longest"`
+}
+
+// solveStreak computes the streaks of the days of the solves relative to now. The days
+// are computed in the location of now.
+func solveStreak(solves []time.Time, now time.Time) StreakResponse {
+	day := func(t time.Time) time.Time {
+		t = t.In(now.Location())
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, now.Location())
+	}
+	days := make(map[time.Time]bool)
+	for _, s := range solves {
+		days[day(s)] = true
+	}
+
+	var ret StreakResponse
+	for d := range days {
+		// Only count the streaks starting at their first day.
+		if days[d.AddDate(0, 0, -1)] {
+			continue
+		}
+		n := 1
+		for days[d.AddDate(0, 0, n)] {
+			n++
+		}
+		if n > ret.Longest {
+			ret.Longest = n
+		}
+		last := d.AddDate(0, 0, n-1)
+		today := day(now)
+		if last.Equal(today) || last.Equal(today.AddDate(0, 0, -1)) {
+			ret.Current = n
+		}
+	}
+	return ret
+}
+
+// Handles the requests of the solve streak of the authenticated user. The days are
+// computed in the timezone passed in the "tz" param.
+func (s *Server) streakHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	u, ok := s.authenticate(req)
+	if !ok {
+		httpJSONError(w, "Wrong username or password", http.StatusUnauthorized)
+		return
+	}
+	loc, err := time.LoadLocation(req.URL.Query().Get("tz"))
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Invalid timezone: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	solves, err := getSolveTimes(s.db, u.Username, false)
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to fetch solves: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var times []time.Time
+	for _, t := range solves {
+		times = append(times, t)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(solveStreak(times, time.Now().In(loc))); err != nil {
+		httpJSONError(w, "Failed to encode streak", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Handles the requests of the rank history of the authenticated user, snapshotted
+// every RankSnapshotInterval.
+func (s *Server) historyHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	u, ok := s.authenticate(req)
+	if !ok {
+		httpJSONError(w, "Wrong username or password", http.StatusUnauthorized)
+		return
+	}
+
+	history, err := getRankHistory(s.db, u.Username)
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to fetch history: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		httpJSONError(w, "Failed to encode history", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PasswordRequest represents the request to change the password of the authenticated
+// user. It's exposed to be used by the command line client.
+type PasswordRequest struct {
+	Password string `json:"password"`
+}
+
+// Handles changing the password of the authenticated user.
+func (s *Server) passwordHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		httpMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	// Impersonation tokens are not accepted, so that the admins can't take over the
+	// accounts they impersonate.
+	u, ok := s.authenticateBasic(req)
+	if !ok {
+		httpJSONError(w, "Wrong username or password", http.StatusUnauthorized)
+		return
+	}
+
+	var preq PasswordRequest
+	if err := json.NewDecoder(req.Body).Decode(&preq); err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to decode request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := s.validatePassword(preq.Password); err != nil {
+		httpJSONError(w, fmt.Sprintf("Weak password: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	encryptedPassword, err := bcrypt.GenerateFromPassword([]byte(preq.Password), bcrypt.DefaultCost)
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to hash password: %v", err), http.StatusInternalServerError)
+		return
+	}
+	u.Password = string(encryptedPassword)
+	if err := u.updatePassword(s.db); err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to save password: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %v changed their password", u.Username)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Handles the requests for the tasks the authenticated user didn't attempt yet.
+func (s *Server) unattemptedHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	u, ok := s.authenticate(req)
+	if !ok {
+		httpJSONError(w, "Wrong username or password", http.StatusUnauthorized)
+		return
+	}
+
+	attempted, err := getAttemptedTasks(s.db, u.Username)
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to fetch attempted tasks: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	ts := []Task{}
+	for _, t := range s.tasks.tasks() {
+		if t.state() != TaskDraft && !attempted[t.Name] {
+			ts = append(ts, t)
+		}
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Name < ts[j].Name })
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(ts); err != nil {
+		httpJSONError(w, "Failed to encode tasks", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,156 @@
+package godge
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// taskNames returns the names of the tasks.
+func taskNames(ts []Task) []string {
+	ret := []string{}
+	for _, t := range ts {
+		ret = append(ret, t.Name)
+	}
+	return ret
+}
+
+func TestUnattemptedTasks(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.addUser(t, "bob")
+	for _, task := range []Task{
+		{Name: "solved", Tests: []Test{outputTest("hello", "hello")}},
+		{Name: "failed", Tests: []Test{outputTest("bye", "bye")}},
+		{Name: "untouched", Tests: []Test{outputTest("hello", "hello")}},
+		{Name: "draft", State: TaskDraft, Tests: []Test{outputTest("hello", "hello")}},
+	} {
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+	for _, task := range []string{"solved", "failed"} {
+		if code, _ := s.submit(t, testUsername, submission(t, task)); code != http.StatusOK {
+			t.Fatalf("Submit to %v returned %v, want %v", task, code, http.StatusOK)
+		}
+	}
+	s.submissions(t, 2, "")
+
+	for _, c := range []struct {
+		username string
+		want     []string
+	}{
+		{testUsername, []string{"untouched"}},
+		{"bob", []string{"failed", "solved", "untouched"}},
+	} {
+		var ts []Task
+		if code := s.doAs(t, c.username, s.unattemptedHTTPHandler, http.MethodGet, "/me/unattempted", nil, nil, &ts); code != http.StatusOK {
+			t.Fatalf("Unattempted tasks returned %v, want %v", code, http.StatusOK)
+		}
+		if got := taskNames(ts); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Got the unattempted tasks %v of %v, want %v", got, c.username, c.want)
+		}
+	}
+}
+
+func TestSolveStreak(t *testing.T) {
+	now := time.Date(2017, 3, 10, 12, 0, 0, 0, time.UTC)
+	day := func(d, hour int) time.Time {
+		return time.Date(2017, 3, d, hour, 0, 0, 0, time.UTC)
+	}
+	for _, c := range []struct {
+		name   string
+		solves []time.Time
+		want   StreakResponse
+	}{
+		{"none", nil, StreakResponse{}},
+		{"today", []time.Time{day(10, 1)}, StreakResponse{Current: 1, Longest: 1}},
+		// Several solves on the same day count once.
+		{"consecutive", []time.Time{day(8, 1), day(8, 23), day(9, 5), day(10, 1)}, StreakResponse{Current: 3, Longest: 3}},
+		// The current streak isn't broken before the end of today.
+		{"ending yesterday", []time.Time{day(8, 1), day(9, 1)}, StreakResponse{Current: 2, Longest: 2}},
+		{"broken", []time.Time{day(1, 1), day(2, 1), day(3, 1), day(5, 1), day(7, 1), day(8, 1)}, StreakResponse{Longest: 3}},
+		{"longest in the past", []time.Time{day(1, 1), day(2, 1), day(3, 1), day(9, 1), day(10, 1)}, StreakResponse{Current: 2, Longest: 3}},
+	} {
+		if got := solveStreak(c.solves, now); got != c.want {
+			t.Errorf("Got the streak %+v for the %v solves, want %+v", got, c.name, c.want)
+		}
+	}
+
+	// The days are computed in the location of now: 23:00 in UTC is already the next
+	// day in UTC+2.
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	if got, want := solveStreak([]time.Time{day(8, 23), day(9, 1)}, now.In(loc)), (StreakResponse{Current: 1, Longest: 1}); got != want {
+		t.Errorf("Got the streak %+v in UTC+2, want %+v", got, want)
+	}
+}
+
+func TestStreakOfSolves(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	var streak StreakResponse
+	if code := s.do(t, s.streakHTTPHandler, http.MethodGet, "/me/streak", nil, nil, &streak); code != http.StatusOK || streak != (StreakResponse{}) {
+		t.Errorf("Streak without solves returned %v %+v, want no streak", code, streak)
+	}
+	if code, resp := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK || !resp.Passed {
+		t.Fatalf("Submit returned %v %+v, want a passed submission", code, resp)
+	}
+	s.submissions(t, 1, "")
+	if code := s.do(t, s.streakHTTPHandler, http.MethodGet, "/me/streak", nil, nil, &streak); code != http.StatusOK || streak != (StreakResponse{Current: 1, Longest: 1}) {
+		t.Errorf("Streak after a solve today returned %v %+v, want a streak of one day", code, streak)
+	}
+}
+
+func TestRankHistoryAccumulates(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.addUser(t, "bob")
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	history := func(username string) []RankSnapshot {
+		var ret []RankSnapshot
+		if code := s.doAs(t, username, s.historyHTTPHandler, http.MethodGet, "/me/history", nil, nil, &ret); code != http.StatusOK {
+			t.Fatalf("History returned %v, want %v", code, http.StatusOK)
+		}
+		return ret
+	}
+	if got := history(testUsername); len(got) != 0 {
+		t.Errorf("Got the history %+v before any snapshot, want none", got)
+	}
+
+	start := time.Date(2017, 3, 10, 12, 0, 0, 0, time.UTC)
+	if err := s.takeRankSnapshot(start); err != nil {
+		t.Fatalf("Failed to snapshot the ranks: %v", err)
+	}
+	if code, _ := s.submit(t, "bob", submission(t, "echo")); code != http.StatusOK {
+		t.Fatalf("Submit returned %v, want %v", code, http.StatusOK)
+	}
+	s.waitForAttempts(t, "bob", 1)
+	if err := s.takeRankSnapshot(start.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to snapshot the ranks: %v", err)
+	}
+
+	for _, c := range []struct {
+		username string
+		want     []RankSnapshot
+	}{
+		{testUsername, []RankSnapshot{{Rank: 1, TakenAt: start}, {Rank: 2, TakenAt: start.Add(time.Hour)}}},
+		{"bob", []RankSnapshot{{Rank: 2, TakenAt: start}, {Rank: 1, Score: 1, TakenAt: start.Add(time.Hour)}}},
+	} {
+		got := history(c.username)
+		if len(got) != len(c.want) {
+			t.Fatalf("Got the history %+v of %v, want %+v", got, c.username, c.want)
+		}
+		for i := range got {
+			if got[i].Rank != c.want[i].Rank || got[i].Score != c.want[i].Score || !got[i].TakenAt.Equal(c.want[i].TakenAt) {
+				t.Errorf("Got the history %+v of %v, want %+v", got, c.username, c.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package godge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sizeHistogram records the distribution of the submission sizes.
+type sizeHistogram struct {
+	sync.Mutex
+	// The sorted upper bounds of the buckets.
+	bounds []int64
+	// The number of observations in each bucket (not cumulative).
+	counts []int64
+	count  int64
+	sum    int64
+}
+
+func newSizeHistogram(bounds []int64) *sizeHistogram {
+	b := append([]int64{}, bounds...)
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+	return &sizeHistogram{
+		bounds: b,
+		counts: make([]int64, len(b)),
+	}
+}
+
+func (h *sizeHistogram) observe(size int64) {
+	h.Lock()
+	defer h.Unlock()
+	h.count++
+	h.sum += size
+	for i, b := range h.bounds {
+		if size <= b {
+			h.counts[i]++
+			return
+		}
+	}
+}
+
+type sizeBucket struct {
+	// The upper bound of the bucket in bytes.
+	LessOrEqual int64 `json:"le"`
+	// The cumulative number of submissions less than or equal the upper bound.
+	Count int64 `json:"count"`
+}
+
+type sizeHistogramSnapshot struct {
+	Buckets []sizeBucket `json:"buckets"`
+	Count   int64        `json:"count"`
+	Sum     int64        `json:"sum"`
+}
+
+func (h *sizeHistogram) snapshot() sizeHistogramSnapshot {
+	h.Lock()
+	defer h.Unlock()
+	ret := sizeHistogramSnapshot{
+		Buckets: []sizeBucket{},
+		Count:   h.count,
+		Sum:     h.sum,
+	}
+	var c int64
+	for i, b := range h.bounds {
+		c += h.counts[i]
+		ret.Buckets = append(ret.Buckets, sizeBucket{LessOrEqual: b, Count: c})
+	}
+	return ret
+}
+
+// Handles the submission sizes histogram requests.
+func (s *Server) sizesHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(s.submissionSizes.snapshot()); err != nil {
+		httpJSONError(w, "Failed to encode histogram", http.StatusInternalServerError)
+		return
+	}
+}
+
+var metricLabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Handles metrics requests. The metrics are exposed in the prometheus text format.
+func (s *Server) metricsHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	ts := s.tasks.names()
+	sort.Strings(ts)
+
+	us, err := userQ.usernames(s.db)
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to fetch users: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sort.Strings(us)
+
+	// The metrics are public, so the results judged while frozen stay hidden.
+	hideFrozen := s.phase(time.Now()) == ContestFrozen
+	var verdicts []struct {
+		Verdict string `db:"verdict"`
+		Count   int    `db:"count"`
+	}
+	if err := s.db.Select(&verdicts, "SELECT verdict, COUNT(*) AS count FROM scoreboard WHERE 1=1"+frozenFilter(hideFrozen)+" GROUP BY verdict ORDER BY verdict"); err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to count submissions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "# HELP godge_users The number of registered users.")
+	fmt.Fprintln(buf, "# TYPE godge_users gauge")
+	fmt.Fprintf(buf, "godge_users %v\n", len(us))
+	fmt.Fprintln(buf, "# HELP godge_tasks The number of registered tasks.")
+	fmt.Fprintln(buf, "# TYPE godge_tasks gauge")
+	fmt.Fprintf(buf, "godge_tasks %v\n", len(ts))
+	fmt.Fprintln(buf, "# HELP godge_submissions_total The number of judged submissions by verdict.")
+	fmt.Fprintln(buf, "# TYPE godge_submissions_total counter")
+	for _, v := range verdicts {
+		fmt.Fprintf(buf, "godge_submissions_total{verdict=\"%v\"} %v\n", metricLabelReplacer.Replace(v.Verdict), v.Count)
+	}
+
+	sizes := s.submissionSizes.snapshot()
+	fmt.Fprintln(buf, "# HELP godge_submission_size_bytes The size of the submission request bodies.")
+	fmt.Fprintln(buf, "# TYPE godge_submission_size_bytes histogram")
+	for _, b := range sizes.Buckets {
+		fmt.Fprintf(buf, "godge_submission_size_bytes_bucket{le=\"%v\"} %v\n", b.LessOrEqual, b.Count)
+	}
+	fmt.Fprintf(buf, "godge_submission_size_bytes_bucket{le=\"+Inf\"} %v\n", sizes.Count)
+	fmt.Fprintf(buf, "godge_submission_size_bytes_sum %v\n", sizes.Sum)
+	fmt.Fprintf(buf, "godge_submission_size_bytes_count %v\n", sizes.Count)
+
+	if s.MetricsMaxUsers > 0 {
+		scoreboard, err := buildScoreboard(s.db, us, ts, s.tasks.points(), s.TieBreaker, hideFrozen)
+		if err != nil {
+			httpJSONError(w, fmt.Sprintf("Failed to build scoreboard: %v", err), http.StatusInternalServerError)
+			return
+		}
+		// The scoreboard rows are sorted by score, so the top users are exported.
+		rows := scoreboard.Rows
+		if len(rows) > s.MetricsMaxUsers {
+			rows = rows[:s.MetricsMaxUsers]
+		}
+		fmt.Fprintln(buf, "# HELP godge_user_solves The number of tasks solved by the user.")
+		fmt.Fprintln(buf, "# TYPE godge_user_solves gauge")
+		for _, row := range rows {
+			fmt.Fprintf(buf, "godge_user_solves{user=\"%v\"} %v\n", metricLabelReplacer.Replace(row.Username), row.solves())
+		}
+		fmt.Fprintln(buf, "# HELP godge_user_score The score of the user on the scoreboard.")
+		fmt.Fprintln(buf, "# TYPE godge_user_score gauge")
+		for _, row := range rows {
+			fmt.Fprintf(buf, "godge_user_score{user=\"%v\"} %v\n", metricLabelReplacer.Replace(row.Username), row.score())
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,75 @@
+package godge
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSizeHistogramBuckets(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	s.submissionSizes = newSizeHistogram([]int64{100, 10, 1000})
+	for _, size := range []int64{5, 10, 50, 500, 5000} {
+		s.submissionSizes.observe(size)
+	}
+
+	var got sizeHistogramSnapshot
+	if code := s.do(t, s.sizesHTTPHandler, http.MethodGet, "/stats/sizes", nil, nil, &got); code != http.StatusOK {
+		t.Fatalf("Sizes returned %v, want %v", code, http.StatusOK)
+	}
+	want := sizeHistogramSnapshot{
+		Buckets: []sizeBucket{{LessOrEqual: 10, Count: 2}, {LessOrEqual: 100, Count: 3}, {LessOrEqual: 1000, Count: 4}},
+		Count:   5,
+		Sum:     5565,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got the histogram %+v, want %+v", got, want)
+	}
+
+	w := serve(s.metricsHTTPHandler, "", http.MethodGet, "/metrics", nil, nil)
+	for _, l := range []string{`godge_submission_size_bytes_bucket{le="100"} 3`, `godge_submission_size_bytes_bucket{le="+Inf"} 5`, "godge_submission_size_bytes_sum 5565"} {
+		if !strings.Contains(w.Body.String(), l+"\n") {
+			t.Errorf("Got the metrics %v, want %v", w.Body.String(), l)
+		}
+	}
+}
+
+func TestMetricsPerUserCapped(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.addUser(t, "bob")
+	s.addUser(t, "carol")
+	s.MetricsMaxUsers = 2
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	if err := s.RegisterTask(Task{Name: "bye", Tests: []Test{outputTest("bye", "bye")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	for _, sub := range []struct{ username, task string }{{testUsername, "echo"}, {"bob", "echo"}, {"carol", "bye"}} {
+		if code, _ := s.submit(t, sub.username, submission(t, sub.task)); code != http.StatusOK {
+			t.Fatalf("Submit of %v returned %v, want %v", sub.username, code, http.StatusOK)
+		}
+	}
+	waitFor(t, "the submissions to be judged", func() bool { return s.count(t, "scoreboard") == 3 })
+
+	w := serve(s.metricsHTTPHandler, "", http.MethodGet, "/metrics", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Metrics returned %v, want %v", w.Code, http.StatusOK)
+	}
+	var scores []string
+	for _, l := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(l, "godge_user_score{") {
+			scores = append(scores, l)
+		}
+	}
+	want := []string{`godge_user_score{user="alice"} 1`, `godge_user_score{user="bob"} 1`}
+	if strings.Join(scores, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got the user scores %q, want the top %v users %q", scores, s.MetricsMaxUsers, want)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `godge_user_solves{user="alice"} 1`) || strings.Contains(body, `user="carol"`) {
+		t.Errorf("Got the metrics %v, want the solves of the top users only", body)
+	}
+}
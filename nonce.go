@@ -0,0 +1,78 @@
+package godge
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// NonceHeader is the header carrying the one time nonce of a submission when
+// the server requires nonces. It's exposed to be used by the command line client.
+const NonceHeader = "X-Godge-Nonce"
+
+// The duration after which an unused nonce expires.
+const nonceMaxAge = 10 * time.Minute
+
+type nonce struct {
+	username string
+	issuedAt time.Time
+}
+
+type nonces struct {
+	sync.Mutex
+	m map[string]nonce
+}
+
+// issue generates a new nonce for the user and drops the expired ones.
+func (n *nonces) issue(username string) string {
+	n.Lock()
+	defer n.Unlock()
+	now := time.Now()
+	for k, v := range n.m {
+		if now.Sub(v.issuedAt) > nonceMaxAge {
+			delete(n.m, k)
+		}
+	}
+	id := randomString(32)
+	n.m[id] = nonce{username: username, issuedAt: now}
+	return id
+}
+
+// consume invalidates the nonce and reports whether it was issued to the user
+// and still valid.
+func (n *nonces) consume(username, id string) bool {
+	n.Lock()
+	defer n.Unlock()
+	v, ok := n.m[id]
+	if !ok || v.username != username || time.Since(v.issuedAt) > nonceMaxAge {
+		return false
+	}
+	delete(n.m, id)
+	return true
+}
+
+// NonceResponse is the response returned back by the server in response to
+// the nonce request. It's exposed to be used by the command line client.
+type NonceResponse struct {
+	Nonce string `json:"nonce"`
+}
+
+// Handles the requests for issuing a new submission nonce.
+func (s *Server) nonceHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		httpMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	u, ok := s.authenticate(req)
+	if !ok {
+		httpJSONError(w, "Wrong username or password", http.StatusUnauthorized)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(NonceResponse{Nonce: s.nonces.issue(u.Username)}); err != nil {
+		httpJSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,66 @@
+package godge
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// PasswordPolicy defines the requirements of the passwords of the users. It's
+// checked when users register or change their password.
+type PasswordPolicy struct {
+	// The minimum number of characters of the password.
+	MinLength int
+	// Whether the password must contain at least one character of each class.
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+}
+
+// unmet returns the requirements of the policy that the password doesn't meet.
+func (p *PasswordPolicy) unmet(password string) []string {
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	var ret []string
+	if len([]rune(password)) < p.MinLength {
+		ret = append(ret, fmt.Sprintf("at least %v characters", p.MinLength))
+	}
+	if p.RequireUpper && !upper {
+		ret = append(ret, "an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		ret = append(ret, "a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		ret = append(ret, "a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		ret = append(ret, "a symbol")
+	}
+	return ret
+}
+
+// validatePassword returns an error listing the unmet requirements of the server's
+// password policy, if any.
+func (s *Server) validatePassword(password string) error {
+	if s.PasswordPolicy == nil {
+		return nil
+	}
+	if unmet := s.PasswordPolicy.unmet(password); len(unmet) > 0 {
+		return fmt.Errorf("the password must contain %v", strings.Join(unmet, ", "))
+	}
+	return nil
+}
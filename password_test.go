@@ -0,0 +1,33 @@
+package godge
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPasswordPolicyEnforced(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	s.PasswordPolicy = &PasswordPolicy{MinLength: 8, RequireUpper: true, RequireDigit: true}
+
+	w := s.register(t, "bob", "weak")
+	if want := "Weak password: the password must contain at least 8 characters, an uppercase letter, a digit"; w.Code != http.StatusBadRequest || errorOf(t, w) != want {
+		t.Errorf("Registering with a weak password returned %v %v, want %v %v", w.Code, w.Body.String(), http.StatusBadRequest, want)
+	}
+	if w := s.register(t, "bob", "Strong123"); w.Code != http.StatusCreated {
+		t.Errorf("Registering with a strong password returned %v, want %v", w.Code, http.StatusCreated)
+	}
+
+	if code := s.do(t, s.passwordHTTPHandler, http.MethodPost, "/me/password", []byte(`{"password": "nodigits"}`), nil, nil); code != http.StatusBadRequest {
+		t.Errorf("Changing to a weak password returned %v, want %v", code, http.StatusBadRequest)
+	}
+	if code := s.do(t, s.passwordHTTPHandler, http.MethodPost, "/me/password", []byte(`{"password": "Changed99"}`), nil, nil); code != http.StatusOK {
+		t.Fatalf("Changing to a strong password returned %v, want %v", code, http.StatusOK)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/submissions", nil)
+	req.SetBasicAuth(testUsername, "Changed99")
+	if _, ok := s.authenticate(req); !ok {
+		t.Errorf("Failed to authenticate with the changed password")
+	}
+}
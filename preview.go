@@ -0,0 +1,174 @@
+package godge
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPreviewUsers = 10
+	defaultPreviewTasks = 5
+	maxPreviewSize      = 100
+)
+
+// previewScoreboard returns a scoreboard of synthetic users and tasks with random
+// results. The same sizes always give the same scoreboard.
+func previewScoreboard(users, tasks int, tieBreaker TieBreaker, unattempted string) *scoreboard {
+	r := rand.New(rand.NewSource(1))
+	start := time.Now().Add(-3 * time.Hour)
+	ret := &scoreboard{Unattempted: unattempted}
+	for t := 0; t < tasks; t++ {
+		ret.Tasks = append(ret.Tasks, fmt.Sprintf("Task%v", t+1))
+	}
+	for u := 0; u < users; u++ {
+		row := scoreboardRow{Username: fmt.Sprintf("user%v", u+1)}
+		for range ret.Tasks {
+			c := scoreboardCell{MaxPoints: 1}
+			switch r.Intn(3) {
+			case 0:
+				// Unattempted.
+			case 1:
+				c.Verdict = failedVerdict
+				c.Attempts = 1 + r.Intn(3)
+				c.SubmittedAt = start.Add(time.Duration(r.Intn(180)) * time.Minute)
+				row.WrongSubmissions += c.Attempts
+			case 2:
+				c.Verdict = passedVerdict
+				c.Attempts = 1 + r.Intn(3)
+				c.Points = 1
+				c.SubmittedAt = start.Add(time.Duration(r.Intn(180)) * time.Minute)
+				c.SolvedAt = c.SubmittedAt
+				row.WrongSubmissions += c.Attempts - 1
+			}
+			row.Cells = append(row.Cells, c)
+		}
+		ret.Rows = append(ret.Rows, row)
+	}
+	ret.rank(tieBreaker)
+	return ret
+}
+
+// previewSize parses the size passed in the given query param.
+func previewSize(req *http.Request, param string, def int) (int, error) {
+	v := req.URL.Query().Get(param)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > maxPreviewSize {
+		return 0, fmt.Errorf("invalid %v: %v", param, v)
+	}
+	return n, nil
+}
+
+// SimulationRequest represents the request to simulate the scoreboard with other
+// points. Tasks that are not set keep their current points.
+type SimulationRequest struct {
+	Points map[string]int `json:"points"`
+}
+
+// SimulationResponse is the response of the scoreboard simulation, holding both
+// the current and the simulated scoreboards to compare them.
+type SimulationResponse struct {
+	Current   ScoreboardResponse `json:"current"`
+	Simulated ScoreboardResponse `json:"simulated"`
+}
+
+// Handles the requests simulating the scoreboard if the tasks were worth other
+// points (e.g. before changing them through /admin/tasks/<name>/points). The scores
+// are derived from the stored fractions of the points, so nothing is judged again
+// and no state is changed.
+func (s *Server) scoreboardSimulationHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		httpMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if _, ok := s.authenticateAdmin(w, req); !ok {
+		return
+	}
+
+	var sreq SimulationRequest
+	if err := json.NewDecoder(req.Body).Decode(&sreq); err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to decode request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	current := s.tasks.points()
+	simulated := make(map[string]int)
+	for t, p := range current {
+		simulated[t] = p
+	}
+	for t, p := range sreq.Points {
+		if _, ok := current[t]; !ok {
+			httpJSONError(w, fmt.Sprintf("Task %v not found", t), http.StatusBadRequest)
+			return
+		}
+		if p < 1 {
+			httpJSONError(w, fmt.Sprintf("The points of task %v must be at least 1", t), http.StatusBadRequest)
+			return
+		}
+		simulated[t] = p
+	}
+
+	ts := s.tasks.names()
+	if s.TaskOrder != nil {
+		s.tasks.sort(ts, s.TaskOrder)
+	} else {
+		sort.Strings(ts)
+	}
+	us, err := userQ.usernames(s.db)
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to fetch users: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sort.Strings(us)
+	before, err := buildScoreboard(s.db, us, ts, current, s.TieBreaker, false)
+	if err != nil {
+		httpJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	after, err := buildScoreboard(s.db, us, ts, simulated, s.TieBreaker, false)
+	if err != nil {
+		httpJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := SimulationResponse{Current: before.response(), Simulated: after.response()}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		httpJSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Handles the requests of the scoreboard rendered with synthetic data, to check
+// how it looks before the contest. The number of synthetic users and tasks can be
+// set using the "users" and "tasks" query params. No real data is read or written.
+func (s *Server) scoreboardPreviewHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if _, ok := s.authenticateAdmin(w, req); !ok {
+		return
+	}
+	users, err := previewSize(req, "users", defaultPreviewUsers)
+	if err != nil {
+		httpJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tasks, err := previewSize(req, "tasks", defaultPreviewTasks)
+	if err != nil {
+		httpJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/html")
+	scoreboardTmpl.Execute(w, map[string]interface{}{
+		"Scoreboard": previewScoreboard(users, tasks, s.TieBreaker, s.UnattemptedPlaceholder),
+	})
+}
@@ -0,0 +1,100 @@
+package godge
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScoreboardPreviewRendersSyntheticData(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.Admins = []string{testUsername}
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	if code, _ := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK {
+		t.Fatalf("Submit returned %v, want %v", code, http.StatusOK)
+	}
+	s.submissions(t, 1, "")
+	tables := []string{"users", "scoreboard"}
+	before := make(map[string]int)
+	for _, table := range tables {
+		before[table] = s.count(t, table)
+	}
+
+	w := serve(s.scoreboardPreviewHTTPHandler, testUsername, http.MethodGet, "/admin/scoreboard/preview?users=3&tasks=2", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Preview returned %v, want %v", w.Code, http.StatusOK)
+	}
+	body := w.Body.String()
+	for _, want := range []string{"user1", "user3", "Task1", "Task2"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("The preview doesn't contain %v", want)
+		}
+	}
+	for _, unwanted := range []string{"user4", "Task3", testUsername, "echo"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("The preview contains %v", unwanted)
+		}
+	}
+	for _, table := range tables {
+		if n := s.count(t, table); n != before[table] {
+			t.Errorf("Got %v rows in %v after the preview, want %v", n, table, before[table])
+		}
+	}
+
+	for _, query := range []string{"users=-1", "tasks=101", "users=many"} {
+		if w := serve(s.scoreboardPreviewHTTPHandler, testUsername, http.MethodGet, "/admin/scoreboard/preview?"+query, nil, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("Preview with %v returned %v, want %v", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestScoreboardSimulation(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.addUser(t, "bob")
+	s.Admins = []string{testUsername}
+	for _, task := range []Task{
+		{Name: "echo", Points: 3, Tests: []Test{outputTest("hello", "hello")}},
+		{Name: "half", Points: 2, PartialCredit: true, Tests: []Test{outputTest("hello", "hello"), outputTest("bye", "bye")}},
+	} {
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+	if code, _ := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK {
+		t.Fatalf("Submit to echo returned %v, want %v", code, http.StatusOK)
+	}
+	if code, _ := s.submit(t, "bob", submission(t, "half")); code != http.StatusOK {
+		t.Fatalf("Submit to half returned %v, want %v", code, http.StatusOK)
+	}
+	s.submissions(t, 1, "")
+	s.submissionsOf(t, "bob", 1, "")
+
+	var resp SimulationResponse
+	if code := s.do(t, s.scoreboardSimulationHTTPHandler, http.MethodPost, "/admin/scoreboard/simulate", []byte(`{"points": {"half": 10}}`), nil, &resp); code != http.StatusOK {
+		t.Fatalf("Simulation returned %v, want %v", code, http.StatusOK)
+	}
+	// Half of the 10 points of half outrank the 3 points of echo.
+	if want := []string{testUsername, "bob"}; !reflect.DeepEqual(resp.Current.Users, want) {
+		t.Errorf("Got the current ranking %v, want %v", resp.Current.Users, want)
+	}
+	if want := []string{"bob", testUsername}; !reflect.DeepEqual(resp.Simulated.Users, want) {
+		t.Errorf("Got the simulated ranking %v, want %v", resp.Simulated.Users, want)
+	}
+	if p := s.tasks.points()["half"]; p != 2 {
+		t.Errorf("Got %v points of half after the simulation, want the 2 points unchanged", p)
+	}
+	if users := s.scoreboardOf(t, "").Users; !reflect.DeepEqual(users, resp.Current.Users) {
+		t.Errorf("Got the ranking %v after the simulation, want the current %v", users, resp.Current.Users)
+	}
+
+	for _, body := range []string{`{"points": {"nope": 3}}`, `{"points": {"half": 0}}`} {
+		if code := s.do(t, s.scoreboardSimulationHTTPHandler, http.MethodPost, "/admin/scoreboard/simulate", []byte(body), nil, nil); code != http.StatusBadRequest {
+			t.Errorf("Simulation with %v returned %v, want %v", body, code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package godge
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// ProblemSet groups related tasks (e.g. the tasks of a round of the contest). The
+// scoreboard can be scoped to the tasks of a single set.
+type ProblemSet struct {
+	// The name of the set used to scope the scoreboard (e.g. /scoreboard?set=<name>).
+	Name string `json:"name"`
+	// A description of the set.
+	Desc string `json:"desc"`
+	// The names of the tasks of the set. The tasks must be registered before the set.
+	Tasks []string `json:"tasks"`
+}
+
+type problemSets struct {
+	sync.RWMutex
+	m map[string]ProblemSet
+}
+
+func (p *problemSets) get(name string) (ProblemSet, bool) {
+	p.RLock()
+	defer p.RUnlock()
+	ret, ok := p.m[name]
+	return ret, ok
+}
+
+func (p *problemSets) set(ps ProblemSet) {
+	p.Lock()
+	defer p.Unlock()
+	p.m[ps.Name] = ps
+}
+
+// all returns all the problem sets sorted by name.
+func (p *problemSets) all() []ProblemSet {
+	p.RLock()
+	defer p.RUnlock()
+	ret := []ProblemSet{}
+	for _, v := range p.m {
+		ret = append(ret, v)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// RegisterProblemSet registers a new set of tasks in the server. It returns an error
+// if any of the tasks of the set is not registered.
+func (s *Server) RegisterProblemSet(ps ProblemSet) error {
+	if ps.Name == "" {
+		return fmt.Errorf("invalid problem set: the name can't be empty")
+	}
+	for _, t := range ps.Tasks {
+		if _, ok := s.tasks.get(t); !ok {
+			return fmt.Errorf("invalid problem set %v: task %v is not registered", ps.Name, t)
+		}
+	}
+	s.problemSets.set(ps)
+	return nil
+}
+
+// visibleTasks returns the names of the tasks of the set that are not drafts.
+func (s *Server) visibleTasks(ps ProblemSet) []string {
+	ret := []string{}
+	for _, name := range ps.Tasks {
+		if t, ok := s.tasks.get(name); ok && t.state() != TaskDraft {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+// Handles listing the problem sets.
+func (s *Server) problemSetsHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	sets := s.problemSets.all()
+	for i := range sets {
+		sets[i].Tasks = s.visibleTasks(sets[i])
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(sets); err != nil {
+		httpJSONError(w, "Failed to encode problem sets", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Handles listing the tasks of a problem set (e.g. /sets/<name>).
+func (s *Server) problemSetHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	parts := splitPath(req.URL.Path, "/sets/")
+	if len(parts) != 1 {
+		httpJSONError(w, "Not found", http.StatusNotFound)
+		return
+	}
+	ps, ok := s.problemSets.get(parts[0])
+	if !ok {
+		httpJSONError(w, fmt.Sprintf("Problem set %v not found", parts[0]), http.StatusNotFound)
+		return
+	}
+
+	ts := []Task{}
+	for _, name := range s.visibleTasks(ps) {
+		t, _ := s.tasks.get(name)
+		ts = append(ts, t)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(ts); err != nil {
+		httpJSONError(w, "Failed to encode tasks", http.StatusInternalServerError)
+		return
+	}
+}
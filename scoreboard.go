@@ -2,8 +2,10 @@ package godge
 
 import (
 	"database/sql"
+	"encoding/xml"
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -12,64 +14,685 @@ import (
 const (
 	failedVerdict = "Failed"
 	passedVerdict = "Passed"
+	// The verdict of submissions that couldn't be judged because of an infrastructure
+	// error. It's ignored when building the scoreboard.
+	infraErrorVerdict = "Infrastructure Error"
+	// The verdict of submissions that crashed the judge. It's ignored when building
+	// the scoreboard.
+	internalErrorVerdict = "Internal Error"
+	// The verdict of submissions whose task environment couldn't be prepared. It's
+	// ignored when building the scoreboard.
+	environmentErrorVerdict = "Environment Error"
 )
 
-func saveToScoreboard(db *sqlx.DB, user, task string, verdict string) error {
-	_, err := db.Exec("INSERT INTO scoreboard (username, task_name, verdict, submitted_at) VALUES (?,?,?,?)", user, task, verdict, time.Now())
+// The status of the passed cells on the JSON and XML scoreboards. The verdict stays
+// "Passed" in the database and the other responses for compatibility.
+const succeededStatus = "Succeeded"
+
+// The categories of the failures of the failed submissions.
+const (
+	wrongAnswerCategory         = "Wrong Answer"
+	runtimeErrorCategory        = "Runtime Error"
+	timeLimitExceededCategory   = "Time Limit Exceeded"
+	memoryLimitExceededCategory = "Memory Limit Exceeded"
+	compileErrorCategory        = "Compile Error"
+	presentationErrorCategory   = "Presentation Error"
+)
+
+// failureCategory returns the category of the error of a failed test given the
+// exit code of the last execution of the submission.
+func failureCategory(err error, exitCode int) string {
+	if _, ok := err.(*presentationError); ok {
+		return presentationErrorCategory
+	}
+	switch {
+	case err == errTimeLimitExceeded:
+		return timeLimitExceededCategory
+	case err == errMemoryLimitExceeded:
+		return memoryLimitExceededCategory
+	case err == errCompilationFailed, err == errCompileOutputTooLarge:
+		return compileErrorCategory
+	case exitCode != 0:
+		return runtimeErrorCategory
+	default:
+		return wrongAnswerCategory
+	}
+}
+
+// verdictOf returns the verdict of a submission given the error of its execution.
+func verdictOf(err error) string {
+	switch {
+	case err == nil:
+		return passedVerdict
+	case isInfrastructureError(err):
+		return infraErrorVerdict
+	case isInternalError(err):
+		return internalErrorVerdict
+	case isEnvironmentError(err):
+		return environmentErrorVerdict
+	default:
+		return failedVerdict
+	}
+}
+
+func saveToScoreboard(db *sqlx.DB, sub *Submission, verdict string, frozen bool) error {
+	_, err := db.Exec("INSERT INTO scoreboard (submission_id, username, task_name, verdict, tags, submitted_at, score, passed_tests, total_tests, language, frozen, seed, category) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)", sub.id, sub.Username, sub.TaskName, verdict, strings.Join(sub.Tags, ","), time.Now(), sub.result.Score, sub.result.Passed, sub.result.Total, sub.Language, frozen, sub.Seed, sub.category)
 	if err != nil {
 		return fmt.Errorf("failed to save scoreboard record: %v", err)
 	}
+	_, err = db.Exec("INSERT INTO submission_sources (submission_id, source) VALUES (?,?)", sub.id, sub.Executor.source())
+	if err != nil {
+		return fmt.Errorf("failed to save submission source: %v", err)
+	}
+	for _, d := range sub.diffs {
+		if _, err := db.Exec("INSERT INTO submission_diffs (submission_id, case_name, diff) VALUES (?,?,?)", sub.id, d.Case, d.Diff); err != nil {
+			return fmt.Errorf("failed to save submission diff: %v", err)
+		}
+	}
+	return nil
+}
+
+// frozenFilter returns the condition excluding the submissions judged while the
+// scoreboard was frozen if hide is true.
+func frozenFilter(hide bool) string {
+	if hide {
+		return " AND frozen=0"
+	}
+	return ""
+}
+
+// getLastSequentialID returns the greatest of the sequential (numeric) submission
+// IDs, or zero if there are none.
+func getLastSequentialID(db *sqlx.DB) (int64, error) {
+	var last int64
+	if err := db.Get(&last, "SELECT COALESCE(MAX(CAST(submission_id AS INTEGER)), 0) FROM scoreboard WHERE submission_id GLOB '[1-9]*' AND submission_id NOT GLOB '*[^0-9]*'"); err != nil {
+		return 0, fmt.Errorf("failed to get the last submission id: %v", err)
+	}
+	return last, nil
+}
+
+// getSubmissionOwner returns the username of the submitter of the submission with the given id.
+func getSubmissionOwner(db *sqlx.DB, id string) (string, error) {
+	var username string
+	if err := db.Get(&username, "SELECT username FROM scoreboard WHERE submission_id=? LIMIT 1", id); err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+// getSubmissionDiffs returns the diffs of the failed sample cases of the submission.
+func getSubmissionDiffs(db *sqlx.DB, id string) ([]CaseDiff, error) {
+	ret := []CaseDiff{}
+	if err := db.Select(&ret, "SELECT case_name AS \"case\", diff FROM submission_diffs WHERE submission_id=? ORDER BY id", id); err != nil {
+		return nil, fmt.Errorf("failed to get submission diffs: %v", err)
+	}
+	return ret, nil
+}
+
+// getSubmissionSource returns the source archive of the submission with the given id.
+func getSubmissionSource(db *sqlx.DB, id string) ([]byte, error) {
+	var source []byte
+	if err := db.Get(&source, "SELECT source FROM submission_sources WHERE submission_id=?", id); err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+// saveSubmissionLogs saves the logs of the last container of the submission.
+func saveSubmissionLogs(db *sqlx.DB, id, stdout, stderr string) error {
+	if _, err := db.Exec("INSERT OR REPLACE INTO submission_logs (submission_id, stdout, stderr) VALUES (?,?,?)", id, stdout, stderr); err != nil {
+		return fmt.Errorf("failed to save submission logs: %v", err)
+	}
 	return nil
 }
 
-func getFromScoreboard(db *sqlx.DB, user, task string) (*string, error) {
-	var res string
-	err := db.Get(&res, "SELECT verdict FROM scoreboard WHERE username=? AND task_name=? ORDER BY ID DESC LIMIT 1", user, task)
+// getSubmissionLogs returns the saved stdout and stderr of the submission with the given id.
+func getSubmissionLogs(db *sqlx.DB, id string) (string, string, error) {
+	var res struct {
+		Stdout string `db:"stdout"`
+		Stderr string `db:"stderr"`
+	}
+	if err := db.Get(&res, "SELECT stdout, stderr FROM submission_logs WHERE submission_id=?", id); err != nil {
+		return "", "", err
+	}
+	return res.Stdout, res.Stderr, nil
+}
+
+// scoreboardCell is the result of a single user on a single task.
+type scoreboardCell struct {
+	// The verdict of the user's best submission. Empty if the user didn't attempt the task.
+	Verdict string
+	// The time of the user's latest submission.
+	SubmittedAt time.Time
+	// The time of the user's first passing submission. Zero if the task is not solved.
+	SolvedAt time.Time
+	// The number of judged (passed or failed) submissions of the user in the task.
+	Attempts int
+	// The points earned by the user's best submission and the points the task is worth.
+	Points    int
+	MaxPoints int
+	// The tests passed by the user's best submission out of the executed ones.
+	PassedTests int
+	TotalTests  int
+	// The category of the failure of the user's best submission if it failed.
+	Category string
+}
+
+// ScoreboardCell is the JSON representation of the result of a single user on a
+// single task. It's exposed to be used by the command line client.
+type ScoreboardCell struct {
+	// The status of the user's best submission ("Succeeded" or "Failed"), or the
+	// server's UnattemptedPlaceholder if the user didn't attempt the task.
+	Status   string `json:"status" xml:"status"`
+	Attempts int    `json:"attempts" xml:"attempts"`
+	// The time of the user's latest judged submission in the task.
+	SubmittedAt *time.Time `json:"submittedAt" xml:"submittedAt,omitempty"`
+	SolvedAt    *time.Time `json:"solvedAt" xml:"solvedAt,omitempty"`
+	Points      int        `json:"points" xml:"points"`
+	MaxPoints   int        `json:"maxPoints" xml:"maxPoints"`
+	PassedTests int        `json:"passedTests" xml:"passedTests"`
+	TotalTests  int        `json:"totalTests" xml:"totalTests"`
+	// The category of the failure if the server reports detailed verdicts.
+	Category string `json:"category,omitempty" xml:"category,omitempty"`
+}
+
+// ScoreboardRow is the JSON representation of the results of a single user.
+type ScoreboardRow struct {
+	Username string           `json:"username" xml:"username"`
+	Score    int              `json:"score" xml:"score"`
+	Cells    []ScoreboardCell `json:"cells" xml:"cells>cell"`
+}
+
+// ScoreboardResponse is the response of the JSON (or XML) scoreboard. The cells of
+// each row are in the same order as the tasks.
+type ScoreboardResponse struct {
+	XMLName xml.Name `json:"-" xml:"scoreboard"`
+	// The users in the order of the rows.
+	Users []string        `json:"users" xml:"users>user"`
+	Tasks []string        `json:"tasks" xml:"tasks>task"`
+	Rows  []ScoreboardRow `json:"rows" xml:"rows>row"`
+	// The requested users that are not registered, if the scoreboard was filtered by user.
+	Unknown []string `json:"unknown,omitempty" xml:"unknown>user,omitempty"`
+}
+
+// scoreboardRow holds the results of a single user.
+type scoreboardRow struct {
+	Username string
+	Cells    []scoreboardCell
+	// The number of failed submissions of the user in all the tasks.
+	WrongSubmissions int
+}
+
+// entry returns the summary of the row used to break the ties.
+func (r scoreboardRow) entry() ScoreboardEntry {
+	e := ScoreboardEntry{
+		Username:         r.Username,
+		Score:            r.score(),
+		WrongSubmissions: r.WrongSubmissions,
+	}
+	for _, c := range r.Cells {
+		if c.Verdict == passedVerdict && c.SolvedAt.After(e.LastSolveAt) {
+			e.LastSolveAt = c.SolvedAt
+		}
+	}
+	return e
+}
+
+// ScoreboardEntry summarizes the results of a single user on the scoreboard.
+type ScoreboardEntry struct {
+	Username string
+	Score    int
+	// The time of the latest task solved by the user.
+	LastSolveAt time.Time
+	// The number of failed submissions of the user in all the tasks.
+	WrongSubmissions int
+}
+
+// TieBreaker decides the order of two users having the same score on the scoreboard.
+// It returns true if a should be ranked before b.
+type TieBreaker func(a, b ScoreboardEntry) bool
+
+var (
+	// EarliestLastSolve ranks first the user who reached their score first. Users
+	// without solves (e.g. with partial points only) rank after the ones with solves.
+	EarliestLastSolve TieBreaker = func(a, b ScoreboardEntry) bool {
+		if a.LastSolveAt.IsZero() || b.LastSolveAt.IsZero() {
+			return !a.LastSolveAt.IsZero()
+		}
+		return a.LastSolveAt.Before(b.LastSolveAt)
+	}
+	// FewestWrongSubmissions ranks first the user with the fewest failed submissions.
+	FewestWrongSubmissions TieBreaker = func(a, b ScoreboardEntry) bool {
+		return a.WrongSubmissions < b.WrongSubmissions
+	}
+	// Alphabetical ranks the users alphabetically by their username.
+	Alphabetical TieBreaker = func(a, b ScoreboardEntry) bool {
+		return a.Username < b.Username
+	}
+)
+
+// TaskOrder decides the order of the task columns of the scoreboard. It returns
+// true if a should be shown before b.
+type TaskOrder func(a, b Task) bool
+
+var (
+	// ByName orders the tasks alphabetically.
+	ByName TaskOrder = func(a, b Task) bool {
+		return a.Name < b.Name
+	}
+	// ByOrder orders the tasks by their Order, then alphabetically.
+	ByOrder TaskOrder = func(a, b Task) bool {
+		if a.Order != b.Order {
+			return a.Order < b.Order
+		}
+		return a.Name < b.Name
+	}
+	// ByPoints orders the tasks from the ones worth the fewest points to the ones
+	// worth the most, then alphabetically.
+	ByPoints TaskOrder = func(a, b Task) bool {
+		if a.maxPoints() != b.maxPoints() {
+			return a.maxPoints() < b.maxPoints()
+		}
+		return a.Name < b.Name
+	}
+)
+
+// score returns the score of the user on the scoreboard, the sum of their points in all the tasks.
+func (r scoreboardRow) score() int {
+	var sc int
+	for _, c := range r.Cells {
+		sc += c.Points
+	}
+	return sc
+}
+
+// solves returns the number of tasks solved by the user.
+func (r scoreboardRow) solves() int {
+	var n int
+	for _, c := range r.Cells {
+		if c.Verdict == passedVerdict {
+			n++
+		}
+	}
+	return n
+}
+
+// scoreboard holds the results of all the users sorted by their score.
+type scoreboard struct {
+	Tasks []string
+	Rows  []scoreboardRow
+	// The status shown in the cells of the tasks a user didn't attempt.
+	Unattempted string
+	// The requested users that are not registered, if the scoreboard was filtered by user.
+	Unknown []string
+	// Whether the categories of the failures are shown.
+	Detailed bool
+}
+
+// in converts all the timestamps of the scoreboard to the given location.
+func (s *scoreboard) in(loc *time.Location) {
+	for _, r := range s.Rows {
+		for i := range r.Cells {
+			if !r.Cells[i].SubmittedAt.IsZero() {
+				r.Cells[i].SubmittedAt = r.Cells[i].SubmittedAt.In(loc)
+			}
+			if !r.Cells[i].SolvedAt.IsZero() {
+				r.Cells[i].SolvedAt = r.Cells[i].SolvedAt.In(loc)
+			}
+		}
+	}
+}
+
+// response returns the JSON representation of the scoreboard.
+func (s *scoreboard) response() ScoreboardResponse {
+	ret := ScoreboardResponse{
+		Users:   []string{},
+		Tasks:   s.Tasks,
+		Rows:    []ScoreboardRow{},
+		Unknown: s.Unknown,
+	}
+	for _, r := range s.Rows {
+		ret.Users = append(ret.Users, r.Username)
+		row := ScoreboardRow{Username: r.Username, Score: r.score(), Cells: []ScoreboardCell{}}
+		for _, c := range r.Cells {
+			cell := ScoreboardCell{
+				Status:      c.Verdict,
+				Attempts:    c.Attempts,
+				Points:      c.Points,
+				MaxPoints:   c.MaxPoints,
+				PassedTests: c.PassedTests,
+				TotalTests:  c.TotalTests,
+			}
+			if s.Detailed {
+				cell.Category = c.Category
+			}
+			switch c.Verdict {
+			case "":
+				cell.Status = s.Unattempted
+			case passedVerdict:
+				cell.Status = succeededStatus
+			}
+			if !c.SubmittedAt.IsZero() {
+				submittedAt := c.SubmittedAt
+				cell.SubmittedAt = &submittedAt
+			}
+			if !c.SolvedAt.IsZero() {
+				solvedAt := c.SolvedAt
+				cell.SolvedAt = &solvedAt
+			}
+			row.Cells = append(row.Cells, cell)
+		}
+		ret.Rows = append(ret.Rows, row)
+	}
+	return ret
+}
+
+// getFromScoreboard returns the result of the user in the task. maxPoints is the
+// number of points the task is worth. The cell holds the user's best submission,
+// so a later worse submission doesn't lose the points of a previous one. The
+// submissions judged while the scoreboard was frozen are ignored if hideFrozen is true.
+func getFromScoreboard(db *sqlx.DB, user, task string, maxPoints int, hideFrozen bool) (scoreboardCell, error) {
+	var res struct {
+		Verdict     string  `db:"verdict"`
+		Score       float64 `db:"score"`
+		PassedTests int     `db:"passed_tests"`
+		TotalTests  int     `db:"total_tests"`
+		Category    string  `db:"category"`
+	}
+	err := db.Get(&res, "SELECT verdict, score, passed_tests, total_tests, category FROM scoreboard WHERE username=? AND task_name=? AND verdict IN (?,?)"+frozenFilter(hideFrozen)+" ORDER BY score DESC, ID DESC LIMIT 1", user, task, passedVerdict, failedVerdict)
 	if err == sql.ErrNoRows {
-		e := ""
-		return &e, nil
+		return scoreboardCell{MaxPoints: maxPoints}, nil
+	}
+	if err != nil {
+		return scoreboardCell{}, fmt.Errorf("failed to get from scoreboard: %v", err)
+	}
+	var submittedAt time.Time
+	err = db.Get(&submittedAt, "SELECT submitted_at FROM scoreboard WHERE username=? AND task_name=? AND verdict IN (?,?)"+frozenFilter(hideFrozen)+" ORDER BY ID DESC LIMIT 1", user, task, passedVerdict, failedVerdict)
+	if err != nil {
+		return scoreboardCell{}, fmt.Errorf("failed to get from scoreboard: %v", err)
+	}
+	var attempts int
+	err = db.Get(&attempts, "SELECT COUNT(*) FROM scoreboard WHERE username=? AND task_name=? AND verdict IN (?,?)"+frozenFilter(hideFrozen), user, task, passedVerdict, failedVerdict)
+	if err != nil {
+		return scoreboardCell{}, fmt.Errorf("failed to count attempts: %v", err)
+	}
+	return scoreboardCell{
+		Verdict:     res.Verdict,
+		SubmittedAt: submittedAt,
+		Attempts:    attempts,
+		Points:      int(res.Score*float64(maxPoints) + 0.5),
+		MaxPoints:   maxPoints,
+		PassedTests: res.PassedTests,
+		TotalTests:  res.TotalTests,
+		Category:    res.Category,
+	}, nil
+}
+
+// SubmissionRecord represents a single past submission of a user. It's exposed
+// to be used by the command line client.
+type SubmissionRecord struct {
+	ID          string    `json:"id"`
+	TaskName    string    `json:"taskName"`
+	Verdict     string    `json:"verdict"`
+	Tags        []string  `json:"tags"`
+	SubmittedAt time.Time `json:"submittedAt"`
+}
+
+// getSubmissions returns the submissions of the user ordered from the newest to the oldest.
+// If tag is not empty, only the submissions tagged with it are returned.
+func getSubmissions(db *sqlx.DB, user, tag string) ([]SubmissionRecord, error) {
+	var rows []struct {
+		ID          sql.NullString `db:"submission_id"`
+		TaskName    string         `db:"task_name"`
+		Verdict     string         `db:"verdict"`
+		Tags        sql.NullString `db:"tags"`
+		SubmittedAt time.Time      `db:"submitted_at"`
+	}
+	err := db.Select(&rows, "SELECT submission_id, task_name, verdict, tags, submitted_at FROM scoreboard WHERE username=? ORDER BY ID DESC", user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get submissions: %v", err)
+	}
+
+	ret := []SubmissionRecord{}
+	for _, r := range rows {
+		var tags []string
+		if r.Tags.String != "" {
+			tags = strings.Split(r.Tags.String, ",")
+		}
+		if tag != "" && !containsString(tags, tag) {
+			continue
+		}
+		ret = append(ret, SubmissionRecord{
+			ID:          r.ID.String,
+			TaskName:    r.TaskName,
+			Verdict:     r.Verdict,
+			Tags:        tags,
+			SubmittedAt: r.SubmittedAt,
+		})
+	}
+	return ret, nil
+}
+
+// ActivityEvent represents a single judged submission in the global activity feed.
+// It's exposed to be used by the command line client.
+type ActivityEvent struct {
+	Username    string    `json:"username"`
+	TaskName    string    `json:"taskName"`
+	Verdict     string    `json:"verdict"`
+	SubmittedAt time.Time `json:"submittedAt"`
+}
+
+// getActivity returns the latest limit judged (passed or failed) submissions of all
+// the users in the given tasks ordered from the newest to the oldest.
+func getActivity(db *sqlx.DB, tasks []string, limit int, hideFrozen bool) ([]ActivityEvent, error) {
+	ret := []ActivityEvent{}
+	if len(tasks) == 0 {
+		return ret, nil
 	}
+	query, args, err := sqlx.In("SELECT username, task_name, verdict, submitted_at FROM scoreboard WHERE verdict IN (?) AND task_name IN (?)"+frozenFilter(hideFrozen)+" ORDER BY ID DESC LIMIT ?", []string{passedVerdict, failedVerdict}, tasks, limit)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get from scoreboard: %v", err)
+		return nil, fmt.Errorf("failed to build activity query: %v", err)
+	}
+	var rows []struct {
+		Username    string    `db:"username"`
+		TaskName    string    `db:"task_name"`
+		Verdict     string    `db:"verdict"`
+		SubmittedAt time.Time `db:"submitted_at"`
 	}
-	return &res, nil
+	if err := db.Select(&rows, db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("failed to get activity: %v", err)
+	}
+	for _, r := range rows {
+		ret = append(ret, ActivityEvent{
+			Username:    r.Username,
+			TaskName:    r.TaskName,
+			Verdict:     r.Verdict,
+			SubmittedAt: r.SubmittedAt,
+		})
+	}
+	return ret, nil
 }
 
-// returns a 2D array of the results (including the tasks as the first row and
-// the users as the first column). The results are rows are sorted by the score
-// of each user.
-func buildScoreboard(db *sqlx.DB, allUsers, allTasks []string) ([][]string, error) {
+// getAttemptedTasks returns the set of the tasks the user submitted to.
+func getAttemptedTasks(db *sqlx.DB, user string) (map[string]bool, error) {
+	var names []string
+	if err := db.Select(&names, "SELECT DISTINCT task_name FROM scoreboard WHERE username=?", user); err != nil {
+		return nil, fmt.Errorf("failed to get attempted tasks: %v", err)
+	}
+	ret := make(map[string]bool)
+	for _, n := range names {
+		ret[n] = true
+	}
+	return ret, nil
+}
 
-	var ret [][]string
+// getSolveTimes returns the time of the first passing submission of the user in
+// each of the tasks they solved.
+func getSolveTimes(db *sqlx.DB, user string, hideFrozen bool) (map[string]time.Time, error) {
+	var rows []struct {
+		TaskName    string    `db:"task_name"`
+		SubmittedAt time.Time `db:"submitted_at"`
+	}
+	if err := db.Select(&rows, "SELECT task_name, submitted_at FROM scoreboard WHERE username=? AND verdict=?"+frozenFilter(hideFrozen)+" ORDER BY ID", user, passedVerdict); err != nil {
+		return nil, fmt.Errorf("failed to get solve times: %v", err)
+	}
+	ret := make(map[string]time.Time)
+	for _, r := range rows {
+		if _, ok := ret[r.TaskName]; !ok {
+			ret[r.TaskName] = r.SubmittedAt
+		}
+	}
+	return ret, nil
+}
+
+// getSolvedTasks returns the set of the given tasks that the user solved in any of
+// the contests stored in the database.
+func getSolvedTasks(db *sqlx.DB, user string, tasks []string) (map[string]bool, error) {
+	ret := make(map[string]bool)
+	if len(tasks) == 0 {
+		return ret, nil
+	}
+	query, args, err := sqlx.In("SELECT DISTINCT task_name FROM scoreboard WHERE username=? AND verdict=? AND task_name IN (?)", user, passedVerdict, tasks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build solved tasks query: %v", err)
+	}
+	var names []string
+	if err := db.Select(&names, db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("failed to get solved tasks: %v", err)
+	}
+	for _, n := range names {
+		ret[n] = true
+	}
+	return ret, nil
+}
+
+// countJudgedSubmissions returns the number of the user's submissions that were
+// judged (passed or failed) in all the tasks.
+func countJudgedSubmissions(db *sqlx.DB, user string) (int, error) {
+	var count int
+	if err := db.Get(&count, "SELECT COUNT(*) FROM scoreboard WHERE username=? AND verdict IN (?,?)", user, passedVerdict, failedVerdict); err != nil {
+		return 0, fmt.Errorf("failed to count submissions: %v", err)
+	}
+	return count, nil
+}
+
+// getSubmissionBudget returns the budget set by the admins for the user, if any.
+func getSubmissionBudget(db *sqlx.DB, user string) (int, bool, error) {
+	var budget int
+	err := db.Get(&budget, "SELECT budget FROM submission_budgets WHERE username=?", user)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get submission budget: %v", err)
+	}
+	return budget, true, nil
+}
+
+func setSubmissionBudget(db *sqlx.DB, user string, budget int) error {
+	if _, err := db.Exec("INSERT OR REPLACE INTO submission_budgets (username, budget) VALUES (?,?)", user, budget); err != nil {
+		return fmt.Errorf("failed to set submission budget: %v", err)
+	}
+	return nil
+}
+
+// UserStatsResponse summarizes the activity of a single user. It's exposed to be
+// used by the command line client.
+type UserStatsResponse struct {
+	Username    string `json:"username"`
+	Submissions int    `json:"submissions"`
+	// The number of distinct tasks solved by the user.
+	Solves   int `json:"solves"`
+	Failures int `json:"failures"`
+	// The number of submissions in each of the languages used by the user.
+	Languages map[string]int `json:"languages"`
+	// The time of the user's latest submission. Null if the user never submitted.
+	LastActive *time.Time `json:"lastActive"`
+}
+
+// getUserStats aggregates all the submissions of the user.
+func getUserStats(db *sqlx.DB, user string) (UserStatsResponse, error) {
+	ret := UserStatsResponse{Username: user, Languages: make(map[string]int)}
+	var rows []struct {
+		TaskName    string    `db:"task_name"`
+		Verdict     string    `db:"verdict"`
+		Language    string    `db:"language"`
+		SubmittedAt time.Time `db:"submitted_at"`
+	}
+	if err := db.Select(&rows, "SELECT task_name, verdict, COALESCE(language, '') AS language, submitted_at FROM scoreboard WHERE username=?", user); err != nil {
+		return UserStatsResponse{}, fmt.Errorf("failed to get user stats: %v", err)
+	}
+	solved := make(map[string]bool)
+	for _, r := range rows {
+		ret.Submissions++
+		switch r.Verdict {
+		case passedVerdict:
+			solved[r.TaskName] = true
+		case failedVerdict:
+			ret.Failures++
+		}
+		if r.Language != "" {
+			ret.Languages[r.Language]++
+		}
+		if ret.LastActive == nil || r.SubmittedAt.After(*ret.LastActive) {
+			submittedAt := r.SubmittedAt
+			ret.LastActive = &submittedAt
+		}
+	}
+	ret.Solves = len(solved)
+	return ret, nil
+}
+
+func countWrongSubmissions(db *sqlx.DB, user string, hideFrozen bool) (int, error) {
+	var count int
+	if err := db.Get(&count, "SELECT COUNT(*) FROM scoreboard WHERE username=? AND verdict=?"+frozenFilter(hideFrozen), user, failedVerdict); err != nil {
+		return 0, fmt.Errorf("failed to count wrong submissions: %v", err)
+	}
+	return count, nil
+}
+
+// buildScoreboard returns the results of all the users in all the tasks. The
+// rows are sorted by the score of each user and the ties are broken using the
+// tie breaker. Users remain in the given order if tieBreaker is nil or doesn't
+// break the tie. points holds the points each task is worth. The submissions judged
+// while the scoreboard was frozen are ignored if hideFrozen is true.
+func buildScoreboard(db *sqlx.DB, allUsers, allTasks []string, points map[string]int, tieBreaker TieBreaker, hideFrozen bool) (*scoreboard, error) {
+
+	ret := &scoreboard{
+		Tasks: allTasks,
+	}
 
 	for _, u := range allUsers {
-		row := []string{u}
+		row := scoreboardRow{Username: u}
+		solves, err := getSolveTimes(db, u, hideFrozen)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build scoreboard: %v", err)
+		}
 		for _, t := range allTasks {
-			v, err := getFromScoreboard(db, u, t)
+			c, err := getFromScoreboard(db, u, t, points[t], hideFrozen)
 			if err != nil {
 				return nil, fmt.Errorf("failed to build scoreboard: %v", err)
 			}
-			row = append(row, *v)
+			c.SolvedAt = solves[t]
+			row.Cells = append(row.Cells, c)
 		}
-		ret = append(ret, row)
-	}
-
-	score := func(row []string) int {
-		var sc int
-		for _, r := range row {
-			if r == passedVerdict {
-				sc++
-			}
+		if row.WrongSubmissions, err = countWrongSubmissions(db, u, hideFrozen); err != nil {
+			return nil, fmt.Errorf("failed to build scoreboard: %v", err)
 		}
-		return sc
+		ret.Rows = append(ret.Rows, row)
 	}
 
-	sort.Slice(ret, func(i, j int) bool {
-		return score(ret[i][1:]) > score(ret[j][1:])
-	})
-
-	header := append([]string{}, append([]string{""}, allTasks...)...)
-	ret = append([][]string{header}, ret...)
-
+	ret.rank(tieBreaker)
 	return ret, nil
 }
+
+// rank sorts the rows by score, breaking the ties using the tie breaker.
+func (s *scoreboard) rank(tieBreaker TieBreaker) {
+	sort.SliceStable(s.Rows, func(i, j int) bool {
+		a, b := s.Rows[i].entry(), s.Rows[j].entry()
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return tieBreaker != nil && tieBreaker(a, b)
+	})
+}
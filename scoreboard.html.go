@@ -22,11 +22,27 @@ var scoreboardTmpl = template.Must(template.New("scoreboard").Parse(`
 		<h1>Scoreboard!</h1>
 		<table>
 			<tbody>
-				{{ range $i1, $row1 :=  $.Scoreboard }}
+				<tr>
+					<td></td>
+					{{ range $.Scoreboard.Tasks }}
+						<td>{{ . }}</td>
+					{{ end }}
+				</tr>
+				{{ range $.Scoreboard.Rows }}
 					<tr>
-						{{ range $i2, $row2 := $row1 }}
+						<td>{{ .Username }}</td>
+						{{ range .Cells }}
 							<td>
-								{{ index $.Scoreboard $i1 $i2 }}
+								{{ if and $.Scoreboard.Detailed .Category }}{{ .Category }}{{ else if .Verdict }}{{ .Verdict }}{{ else }}{{ $.Scoreboard.Unattempted }}{{ end }}{{ if .Attempts }} ({{ .Attempts }}){{ end }}
+								{{ if gt .MaxPoints 1 }}
+									<br>{{ .Points }}/{{ .MaxPoints }}
+								{{ end }}
+								{{ if and (gt .TotalTests 1) (lt .PassedTests .TotalTests) }}
+									<br><small>{{ .PassedTests }}/{{ .TotalTests }} tests</small>
+								{{ end }}
+								{{ if not .SubmittedAt.IsZero }}
+									<br><small>{{ .SubmittedAt.Format "2006-01-02 15:04:05 MST" }}</small>
+								{{ end }}
 							</td>
 						{{ end }}
 					</tr>
@@ -0,0 +1,428 @@
+package godge
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scoreboardOf fetches the JSON scoreboard with the given query.
+func (s *testServer) scoreboardOf(t *testing.T, query string) ScoreboardResponse {
+	var resp ScoreboardResponse
+	if code := s.do(t, s.scoreboardJSONHTTPHandler, http.MethodGet, "/scoreboard.json"+query, nil, nil, &resp); code != http.StatusOK {
+		t.Fatalf("Scoreboard returned %v, want %v", code, http.StatusOK)
+	}
+	return resp
+}
+
+// waitForAttempts waits until the user made n judged attempts in the first task of the scoreboard.
+func (s *testServer) waitForAttempts(t *testing.T, username string, n int) {
+	waitFor(t, "the submissions to be judged", func() bool {
+		for _, r := range s.scoreboardOf(t, "").Rows {
+			if r.Username == username {
+				return r.Cells[0].Attempts == n
+			}
+		}
+		return false
+	})
+}
+
+func TestScoreboardTimezone(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	if code, _ := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK {
+		t.Fatalf("Submit returned %v, want %v", code, http.StatusOK)
+	}
+	s.waitForAttempts(t, testUsername, 1)
+
+	w := serve(s.scoreboardHTTPHandler, "", http.MethodGet, "/scoreboard?tz=Asia/Tokyo", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Scoreboard returned %v, want %v", w.Code, http.StatusOK)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "JST") {
+		t.Errorf("Got the scoreboard %v, want the times in JST", body)
+	}
+	resp := s.scoreboardOf(t, "?tz=Asia/Tokyo")
+	if at := resp.Rows[0].Cells[0].SubmittedAt; at == nil || at.Format("-07:00") != "+09:00" {
+		t.Errorf("Got the submission time %v, want it in +09:00", at)
+	}
+
+	for _, tz := range []string{"Local", "Mars/Olympus"} {
+		w := serve(s.scoreboardHTTPHandler, "", http.MethodGet, "/scoreboard?tz="+tz, nil, nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Scoreboard in %v returned %v, want %v", tz, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestTieBreakersOrderEqualScores(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.addUser(t, "bob")
+	s.addUser(t, "zed")
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	if err := s.RegisterTask(Task{Name: "bye", Tests: []Test{outputTest("bye", "bye")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	// zed solves first with a wrong submission, alice second with two and bob last
+	// without any.
+	for i, sub := range []struct{ username, task string }{
+		{"zed", "bye"}, {"zed", "echo"},
+		{testUsername, "bye"}, {testUsername, "bye"}, {testUsername, "echo"},
+		{"bob", "echo"},
+	} {
+		if code, _ := s.submit(t, sub.username, submission(t, sub.task)); code != http.StatusOK {
+			t.Fatalf("Submit of %v returned %v, want %v", sub.username, code, http.StatusOK)
+		}
+		waitFor(t, "the submission to be judged", func() bool { return s.count(t, "scoreboard") == i+1 })
+	}
+
+	for _, c := range []struct {
+		name       string
+		tieBreaker TieBreaker
+		want       []string
+	}{
+		{"EarliestLastSolve", EarliestLastSolve, []string{"zed", testUsername, "bob"}},
+		{"FewestWrongSubmissions", FewestWrongSubmissions, []string{"bob", "zed", testUsername}},
+		{"Alphabetical", Alphabetical, []string{testUsername, "bob", "zed"}},
+	} {
+		s.TieBreaker = c.tieBreaker
+		if got := s.scoreboardOf(t, "").Users; !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Got the users %v ranked by %v, want %v", got, c.name, c.want)
+		}
+	}
+}
+
+func TestScoreboardJSONUsesCamelCase(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	if code, _ := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK {
+		t.Fatalf("Submit returned %v, want %v", code, http.StatusOK)
+	}
+	s.waitForAttempts(t, testUsername, 1)
+
+	var resp struct {
+		Rows []struct {
+			Cells []map[string]interface{} `json:"cells"`
+		} `json:"rows"`
+	}
+	if code := s.do(t, s.scoreboardJSONHTTPHandler, http.MethodGet, "/scoreboard.json", nil, nil, &resp); code != http.StatusOK {
+		t.Fatalf("Scoreboard returned %v, want %v", code, http.StatusOK)
+	}
+	cell := resp.Rows[0].Cells[0]
+	for _, k := range []string{"status", "attempts", "submittedAt", "solvedAt", "points", "maxPoints", "passedTests", "totalTests"} {
+		if _, ok := cell[k]; !ok {
+			t.Errorf("Got the cell %v, want the %v key", cell, k)
+		}
+	}
+	for k := range cell {
+		if strings.Contains(k, "_") {
+			t.Errorf("Got the snake case key %v in the cell %v", k, cell)
+		}
+	}
+}
+
+// activity returns the (username, task) of the events on /activity.
+func (s *testServer) activity(t *testing.T, query string) []string {
+	var events []ActivityEvent
+	if code := s.do(t, s.activityHTTPHandler, http.MethodGet, "/activity"+query, nil, nil, &events); code != http.StatusOK {
+		t.Fatalf("Activity returned %v, want %v", code, http.StatusOK)
+	}
+	ret := []string{}
+	for _, e := range events {
+		ret = append(ret, e.Username+" "+e.TaskName+" "+e.Verdict)
+	}
+	return ret
+}
+
+func TestActivityNewestFirstHidingFrozen(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.addUser(t, "bob")
+	s.addUser(t, "carol")
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	if err := s.RegisterTask(Task{Name: "bye", Tests: []Test{outputTest("bye", "bye")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	now := time.Now()
+	s.StartAt, s.EndAt = now.Add(-time.Hour), now.Add(time.Hour)
+	for i, sub := range []struct{ username, task string }{{testUsername, "echo"}, {"bob", "bye"}, {"carol", "echo"}} {
+		if sub.username == "carol" {
+			s.FreezeAt = time.Now()
+		}
+		if code, _ := s.submit(t, sub.username, submission(t, sub.task)); code != http.StatusOK {
+			t.Fatalf("Submit of %v returned %v, want %v", sub.username, code, http.StatusOK)
+		}
+		waitFor(t, "the submission to be judged", func() bool { return s.count(t, "scoreboard") == i+1 })
+	}
+
+	want := []string{"bob bye Failed", "alice echo Passed"}
+	if got := s.activity(t, ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got the activity %v while frozen, want %v", got, want)
+	}
+	if got := s.activity(t, "?limit=1"); !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("Got the activity %v limited to 1, want %v", got, want[:1])
+	}
+	s.EndAt = time.Now()
+	want = append([]string{"carol echo Passed"}, want...)
+	if got := s.activity(t, ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got the activity %v once ended, want %v", got, want)
+	}
+}
+
+func TestUnattemptedCellsShowPlaceholder(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.addUser(t, "bob")
+	s.UnattemptedPlaceholder = "n/a"
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	if code, _ := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK {
+		t.Fatalf("Submit returned %v, want %v", code, http.StatusOK)
+	}
+	s.waitForAttempts(t, testUsername, 1)
+
+	got := map[string]string{}
+	for _, r := range s.scoreboardOf(t, "").Rows {
+		got[r.Username] = r.Cells[0].Status
+	}
+	if want := map[string]string{testUsername: succeededStatus, "bob": "n/a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got the statuses %v, want %v", got, want)
+	}
+	w := serve(s.scoreboardHTTPHandler, "", http.MethodGet, "/scoreboard", nil, nil)
+	if n := strings.Count(w.Body.String(), "n/a"); n != 1 {
+		t.Errorf("Got the placeholder %v times in the scoreboard page, want once", n)
+	}
+}
+
+func TestScoreboardScopedToProblemSet(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	for _, task := range []Task{
+		{Name: "a", Tests: []Test{outputTest("hello", "hello")}},
+		{Name: "b", Tests: []Test{outputTest("hello", "hello")}},
+		{Name: "c", Tests: []Test{outputTest("hello", "hello")}},
+		{Name: "draft", State: TaskDraft, Tests: []Test{outputTest("hello", "hello")}},
+	} {
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+	for _, ps := range []ProblemSet{
+		{Name: "round1", Tasks: []string{"b", "a", "draft"}},
+		{Name: "round2", Tasks: []string{"c"}},
+	} {
+		if err := s.RegisterProblemSet(ps); err != nil {
+			t.Fatalf("Failed to register problem set: %v", err)
+		}
+	}
+	for _, task := range []string{"a", "c"} {
+		if code, _ := s.submit(t, testUsername, submission(t, task)); code != http.StatusOK {
+			t.Fatalf("Submit to %v returned %v, want %v", task, code, http.StatusOK)
+		}
+	}
+	s.submissions(t, 2, "")
+
+	// Only the solves of the set's tasks are counted, a point each.
+	for _, c := range []struct {
+		set   string
+		tasks []string
+		score int
+	}{
+		{"round1", []string{"a", "b"}, 1},
+		{"round2", []string{"c"}, 1},
+	} {
+		resp := s.scoreboardOf(t, "?set="+c.set)
+		if !reflect.DeepEqual(resp.Tasks, c.tasks) {
+			t.Errorf("Got the tasks %v in the %v scoreboard, want %v", resp.Tasks, c.set, c.tasks)
+		}
+		if len(resp.Rows) != 1 || len(resp.Rows[0].Cells) != len(c.tasks) || resp.Rows[0].Score != c.score {
+			t.Errorf("Got the rows %+v in the %v scoreboard, want a cell per task and a score of %v", resp.Rows, c.set, c.score)
+		}
+	}
+	if all := s.scoreboardOf(t, ""); len(all.Tasks) != 3 || all.Rows[0].Score != 2 {
+		t.Errorf("Got the unscoped scoreboard %+v, want the 3 tasks and a score of 2", all)
+	}
+
+	if w := serve(s.scoreboardJSONHTTPHandler, "", http.MethodGet, "/scoreboard.json?set=round3", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Scoreboard of an unknown set returned %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestScoreboardJSONStatusesAndOrder(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	for _, u := range []string{"bob", "dan", "eve", "zed"} {
+		s.addUser(t, u)
+	}
+	for _, task := range []Task{
+		{Name: "a", Tests: []Test{outputTest("hello", "hello")}},
+		{Name: "b", Tests: []Test{outputTest("hello", "hello")}},
+		{Name: "bye", Tests: []Test{outputTest("bye", "bye")}},
+	} {
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+	// zed solves a single task after bob, and dan only fails.
+	for i, sub := range []struct{ username, task string }{
+		{"bob", "b"}, {testUsername, "a"}, {"zed", "a"}, {testUsername, "bye"}, {"dan", "bye"}, {testUsername, "b"},
+	} {
+		if code, _ := s.submit(t, sub.username, submission(t, sub.task)); code != http.StatusOK {
+			t.Fatalf("Submit of %v returned %v, want %v", sub.username, code, http.StatusOK)
+		}
+		waitFor(t, "the submission to be judged", func() bool { return s.count(t, "scoreboard") == i+1 })
+	}
+
+	resp := s.scoreboardOf(t, "")
+	if want := []string{testUsername, "bob", "zed", "dan", "eve"}; !reflect.DeepEqual(resp.Users, want) {
+		t.Errorf("Got the users %v, want %v ranked by solves and then earliest solve", resp.Users, want)
+	}
+	want := map[string][]string{
+		testUsername: {"Succeeded", "Succeeded", "Failed"},
+		"bob":        {"", "Succeeded", ""},
+		"zed":        {"Succeeded", "", ""},
+		"dan":        {"", "", "Failed"},
+		"eve":        {"", "", ""},
+	}
+	for _, r := range resp.Rows {
+		var got []string
+		for _, c := range r.Cells {
+			got = append(got, c.Status)
+		}
+		if !reflect.DeepEqual(got, want[r.Username]) {
+			t.Errorf("Got the statuses %q of %v, want %q", got, r.Username, want[r.Username])
+		}
+	}
+
+	// The order doesn't change between requests.
+	for i := 0; i < 5; i++ {
+		if got := s.scoreboardOf(t, "").Users; !reflect.DeepEqual(got, resp.Users) {
+			t.Errorf("Got the users %v, want the same order %v", got, resp.Users)
+		}
+	}
+}
+
+func TestScoreboardFilteredByUsers(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	for _, u := range []string{"bob", "zed"} {
+		s.addUser(t, u)
+	}
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	if code, _ := s.submit(t, "zed", submission(t, "echo")); code != http.StatusOK {
+		t.Fatalf("Submit returned %v, want %v", code, http.StatusOK)
+	}
+	s.waitForAttempts(t, "zed", 1)
+
+	for _, c := range []struct {
+		users   string
+		want    []string
+		unknown []string
+	}{
+		{"", []string{"zed", testUsername, "bob"}, nil},
+		{"bob,zed", []string{"zed", "bob"}, nil},
+		// Blank and repeated users are ignored.
+		{"bob, ,bob", []string{"bob"}, nil},
+		{"bob,carl", []string{"bob"}, []string{"carl"}},
+	} {
+		resp := s.scoreboardOf(t, "?users="+url.QueryEscape(c.users))
+		var rows []string
+		for _, r := range resp.Rows {
+			rows = append(rows, r.Username)
+		}
+		if !reflect.DeepEqual(resp.Users, c.want) || !reflect.DeepEqual(rows, c.want) {
+			t.Errorf("Got the users %v and the rows %v filtered by %q, want %v", resp.Users, rows, c.users, c.want)
+		}
+		if !reflect.DeepEqual(resp.Unknown, c.unknown) {
+			t.Errorf("Got the unknown users %v filtered by %q, want %v", resp.Unknown, c.users, c.unknown)
+		}
+	}
+}
+
+func TestScoreboardTaskOrder(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	for _, task := range []Task{
+		{Name: "alpha", Order: 3, Points: 2, Tests: []Test{outputTest("hello", "hello")}},
+		{Name: "beta", Order: 1, Points: 3, Tests: []Test{outputTest("hello", "hello")}},
+		{Name: "gamma", Order: 2, Points: 1, Tests: []Test{outputTest("hello", "hello")}},
+	} {
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+	if code, _ := s.submit(t, testUsername, submission(t, "alpha")); code != http.StatusOK {
+		t.Fatalf("Submit returned %v, want %v", code, http.StatusOK)
+	}
+	s.submissions(t, 1, "")
+
+	for _, c := range []struct {
+		name  string
+		order TaskOrder
+		want  []string
+	}{
+		{"ByName", ByName, []string{"alpha", "beta", "gamma"}},
+		{"ByOrder", ByOrder, []string{"beta", "gamma", "alpha"}},
+		{"ByPoints", ByPoints, []string{"gamma", "alpha", "beta"}},
+	} {
+		s.TaskOrder = c.order
+		resp := s.scoreboardOf(t, "")
+		if !reflect.DeepEqual(resp.Tasks, c.want) {
+			t.Errorf("Got the tasks %v ordered %v, want %v", resp.Tasks, c.name, c.want)
+		}
+		// The cells follow the columns.
+		for i, cell := range resp.Rows[0].Cells {
+			if solved := cell.Status == succeededStatus; solved != (c.want[i] == "alpha") {
+				t.Errorf("Got the cell %+v in the %v column ordered %v, want only alpha solved", cell, c.want[i], c.name)
+			}
+		}
+	}
+}
+
+func TestFailureCategories(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		dc       *fakeDocker
+		limits   Limits
+		accepted string
+		detailed bool
+		want     string
+	}{
+		{"wrong answer", &fakeDocker{stdout: "hello"}, Limits{}, "bye", true, wrongAnswerCategory},
+		{"presentation", &fakeDocker{stdout: "hello  world"}, Limits{}, "hello world", true, presentationErrorCategory},
+		{"runtime", &fakeDocker{stdout: "hello", exitCode: 2}, Limits{}, "bye", true, runtimeErrorCategory},
+		{"compile", &fakeDocker{exitCode: 1, files: map[string]string{compileFailedMarker: ""}}, Limits{}, "hello", true, compileErrorCategory},
+		{"time limit", &fakeDocker{stdout: "hello", hang: true}, Limits{Timeout: 50 * time.Millisecond}, "hello", true, timeLimitExceededCategory},
+		// The categories are only exposed if the server details the verdicts.
+		{"undetailed", &fakeDocker{stdout: "hello"}, Limits{}, "bye", false, ""},
+	} {
+		s := newTestServer(t, c.dc)
+		s.DetailedVerdicts = c.detailed
+		task := Task{Name: "echo", Limits: c.limits, Cases: []Case{{Name: "out", Accepted: []string{c.accepted}}}}
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+		code, resp := s.submit(t, testUsername, submission(t, "echo"))
+		if code != http.StatusOK || resp.Passed || resp.Category != c.want {
+			t.Errorf("Submit of the %v submission returned %v %+v, want the category %q", c.name, code, resp, c.want)
+		}
+		s.close()
+	}
+}
@@ -1,13 +1,25 @@
 package godge
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"runtime"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
@@ -34,6 +46,19 @@ func (r *runningSubmissions) set(id string, sub *Submission) {
 	r.m[id] = sub
 }
 
+// containerIDs returns the set of the current containers of the running submissions.
+func (r *runningSubmissions) containerIDs() map[string]bool {
+	r.RLock()
+	defer r.RUnlock()
+	ret := make(map[string]bool)
+	for _, sub := range r.m {
+		if id := sub.Executor.containerID(); id != "" {
+			ret[id] = true
+		}
+	}
+	return ret
+}
+
 func (r *runningSubmissions) del(id string) {
 	r.Lock()
 	defer r.Unlock()
@@ -58,12 +83,50 @@ func (t *tasks) set(name string, task Task) {
 	t.m[name] = task
 }
 
+// update atomically applies f to the task with the given name.
+func (t *tasks) update(name string, f func(*Task) error) error {
+	t.Lock()
+	defer t.Unlock()
+	task, ok := t.m[name]
+	if !ok {
+		return fmt.Errorf("task %v not found", name)
+	}
+	if err := f(&task); err != nil {
+		return err
+	}
+	t.m[name] = task
+	return nil
+}
+
+// points returns the points each of the tasks is worth.
+func (t *tasks) points() map[string]int {
+	t.RLock()
+	defer t.RUnlock()
+	ret := make(map[string]int)
+	for k, v := range t.m {
+		ret[k] = v.maxPoints()
+	}
+	return ret
+}
+
+// sort sorts the names of the registered tasks with the given order.
+func (t *tasks) sort(names []string, order TaskOrder) {
+	t.RLock()
+	defer t.RUnlock()
+	sort.SliceStable(names, func(i, j int) bool {
+		return order(t.m[names[i]], t.m[names[j]])
+	})
+}
+
+// names returns the names of the tasks that are not drafts.
 func (t *tasks) names() []string {
 	t.RLock()
 	defer t.RUnlock()
 	var ret []string
-	for k := range t.m {
-		ret = append(ret, k)
+	for k, v := range t.m {
+		if v.state() != TaskDraft {
+			ret = append(ret, k)
+		}
 	}
 	return ret
 }
@@ -84,9 +147,305 @@ type Server struct {
 	tasks              tasks
 	pendingSubmissions chan submissionRequest
 	requestErrorChan   chan error
-	dockerClient       *docker.Client
+	dockerAddress      string
+	dockerMu           sync.RWMutex
+	dockerClient       dockerAPI
+	newDockerClient    func() (dockerAPI, error)
+	// Returns the free disk space of the filesystem of a path, see lowOnDisk.
+	freeDiskBytes      func(path string) (uint64, error)
+	windowMu           sync.RWMutex
 	runningSubmissions runningSubmissions
 	db                 *sqlx.DB
+	submissionSizes    *sizeHistogram
+	startedAt          time.Time
+	nonces             nonces
+	workers            workerPool
+	queueStats         queueStats
+	userTaskQueues     userTaskQueues
+	submissionWaits    submissionWaits
+	ipBlocks           blocks
+	accountLocks       blocks
+	groupSubmissions   slots
+	budgetSlots        slots
+	userStreams        slots
+	impersonations     impersonations
+	problemSets        problemSets
+	taskCrashes        taskCrashes
+	submissionIDs      submissionIDs
+	verdicts           verdictCache
+	allowedNets        []*net.IPNet
+
+	// The maximum number of times a submission is executed when it keeps failing
+	// because of infrastructure errors. If all the attempts fail, the submission is
+	// neither passed nor failed. Defaults to 1.
+	MaxExecutionAttempts int
+
+	// The maximum number of users exported with per user metrics (solves and score)
+	// on /metrics. The top users on the scoreboard are exported. Per user metrics
+	// are disabled when zero.
+	MetricsMaxUsers int
+
+	// The maximum size in bytes of a submission request body. The body is buffered
+	// in memory before being decoded. Defaults to 32MB.
+	MaxSubmissionBytes int64
+	// The maximum number of files (excluding directories) in the archive of a
+	// submission. Submissions with more files are rejected before being staged.
+	// Unlimited if zero.
+	MaxFilesPerSubmission int
+
+	// The names of the docker HostConfig fields (e.g. "CapAdd") that tasks are
+	// allowed to set in their HostConfig. Tasks setting any other field are rejected
+	// by RegisterTask.
+	AllowedHostConfigFields []string
+
+	// Usernames that can't be registered (e.g. "admin"). The matching is case insensitive.
+	ReservedUsernames []string
+
+	// If true, a username can't be registered if it only differs from a registered
+	// one in case or by look-alike characters (e.g. a Cyrillic "а" for a Latin "a").
+	RejectConfusableUsernames bool
+
+	// The upper bounds in bytes of the buckets of the submission sizes histogram
+	// exposed on /stats/sizes and /metrics.
+	SubmissionSizeBuckets []int64
+
+	// If set, a SolveEvent is POSTed to this URL whenever a user solves a task.
+	// Failed deliveries are queued in the database and retried with a backoff. The
+	// solves of the frozen contest are delivered once it ends.
+	WebhookURL string
+	// The maximum age of a queued webhook delivery after which it's dropped if it
+	// still fails. Defaults to 1 hour.
+	WebhookMaxAge time.Duration
+
+	// The images used to run the submissions of each language (e.g. "go": "golang:1.8").
+	// The images can be pinned by digest (e.g. golang@sha256:...), in which case the
+	// image of the container is verified before running. Tasks can override it.
+	LanguageImages map[string]string
+	// Whether the missing images of the languages and the tasks are pulled when a
+	// submission needs them. Submissions whose image is unavailable are rejected with
+	// 503 before being queued. Defaults to PullNever.
+	ImagePullPolicy PullPolicy
+
+	// The usernames of the users allowed to use the admin endpoints.
+	Admins []string
+
+	// The maximum number of tests (including cases) a task can have. RegisterTask
+	// rejects tasks with more tests. Unlimited if zero.
+	MaxTestsPerTask int
+
+	// The log driver (and its options) of the containers running the submissions.
+	// Note that the executors read the stdout and stderr of the containers through
+	// the docker logs API, so the driver must support reading (e.g. json-file or journald).
+	LogConfig *docker.LogConfig
+
+	// Breaks the ties between users having the same score on the scoreboard (e.g.
+	// FewestWrongSubmissions). Users with the same score are sorted alphabetically if
+	// nil. Defaults to EarliestLastSolve.
+	TieBreaker TieBreaker
+
+	// If true, the failed submissions are reported with the category of their first
+	// failed test (e.g. "Wrong Answer" or "Time Limit Exceeded") in the submission
+	// responses and the scoreboard instead of a plain "Failed".
+	DetailedVerdicts bool
+
+	// The order of the task columns of the scoreboard (e.g. ByOrder). Defaults to ByName.
+	TaskOrder TaskOrder
+
+	// How often the connection to the docker daemon is checked. The server reconnects
+	// to the daemon if the check fails (e.g. the daemon restarted). Defaults to 10 seconds.
+	DockerHealthCheckInterval time.Duration
+
+	// If true, every submission must carry a nonce issued by /nonce in the NonceHeader
+	// header. A nonce can only be used once, so replayed submissions are rejected.
+	RequireNonce bool
+
+	// The number of submissions processed in parallel. It can be changed at runtime
+	// through /admin/workers. Defaults to the number of CPUs.
+	Workers int
+
+	// Assigns users (by username) to groups (e.g. classes) sharing a submission quota.
+	UserGroups map[string]string
+	// The maximum number of in flight submissions of the users of each group. Submissions
+	// beyond the quota are rejected with 429. Groups without a quota are unlimited.
+	GroupQuotas map[string]int
+
+	// How often the containers leaked by the judge are removed. The sweep is disabled
+	// when zero.
+	OrphanSweepInterval time.Duration
+	// The age after which a container created by the judge is considered leaked by the
+	// sweep. It must be longer than the judging of any submission. Defaults to 1 hour.
+	OrphanContainerMaxAge time.Duration
+
+	// If true, the submissions are numbered 1, 2, ... in the order they're accepted
+	// instead of getting random IDs. The numbering continues after restarts and
+	// restarts from 1 when the contest goes live.
+	SequentialSubmissionIDs bool
+
+	// The seed of the randomized inputs of the tests (see Submission.Rand) of all the
+	// submissions, so that all the contestants get the same inputs. Each submission
+	// gets its own random seed if zero.
+	Seed int64
+
+	// How long the verdict of a submission is reused for the identical resubmissions
+	// (same user, task, language and source), which get the response of the first
+	// one marked as cached without being judged or counted again. Infrastructure,
+	// internal and environment errors are never reused. Disabled when zero.
+	VerdictCacheWindow time.Duration
+
+	// Receive the outcome of every judged submission, in addition to the scoreboard
+	// of the server's database. Tasks can add their own sinks.
+	ResultSinks []ResultSink
+
+	// How often the images tagged latest of the languages and the tasks are pulled
+	// again, so that the submissions aren't judged with stale runtimes. Images pinned
+	// by digest or with other tags are never re-pulled. Disabled when zero.
+	ImageRepullInterval time.Duration
+
+	// The maximum number of concurrent streaming requests (e.g. long polls and
+	// followed logs) of each user. Further requests are rejected with 429 until one
+	// of them ends. Unlimited if zero.
+	MaxStreamsPerUser int
+
+	// The number of consecutive failed authentications and rate limit hits (e.g. group
+	// quotas) within ClientBlockDuration after which a client IP is blocked with 403
+	// for ClientBlockDuration. A successful authentication resets the count. Abusive
+	// clients aren't blocked if zero.
+	MaxClientStrikes int
+	// Defaults to 15 minutes.
+	ClientBlockDuration time.Duration
+
+	// The number of consecutive failed authentications of a username within
+	// AccountLockDuration after which its account is locked with 423 for
+	// AccountLockDuration, even with the right password.
+	// A successful authentication resets the count. Accounts aren't locked if zero.
+	MaxFailedLogins int
+	// Defaults to 15 minutes.
+	AccountLockDuration time.Duration
+
+	// Submissions are rejected with 503 while the free disk space in DiskCheckPath
+	// is below MinFreeDiskBytes. The check is disabled when zero.
+	MinFreeDiskBytes uint64
+	// The path whose filesystem is checked, where the submissions are staged.
+	// Defaults to the temporary directory.
+	DiskCheckPath string
+
+	// How often the rank and the score of every user are snapshotted for their
+	// /me/history. The snapshots are disabled when zero.
+	RankSnapshotInterval time.Duration
+
+	// If set, submissions are only accepted from clients whose IP is in one of these
+	// CIDRs (e.g. the venue network 192.168.1.0/24). Others are rejected with 403.
+	AllowedCIDRs []string
+	// Whether the client IP is read from the X-Forwarded-For header. Only enable it
+	// behind a trusted reverse proxy as the header can be set by the clients.
+	TrustForwardedFor bool
+
+	// The minimum size in bytes of the responses of the read endpoints (e.g. the
+	// scoreboard) compressed with gzip for the clients accepting it. Compression is
+	// disabled when zero. Defaults to 1KB.
+	CompressionMinBytes int
+
+	// The time window of the contest. Submissions are rejected before StartAt and after
+	// EndAt. Tasks can override the window using OpenAt and CloseAt. Zero values mean
+	// that the window is unbounded.
+	StartAt time.Time
+	EndAt   time.Time
+	// The scoreboard and the activity are frozen between FreezeAt and EndAt: the
+	// results of the submissions judged in the meantime are only revealed once the
+	// contest ends. Never frozen if zero.
+	FreezeAt time.Time
+	// If set, the archive of the practice contest (see /admin/export) is written to
+	// this directory before its results are cleared by /admin/contest/golive.
+	PracticeArchiveDir string
+
+	// The status shown on the scoreboard (HTML and JSON) in the cells of the tasks a
+	// user didn't attempt (e.g. "-"), to distinguish them from the failed ones.
+	UnattemptedPlaceholder string
+
+	// The requirements of the users' passwords. Passwords are not checked if nil.
+	PasswordPolicy *PasswordPolicy
+
+	// The Cache-Control header (e.g. "max-age=5") of the public read endpoints, such
+	// as the scoreboard and the tasks, letting browsers and CDNs cache them briefly.
+	// Responses of the authenticated endpoints are never cached.
+	PublicCacheControl string
+
+	// The maximum number of workers when auto scaling. The workers are scaled up, one
+	// at a time, while submissions wait in the queue for longer than ScaleUpQueueWait,
+	// and scaled back down to Workers after ScaleDownIdle without submissions. Auto
+	// scaling is disabled if not greater than Workers.
+	MaxWorkers int
+	// Defaults to 5 seconds.
+	ScaleUpQueueWait time.Duration
+	// Defaults to 1 minute.
+	ScaleDownIdle time.Duration
+
+	// The maximum size in bytes of the compiler output of a submission. Submissions
+	// exceeding it fail with "compile output too large". Unlimited if zero.
+	MaxCompileOutputBytes int64
+
+	// The maximum number of processes in the containers of the tasks not setting their
+	// own limit (e.g. 512). Unlimited if zero.
+	PidsLimit int64
+
+	// The path of a writable tmpfs mounted in the containers as scratch space for the
+	// submissions (e.g. /scratch). Nothing is mounted if empty. It shouldn't be /tmp
+	// as the judge reads files the compilation leaves there after the container exits.
+	TmpfsPath string
+	// Mount the tmpfs with noexec, so that the submissions can't execute the binaries
+	// they drop in it.
+	TmpfsNoExec bool
+	// Mount the tmpfs with nosuid, so that setuid binaries dropped in it don't gain
+	// privileges.
+	TmpfsNoSuid bool
+
+	// The maximum time a /submissions/<id>/wait request blocks for the submission to
+	// be judged. Clients can ask for a shorter wait with the timeout parameter.
+	// Defaults to 30 seconds.
+	MaxWaitTimeout time.Duration
+
+	// The streamed responses (e.g. followed logs) are buffered up to StreamBufferBytes
+	// for slow clients. A client that doesn't make room in the buffer within
+	// StreamWriteTimeout is disconnected. Default to 1MB and 10 seconds.
+	StreamBufferBytes  int
+	StreamWriteTimeout time.Duration
+
+	// How long the impersonation tokens issued to the admins through
+	// /admin/users/<name>/impersonate are valid. Defaults to 15 minutes.
+	ImpersonationTTL time.Duration
+
+	// If set, the response of the registration carries an entry token signed with
+	// this key, and submissions are only accepted with a valid entry token of their
+	// user in the EntryTokenHeader. Users get a new token through /me/entry-token.
+	EntryTokenKey []byte
+	// The contest the entry tokens are signed for, so that the tokens of another
+	// contest signed with the same key are rejected.
+	ContestID string
+	// The duration the entry tokens are valid for. Defaults to 24 hours.
+	EntryTokenTTL time.Duration
+
+	// If true, users register with an email and can't submit until they verify it
+	// using the token sent to them by SendVerification through /verify.
+	RequireEmailVerification bool
+	// Sends the verification token to the email of a newly registered user. The token
+	// is only logged if nil.
+	SendVerification func(username, email, token string) error
+
+	// If set, registrations must carry a CAPTCHA token accepted by this function,
+	// which is passed the token and the IP of the client (e.g. SiteVerifyCaptcha).
+	VerifyCaptcha func(token, remoteIP string) error
+
+	// The number of consecutive submissions whose tests crash with an internal error
+	// (e.g. a panic) after which the task is automatically closed. Disabled when zero.
+	MaxTaskInternalErrors int
+	// Called to alert the admins (e.g. when a task is automatically closed). The
+	// alerts are always logged.
+	Alert func(msg string)
+
+	// The total number of judged submissions each user can make in all the tasks.
+	// Admins can override it per user through /admin/users/<name>/budget. Unlimited
+	// if zero.
+	SubmissionBudget int
 }
 
 // NewServer creates a new instance of the judge. It takes the address that the
@@ -104,24 +463,202 @@ func NewServer(address string, dockerAddress string, dbpath string) (*Server, er
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %v", err)
 	}
+	// The workers report their results concurrently, serializing the writes on a
+	// single connection avoids sqlite's "database is locked" errors.
+	db.SetMaxOpenConns(1)
+	return newServer(address, dockerAddress, dc, db), nil
+}
 
+// newServer returns a server with the default configuration using the given docker
+// client and database.
+func newServer(address string, dockerAddress string, dc dockerAPI, db *sqlx.DB) *Server {
 	return &Server{
 		address: address,
 		tasks: tasks{
 			m: make(map[string]Task),
 		},
 		pendingSubmissions: make(chan submissionRequest),
+		dockerAddress:      dockerAddress,
 		dockerClient:       dc,
+		freeDiskBytes:      freeDiskBytes,
+		newDockerClient: func() (dockerAPI, error) {
+			dc, err := docker.NewClient(dockerAddress)
+			if err != nil {
+				return nil, err
+			}
+			return dc, nil
+		},
 		runningSubmissions: runningSubmissions{
 			m: make(map[string]*Submission),
 		},
-		db: db,
-	}, nil
+		nonces: nonces{
+			m: make(map[string]nonce),
+		},
+		groupSubmissions: slots{
+			m: make(map[string]int),
+		},
+		budgetSlots: slots{
+			m: make(map[string]int),
+		},
+		userStreams: slots{
+			m: make(map[string]int),
+		},
+		userTaskQueues: userTaskQueues{
+			m: make(map[string][]submissionRequest),
+		},
+		submissionWaits: submissionWaits{
+			m: make(map[string]*submissionWait),
+		},
+		ipBlocks: blocks{
+			m: make(map[string]*blockRecord),
+		},
+		accountLocks: blocks{
+			m: make(map[string]*blockRecord),
+		},
+		impersonations: impersonations{
+			m: make(map[string]impersonation),
+		},
+		problemSets: problemSets{
+			m: make(map[string]ProblemSet),
+		},
+		taskCrashes: taskCrashes{
+			m: make(map[string]int),
+		},
+		verdicts: verdictCache{
+			m: make(map[string]cachedVerdict),
+		},
+		db:                   db,
+		MaxExecutionAttempts: 1,
+		Workers:              runtime.NumCPU(),
+		TieBreaker:           EarliestLastSolve,
+		TaskOrder:            ByName,
+		MaxSubmissionBytes:   32 << 20,
+		CompressionMinBytes:  1 << 10,
+		ImagePullPolicy:      PullNever,
+		MaxWaitTimeout:       30 * time.Second,
+		StreamBufferBytes:    1 << 20,
+		StreamWriteTimeout:   10 * time.Second,
+		ClientBlockDuration:  15 * time.Minute,
+		AccountLockDuration:  15 * time.Minute,
+		EntryTokenTTL:        24 * time.Hour,
+		SubmissionSizeBuckets: []int64{
+			1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20,
+		},
+		WebhookMaxAge:             time.Hour,
+		DockerHealthCheckInterval: 10 * time.Second,
+		ScaleUpQueueWait:          5 * time.Second,
+		ScaleDownIdle:             time.Minute,
+		ImpersonationTTL:          15 * time.Minute,
+		OrphanContainerMaxAge:     time.Hour,
+		LanguageImages: map[string]string{
+			"go": defaultGoImage,
+		},
+	}
 }
 
-// RegisterTask registers a new task in the server.
-func (s *Server) RegisterTask(t Task) {
+// RegisterTask registers a new task in the server. It returns an error if the
+// task's configuration is not allowed by the server.
+func (s *Server) RegisterTask(t Task) error {
+	n := len(t.tests())
+	for _, st := range t.Subtasks {
+		n += len(st.Tests)
+	}
+	if s.MaxTestsPerTask > 0 && n > s.MaxTestsPerTask {
+		return fmt.Errorf("invalid task %v: it has %v tests, at most %v are allowed", t.Name, n, s.MaxTestsPerTask)
+	}
+	if _, err := matchOutput(t.OutputPattern); err != nil {
+		return fmt.Errorf("invalid task %v: invalid output pattern: %v", t.Name, err)
+	}
+	for _, c := range t.Cases {
+		if len(c.Accepted) == 0 && c.Pattern == "" && t.OutputPattern == "" {
+			return fmt.Errorf("invalid task %v: case %v has no accepted outputs", t.Name, c.Name)
+		}
+		if _, err := matchOutput(c.Pattern); err != nil {
+			return fmt.Errorf("invalid task %v: invalid output pattern of case %v: %v", t.Name, c.Name, err)
+		}
+	}
+	for _, st := range t.Subtasks {
+		if st.Points < 0 {
+			return fmt.Errorf("invalid task %v: subtask %v has negative points", t.Name, st.Name)
+		}
+	}
+	if t.Points < 0 {
+		return fmt.Errorf("invalid task %v: negative points", t.Name)
+	}
+	if t.HostConfig != nil {
+		if err := validateHostConfig(t.HostConfig, s.AllowedHostConfigFields); err != nil {
+			return fmt.Errorf("invalid task %v: %v", t.Name, err)
+		}
+	}
+	if err := validateImage(t.Image); err != nil {
+		return fmt.Errorf("invalid task %v: %v", t.Name, err)
+	}
+	if _, ok := taskStateTransitions[t.state()]; !ok {
+		return fmt.Errorf("invalid task %v: unknown state %v", t.Name, t.State)
+	}
+	for _, l := range t.AllowedLanguages {
+		if _, ok := s.LanguageImages[l]; !ok {
+			return fmt.Errorf("invalid task %v: unknown language %v", t.Name, l)
+		}
+	}
 	s.tasks.set(t.Name, t)
+	return nil
+}
+
+// executorConfig returns the configuration of the executor running the submission of the task.
+func (s *Server) executorConfig(t Task, sub *Submission) executorConfig {
+	image := s.image(t, sub.Language)
+	limits := t.Limits
+	if limits.Pids == 0 {
+		limits.Pids = s.PidsLimit
+	}
+	return executorConfig{
+		image:                 image,
+		logConfig:             s.LogConfig,
+		hostConfig:            t.HostConfig,
+		setup:                 t.Setup,
+		maxCompileOutputBytes: s.MaxCompileOutputBytes,
+		limits:                limits,
+		tmpfs:                 s.tmpfs(),
+	}
+}
+
+// image returns the image running the submissions of the task in the language.
+func (s *Server) image(t Task, language string) string {
+	if t.Image != "" {
+		return t.Image
+	}
+	return s.LanguageImages[language]
+}
+
+// runtimeAvailable checks that the image running the submission is available and
+// writes the error response otherwise.
+func (s *Server) runtimeAvailable(w http.ResponseWriter, t Task, sub *Submission) bool {
+	image := s.image(t, sub.Language)
+	if image == "" {
+		return true
+	}
+	if err := s.ensureImage(image); err != nil {
+		log.Printf("Rejected %v submission for %v: %v", sub.Language, t.Name, err)
+		httpJSONError(w, fmt.Sprintf("The %v runtime is unavailable: %v", sub.Language, err), http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// tmpfs returns the tmpfs mounts of the containers with their mount options.
+func (s *Server) tmpfs() map[string]string {
+	if s.TmpfsPath == "" {
+		return nil
+	}
+	opts := []string{"rw"}
+	if s.TmpfsNoExec {
+		opts = append(opts, "noexec")
+	}
+	if s.TmpfsNoSuid {
+		opts = append(opts, "nosuid")
+	}
+	return map[string]string{s.TmpfsPath: strings.Join(opts, ",")}
 }
 
 // handleSubmission is used to handle a received submission by executing the tests of the
@@ -131,97 +668,535 @@ func (s *Server) handleSubmission(sub *Submission) error {
 	if !ok {
 		return fmt.Errorf("task %v not found", sub.TaskName)
 	}
+	sub.Executor.configure(s.executorConfig(t, sub))
+	defer sub.Executor.cleanup()
+	defer s.saveLogs(sub)
 	s.runningSubmissions.set(sub.id, sub)
 	defer s.runningSubmissions.del(sub.id)
-	if err := t.execute(sub); err != nil {
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		// The docker client might have been replaced after reconnecting to the daemon.
+		sub.Executor.setDockerClient(s.docker())
+		err = t.execute(sub)
+		if !isInfrastructureError(err) || attempt >= s.MaxExecutionAttempts {
+			break
+		}
+		log.Printf("Attempt %v of %v submission for %v failed: %v", attempt, sub.Language, sub.TaskName, err)
+	}
+	if isJudgeError(err) {
+		return err
+	}
+	if err != nil {
 		return fmt.Errorf("task %v failed: %v", sub.TaskName, err)
 	}
 	return nil
 }
 
+// saveLogs saves the logs of the last container of the submission so that they are
+// available once the container is removed.
+func (s *Server) saveLogs(sub *Submission) {
+	if sub.Executor.containerID() == "" {
+		return
+	}
+	stdout, err := sub.Executor.Stdout()
+	if err != nil {
+		log.Printf("Failed to read the stdout of submission %v: %v", sub.id, err)
+	}
+	stderr, err := sub.Executor.Stderr()
+	if err != nil {
+		log.Printf("Failed to read the stderr of submission %v: %v", sub.id, err)
+	}
+	if err := saveSubmissionLogs(s.db, sub.id, stdout, stderr); err != nil {
+		log.Printf("Failed to save the logs of submission %v: %v", sub.id, err)
+	}
+}
+
 // A wrapper around the submission that's used for communication between
 // the http handler and the server.
 type submissionRequest struct {
 	result     chan error
 	submission *Submission
+	// If set, only the sample tests are run and their results are sent on this
+	// channel instead of result. The scoreboard is not updated.
+	samples chan []TestResult
+}
+
+// runSamples runs the sample tests of the submission's task against the submission.
+func (s *Server) runSamples(sub *Submission) []TestResult {
+	t, ok := s.tasks.get(sub.TaskName)
+	if !ok {
+		return []TestResult{}
+	}
+	sub.Executor.setDockerClient(s.docker())
+	sub.Executor.configure(s.executorConfig(t, sub))
+	defer sub.Executor.cleanup()
+	s.runningSubmissions.set(sub.id, sub)
+	defer s.runningSubmissions.del(sub.id)
+	return t.runSamples(sub)
 }
 
 // Updates the scoreboard.
 func (s *Server) reportResult(sub *Submission, err error) {
 	log.Printf("%v submission for %v: %v", sub.Language, sub.TaskName, err)
+	verdict := verdictOf(err)
+	now := time.Now()
+	frozen := s.phase(now) == ContestFrozen
+	saveToScoreboard(s.db, sub, verdict, frozen)
+	o := Outcome{
+		SubmissionID: sub.id,
+		Username:     sub.Username,
+		TaskName:     sub.TaskName,
+		Language:     sub.Language,
+		Tags:         sub.Tags,
+		Verdict:      verdict,
+		Result:       sub.result,
+		Subtasks:     sub.subtasks,
+		Seed:         sub.Seed,
+		JudgedAt:     now,
+		Frozen:       frozen,
+	}
 	if err != nil {
-		saveToScoreboard(s.db, sub.Username, sub.TaskName, failedVerdict)
+		o.Error = err.Error()
+	}
+	if verdict == failedVerdict {
+		o.Category = sub.category
+	}
+	s.saveToSinks(sub, o)
+	s.demoteCrashingTask(sub.TaskName, err)
+	if verdict != passedVerdict {
 		return
 	}
-	saveToScoreboard(s.db, sub.Username, sub.TaskName, passedVerdict)
+	if err := s.enqueueSolveEvent(sub); err != nil {
+		log.Printf("Failed to queue solve event: %v", err)
+	}
 }
 
 // Executes the tests and report the result back to the http handler and the
-// scoreboard.
-func (s *Server) processSubmissions() {
-	for sreq := range s.pendingSubmissions {
-		err := s.handleSubmission(sreq.submission)
-		sreq.result <- err
-		s.reportResult(sreq.submission, err)
+// scoreboard. It's run by each of the workers until their stop channel is closed.
+func (s *Server) processSubmissions(stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case sreq := <-s.pendingSubmissions:
+			if sreq.samples != nil {
+				sreq.samples <- s.runSamples(sreq.submission)
+				continue
+			}
+			err := s.handleSubmission(sreq.submission)
+			sreq.result <- err
+			s.reportResult(sreq.submission, err)
+			if sreq.submission.budgetReserved {
+				s.budgetSlots.release(sreq.submission.Username)
+			}
+			if s.SequentialSubmissionIDs {
+				s.submissionIDs.done()
+			}
+			// The next submission of the user to the task is only sent once this one
+			// is reported, so that the last reported one is the last judged one. It's
+			// sent from another goroutine as the worker would be the one receiving it.
+			if next, ok := s.userTaskQueues.pop(sreq.submission); ok {
+				go s.dispatch(next)
+			}
+		}
+	}
+}
+
+// authenticate returns the user identified by the basic auth credentials of the
+// request, or impersonated by an admin's bearer token. It returns false if the
+// credentials are missing or wrong.
+func (s *Server) authenticate(req *http.Request) (*user, bool) {
+	if u, ok := s.authenticateImpersonation(req); ok {
+		return u, true
+	}
+	return s.authenticateBasic(req)
+}
+
+// authenticateBasic returns the user identified by the basic auth credentials of the request.
+func (s *Server) authenticateBasic(req *http.Request) (*user, bool) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+	u, err := userQ.find(s.db, username)
+	if err != nil || !u.isCorrectPassword(password) {
+		s.strikeClient(req)
+		if err == nil {
+			s.strikeAccount(username)
+		}
+		return nil, false
+	}
+	s.resetClient(req)
+	s.resetAccount(username)
+	return u, true
+}
+
+// isAllowedClient reports whether the client of the request is in one of the allowed
+// networks. All clients are allowed if no networks are configured.
+func (s *Server) isAllowedClient(req *http.Request) bool {
+	if len(s.allowedNets) == 0 {
+		return true
+	}
+	ip := clientIP(req, s.TrustForwardedFor)
+	if ip == nil {
+		return false
+	}
+	for _, n := range s.allowedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptingSubmissions returns an error if the task doesn't accept submissions at the
+// given time, either because of its state or because it's outside of its time window.
+func (s *Server) acceptingSubmissions(t Task, now time.Time) error {
+	if t.state() != TaskOpen {
+		return fmt.Errorf("task %v is not open for submissions", t.Name)
+	}
+	startAt, _, endAt := s.contestWindow()
+	openAt, closeAt := t.window(startAt, endAt)
+	if !openAt.IsZero() && now.Before(openAt) {
+		return fmt.Errorf("task %v opens for submissions at %v", t.Name, openAt)
 	}
+	if !closeAt.IsZero() && !now.Before(closeAt) {
+		return fmt.Errorf("task %v closed for submissions at %v", t.Name, closeAt)
+	}
+	return nil
+}
+
+// lowOnDisk reports whether the free disk space where the submissions are staged
+// is below MinFreeDiskBytes. Failures to check are logged and ignored.
+func (s *Server) lowOnDisk() bool {
+	if s.MinFreeDiskBytes == 0 {
+		return false
+	}
+	path := s.DiskCheckPath
+	if path == "" {
+		path = os.TempDir()
+	}
+	free, err := s.freeDiskBytes(path)
+	if err != nil {
+		log.Printf("Failed to check the free disk space of %v: %v", path, err)
+		return false
+	}
+	return free < s.MinFreeDiskBytes
+}
+
+// reserveBudget reserves one of the user's submission budget for a submission, the
+// judged submissions and the ones being judged count toward it. It returns false
+// if the budget is exhausted, and reserved is false if the budget is unlimited.
+// A reservation is released from the budgetSlots once the submission is reported.
+func (s *Server) reserveBudget(username string) (reserved, ok bool, err error) {
+	budget, set, err := getSubmissionBudget(s.db, username)
+	if err != nil {
+		return false, false, err
+	}
+	if !set {
+		budget = s.SubmissionBudget
+	}
+	if budget <= 0 {
+		return false, true, nil
+	}
+	// The slots stay locked while counting, so that no concurrent submission of the
+	// user is reserved or reported meanwhile.
+	s.budgetSlots.Lock()
+	defer s.budgetSlots.Unlock()
+	n, err := countJudgedSubmissions(s.db, username)
+	if err != nil {
+		return false, false, err
+	}
+	if n+s.budgetSlots.m[username] >= budget {
+		return false, false, nil
+	}
+	s.budgetSlots.m[username]++
+	return true, true, nil
+}
+
+// missingPrerequisites returns the prerequisites of the task that the user didn't solve.
+func (s *Server) missingPrerequisites(t Task, username string) ([]string, error) {
+	solved, err := getSolvedTasks(s.db, username, t.Prerequisites)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, p := range t.Prerequisites {
+		if !solved[p] {
+			ret = append(ret, p)
+		}
+	}
+	return ret, nil
 }
 
 // SubmissionResponse is the response returned back by the server in response
 // to the submission request. It's exposed to be used by the command line client.
 type SubmissionResponse struct {
-	Passed bool   `json:"passed"`
-	Error  string `json:"error"`
+	XMLName       xml.Name      `json:"-" xml:"submission"`
+	ID            string        `json:"id" xml:"id"`
+	Passed        bool          `json:"passed" xml:"passed"`
+	Error         string        `json:"error" xml:"error"`
+	ResourceUsage ResourceUsage `json:"resourceUsage" xml:"resourceUsage"`
+	// The score of the submission in each of the task's subtasks, if any.
+	Subtasks []SubtaskResult `json:"subtasks,omitempty" xml:"subtasks>subtask,omitempty"`
+	// The number of passed tests and the score of the submission.
+	Result Result `json:"result" xml:"result"`
+	// The seed of the randomized inputs of the tests, to reproduce the submission's result.
+	Seed int64 `json:"seed" xml:"seed"`
+	// The category of the failure (e.g. "Wrong Answer") if the server reports
+	// detailed verdicts and the submission failed.
+	Category string `json:"category,omitempty" xml:"category,omitempty"`
+	// Whether the response is the one of an identical previous submission that
+	// wasn't judged again, see Server.VerdictCacheWindow.
+	Cached bool `json:"cached,omitempty" xml:"cached,omitempty"`
 }
 
 // The handler that handles submission requests.
 func (s *Server) submitHTTPHandler(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodPost {
-		httpJSONError(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		httpMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !s.isAllowedClient(req) {
+		httpJSONError(w, "Submissions are not allowed from your network", http.StatusForbidden)
 		return
 	}
 	// Authenticate the user
-	username, password, ok := req.BasicAuth()
+	u, ok := s.authenticate(req)
 	if !ok {
 		httpJSONError(w, "Wrong username or password", http.StatusUnauthorized)
 		return
 	}
-	if u, err := userQ.find(s.db, username); err != nil || !u.isCorrectPassword(password) {
-		httpJSONError(w, "Wrong username or password", http.StatusUnauthorized)
+	if s.RequireEmailVerification && !u.Verified {
+		httpJSONError(w, "Verify your email before submitting", http.StatusForbidden)
+		return
+	}
+	if len(s.EntryTokenKey) > 0 {
+		if err := verifyEntryToken(s.EntryTokenKey, u.Username, s.ContestID, req.Header.Get(EntryTokenHeader), time.Now()); err != nil {
+			httpJSONError(w, fmt.Sprintf("Rejected entry token: %v", err), http.StatusForbidden)
+			return
+		}
+	}
+	if s.RequireNonce {
+		n := req.Header.Get(NonceHeader)
+		if n == "" {
+			httpJSONError(w, fmt.Sprintf("Missing the %v header", NonceHeader), http.StatusBadRequest)
+			return
+		}
+		if !s.nonces.consume(u.Username, n) {
+			httpJSONError(w, "The nonce is invalid or was already used", http.StatusConflict)
+			return
+		}
+	}
+
+	if s.lowOnDisk() {
+		httpJSONError(w, "Insufficient disk space to judge submissions, try again later", http.StatusServiceUnavailable)
 		return
 	}
 
-	var sub Submission
-	err := json.NewDecoder(req.Body).Decode(&sub)
+	sub, ok := s.readSubmission(w, req)
+	if !ok {
+		return
+	}
+	// The submission is always filed under the authenticated user, whatever the
+	// username in the body.
+	sub.Username = u.Username
+	if s.MaxFilesPerSubmission > 0 {
+		files, err := countSourceFiles(sub.Executor.source())
+		if err != nil {
+			httpJSONError(w, fmt.Sprintf("Failed to count source files: %v", err), http.StatusBadRequest)
+			return
+		}
+		if files > s.MaxFilesPerSubmission {
+			httpJSONError(w, fmt.Sprintf("Submission has %v files, at most %v are allowed", files, s.MaxFilesPerSubmission), http.StatusBadRequest)
+			return
+		}
+	}
+	if t, ok := s.tasks.get(sub.TaskName); ok {
+		if err := s.acceptingSubmissions(t, time.Now()); err != nil {
+			httpJSONError(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		missing, err := s.missingPrerequisites(t, u.Username)
+		if err != nil {
+			httpJSONError(w, fmt.Sprintf("Failed to check prerequisites: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if len(missing) > 0 {
+			httpJSONError(w, fmt.Sprintf("Task %v requires solving %v first", t.Name, strings.Join(missing, ", ")), http.StatusForbidden)
+			return
+		}
+		if !t.acceptsLanguage(sub.Language) {
+			httpJSONError(w, fmt.Sprintf("Task %v doesn't accept %v submissions, allowed languages: %v", t.Name, sub.Language, strings.Join(t.AllowedLanguages, ", ")), http.StatusBadRequest)
+			return
+		}
+		if t.MaxSourceLines > 0 {
+			lines, err := countSourceLines(sub.Executor.source())
+			if err != nil {
+				httpJSONError(w, fmt.Sprintf("Failed to count source lines: %v", err), http.StatusBadRequest)
+				return
+			}
+			if lines > t.MaxSourceLines {
+				httpJSONError(w, fmt.Sprintf("Submission has %v lines, task %v allows at most %v", lines, t.Name, t.MaxSourceLines), http.StatusBadRequest)
+				return
+			}
+		}
+		if !s.runtimeAvailable(w, t, &sub) {
+			return
+		}
+	}
+	if s.VerdictCacheWindow > 0 {
+		sub.verdictKey = verdictKey(u.Username, &sub)
+		if resp, ok := s.verdicts.get(sub.verdictKey, s.VerdictCacheWindow); ok {
+			resp.Cached = true
+			if wantsXML(req) {
+				writeXML(w, resp)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			if err := json.NewEncoder(w).Encode(resp); err != nil {
+				httpJSONError(w, "Failed to encode response", http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	release := func() {}
+	if group, ok := s.UserGroups[u.Username]; ok {
+		if !s.groupSubmissions.acquire(group, s.GroupQuotas[group]) {
+			s.strikeClient(req)
+			httpJSONError(w, fmt.Sprintf("Group %v reached its quota of %v concurrent submissions", group, s.GroupQuotas[group]), http.StatusTooManyRequests)
+			return
+		}
+		release = func() { s.groupSubmissions.release(group) }
+	}
+	reserved, ok, err := s.reserveBudget(u.Username)
 	if err != nil {
-		httpJSONError(w, fmt.Sprintf("Failed to decode request body: %v", err), http.StatusBadRequest)
+		release()
+		httpJSONError(w, fmt.Sprintf("Failed to check submission budget: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		release()
+		httpJSONError(w, "Submission budget exhausted", http.StatusForbidden)
 		return
 	}
-	sub.Executor.setDockerClient(s.dockerClient)
+	sub.budgetReserved = reserved
+	// The submissions are numbered once accepted, so that the rejected ones don't
+	// leave gaps.
+	if s.SequentialSubmissionIDs {
+		sub.id = s.submissionIDs.next()
+	}
+	s.submissionWaits.add(sub.id, u.Username)
 
-	// Send the submission for the server to run the tests.
-	res := make(chan error)
-	s.pendingSubmissions <- submissionRequest{
-		result:     res,
-		submission: &sub,
+	// Async submissions return right away, their result is long polled with
+	// /submissions/<id>/wait.
+	if req.URL.Query().Get("async") == "true" {
+		go func() {
+			defer release()
+			s.judge(&sub)
+		}()
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(PendingResponse{ID: sub.id}); err != nil {
+			httpJSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		}
+		return
 	}
+	defer release()
+	resp := s.judge(&sub)
+
+	if wantsXML(req) {
+		writeXML(w, resp)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		httpJSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
 
-	// Wait for the submission results and prepare the response.
+// judge sends the submission for the workers to run the tests, waits for its
+// result and wakes up the clients waiting for it.
+func (s *Server) judge(sub *Submission) SubmissionResponse {
+	res := make(chan error)
+	s.enqueue(submissionRequest{
+		result:     res,
+		submission: sub,
+	})
 	result := <-res
 
 	resp := SubmissionResponse{
-		Passed: true,
-		Error:  "",
+		ID:            sub.id,
+		Seed:          sub.Seed,
+		Passed:        true,
+		Error:         "",
+		ResourceUsage: sub.Executor.ResourceUsage(),
+		Subtasks:      sub.subtasks,
+		Result:        sub.result,
 	}
-
 	if result != nil {
-		resp = SubmissionResponse{
-			Passed: false,
-			Error:  result.Error(),
+		resp.Passed = false
+		resp.Error = result.Error()
+		if s.DetailedVerdicts && verdictOf(result) == failedVerdict {
+			resp.Category = sub.category
 		}
 	}
+	if v := verdictOf(result); sub.verdictKey != "" && (v == passedVerdict || v == failedVerdict) {
+		s.verdicts.put(sub.verdictKey, resp, s.VerdictCacheWindow)
+	}
+	s.submissionWaits.finish(sub.id, resp)
+	return resp
+}
+
+// RunResponse is the response returned back by the server in response to
+// the run request. It's exposed to be used by the command line client.
+type RunResponse struct {
+	Results []TestResult `json:"results"`
+}
+
+// The handler that handles running a submission against the sample tests of a task.
+func (s *Server) runHTTPHandler(w http.ResponseWriter, req *http.Request, taskName string) {
+	if req.Method != http.MethodPost {
+		httpMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	u, ok := s.authenticate(req)
+	if !ok {
+		httpJSONError(w, "Wrong username or password", http.StatusUnauthorized)
+		return
+	}
+	t, ok := s.tasks.get(taskName)
+	if !ok || t.state() == TaskDraft {
+		httpJSONError(w, fmt.Sprintf("Task %v not found", taskName), http.StatusNotFound)
+		return
+	}
+	if t.state() != TaskOpen {
+		httpJSONError(w, fmt.Sprintf("Task %v is not open for submissions", taskName), http.StatusForbidden)
+		return
+	}
+
+	var sub Submission
+	err := json.NewDecoder(req.Body).Decode(&sub)
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to decode request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	sub.TaskName = taskName
+	sub.Username = u.Username
+	if !s.runtimeAvailable(w, t, &sub) {
+		return
+	}
+
+	res := make(chan []TestResult)
+	s.enqueue(submissionRequest{
+		submission: &sub,
+		samples:    res,
+	})
 
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
+	if err := json.NewEncoder(w).Encode(RunResponse{Results: <-res}); err != nil {
 		httpJSONError(w, "Failed to encode response", http.StatusInternalServerError)
 		return
 	}
@@ -232,12 +1207,16 @@ func (s *Server) submitHTTPHandler(w http.ResponseWriter, req *http.Request) {
 type RegisterRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// Required if the server requires email verification.
+	Email string `json:"email"`
+	// The CAPTCHA token solved by the user. Required if the server verifies CAPTCHAs.
+	Captcha string `json:"captcha"`
 }
 
 // Handles registration requests.
 func (s *Server) registerHTTPHandler(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodPost {
-		httpJSONError(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		httpMethodNotAllowed(w, http.MethodPost)
 		return
 	}
 
@@ -248,17 +1227,62 @@ func (s *Server) registerHTTPHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if s.VerifyCaptcha != nil {
+		if rreq.Captcha == "" {
+			httpJSONError(w, "A captcha is required", http.StatusBadRequest)
+			return
+		}
+		var ip string
+		if addr := clientIP(req, s.TrustForwardedFor); addr != nil {
+			ip = addr.String()
+		}
+		if err := s.VerifyCaptcha(rreq.Captcha, ip); err != nil {
+			log.Printf("Captcha verification failed: %v", err)
+			httpJSONError(w, "Captcha verification failed", http.StatusForbidden)
+			return
+		}
+	}
+
 	// Make sure that the username is not empty.
 	if len(rreq.Username) == 0 {
 		httpJSONError(w, fmt.Sprintf("Username cannot be empty"), http.StatusBadRequest)
 		return
 	}
 
+	// Make sure that the username is not reserved.
+	for _, r := range s.ReservedUsernames {
+		if strings.EqualFold(r, rreq.Username) {
+			httpJSONError(w, fmt.Sprintf("Username %v is reserved", rreq.Username), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Make sure that the username is unique.
 	if _, err := userQ.find(s.db, rreq.Username); err != sql.ErrNoRows {
 		httpJSONError(w, fmt.Sprintf("Username %v is already registered", rreq.Username), http.StatusBadRequest)
 		return
 	}
+	if s.RejectConfusableUsernames {
+		existing, ok, err := s.confusableUsername(rreq.Username)
+		if err != nil {
+			httpJSONError(w, fmt.Sprintf("Failed to check username: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if ok {
+			httpJSONError(w, fmt.Sprintf("Username %v is too similar to the registered username %v", rreq.Username, existing), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := s.validatePassword(rreq.Password); err != nil {
+		httpJSONError(w, fmt.Sprintf("Weak password: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if s.RequireEmailVerification && !strings.Contains(rreq.Email, "@") {
+		httpJSONError(w, "A valid email is required", http.StatusBadRequest)
+		return
+	}
 
 	encryptedPassword, err := bcrypt.GenerateFromPassword([]byte(rreq.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -266,24 +1290,90 @@ func (s *Server) registerHTTPHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	user := &user{Username: rreq.Username, Password: string(encryptedPassword)}
+	user := &user{Username: rreq.Username, Password: string(encryptedPassword), Email: rreq.Email, Verified: true}
+	if s.RequireEmailVerification {
+		token, err := secureToken(32)
+		if err != nil {
+			httpJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		user.Verified = false
+		user.VerificationToken = token
+	}
 	if err := user.save(s.db); err != nil {
 		httpJSONError(w, fmt.Sprintf("Failed to save user: %v", err), http.StatusInternalServerError)
 		return
 	}
 	log.Printf("User %v registered", rreq.Username)
 
+	if !user.Verified {
+		if s.SendVerification == nil {
+			log.Printf("Verification token of %v: %v", user.Username, user.VerificationToken)
+		} else if err := s.SendVerification(user.Username, user.Email, user.VerificationToken); err != nil {
+			log.Printf("Failed to send the verification token of %v: %v", user.Username, err)
+		}
+	}
+
+	if len(s.EntryTokenKey) == 0 {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
 	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(s.issueEntryToken(user.Username)); err != nil {
+		httpJSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// VerifyRequest represents the email verification request. It's exposed to be used
+// by the command line client.
+type VerifyRequest struct {
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+// Handles email verification requests.
+func (s *Server) verifyHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		httpMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var vreq VerifyRequest
+	if err := json.NewDecoder(req.Body).Decode(&vreq); err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to decode request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	u, err := userQ.find(s.db, vreq.Username)
+	if err != nil || u.Verified || u.VerificationToken == "" || subtle.ConstantTimeCompare([]byte(u.VerificationToken), []byte(vreq.Token)) != 1 {
+		httpJSONError(w, "Invalid verification token", http.StatusBadRequest)
+		return
+	}
+	// Verifying clears the token, so that it can't be used again.
+	if err := u.verify(s.db); err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to verify user: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %v verified their email", u.Username)
+
+	w.WriteHeader(http.StatusOK)
 }
 
 // Handles tasks queries.
 func (s *Server) tasksHTTPHandler(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodGet {
-		httpJSONError(w, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+		httpMethodNotAllowed(w, http.MethodGet)
 		return
 	}
 
-	ts := s.tasks.tasks()
+	// Draft tasks are hidden.
+	ts := []Task{}
+	for _, t := range s.tasks.tasks() {
+		if t.state() != TaskDraft {
+			ts = append(ts, t)
+		}
+	}
 
 	w.WriteHeader(http.StatusOK)
 
@@ -293,41 +1383,354 @@ func (s *Server) tasksHTTPHandler(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// Dispatches the requests of a specific task (e.g. /tasks/<name>/run).
+func (s *Server) taskHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	parts := splitPath(req.URL.Path, "/tasks/")
+	if len(parts) == 2 && parts[1] == "run" {
+		s.runHTTPHandler(w, req, parts[0])
+		return
+	}
+	if len(parts) == 2 && parts[1] == "languages" {
+		s.taskLanguagesHTTPHandler(w, req, parts[0])
+		return
+	}
+	httpJSONError(w, "Not found", http.StatusNotFound)
+}
+
+// TaskLanguagesResponse is the response of the languages request of a task. It's
+// exposed to be used by the command line client.
+type TaskLanguagesResponse struct {
+	Task      string   `json:"task"`
+	Languages []string `json:"languages"`
+}
+
+// Handles the requests of the languages accepted by a task.
+func (s *Server) taskLanguagesHTTPHandler(w http.ResponseWriter, req *http.Request, taskName string) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	t, ok := s.tasks.get(taskName)
+	if !ok || t.state() == TaskDraft {
+		httpJSONError(w, fmt.Sprintf("Task %v not found", taskName), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(TaskLanguagesResponse{Task: t.Name, Languages: t.languages(s.LanguageImages)}); err != nil {
+		httpJSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
 // Handles scoreboard requests.
 func (s *Server) scoreboardHTTPHandler(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodGet {
-		httpJSONError(w, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	scoreboard, ok := s.scoreboard(w, req)
+	if !ok {
 		return
 	}
 
+	// The response depends on the Accept header, so caches must key on it.
+	w.Header().Add("Vary", "Accept")
+	if wantsXML(req) {
+		writeXML(w, scoreboard.response())
+		return
+	}
 	w.Header().Add("Content-Type", "text/html")
+	scoreboardTmpl.Execute(w, map[string]interface{}{
+		"Scoreboard": scoreboard,
+	})
+}
+
+// Handles the requests of the scoreboard in JSON.
+func (s *Server) scoreboardJSONHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	scoreboard, ok := s.scoreboard(w, req)
+	if !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(scoreboard.response()); err != nil {
+		httpJSONError(w, "Failed to encode scoreboard", http.StatusInternalServerError)
+		return
+	}
+}
+
+// readSubmission decodes the submission in the request body. The body is buffered
+// once so that it can be both hashed and decoded. It writes the error response and
+// returns false if it fails.
+func (s *Server) readSubmission(w http.ResponseWriter, req *http.Request) (Submission, bool) {
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, s.MaxSubmissionBytes+1))
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+		return Submission{}, false
+	}
+	s.submissionSizes.observe(int64(len(body)))
+	if int64(len(body)) > s.MaxSubmissionBytes {
+		httpJSONError(w, fmt.Sprintf("Submission exceeds the maximum size of %v bytes", s.MaxSubmissionBytes), http.StatusRequestEntityTooLarge)
+		return Submission{}, false
+	}
+
+	var sub Submission
+	if err := json.Unmarshal(body, &sub); err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to decode request body: %v", err), http.StatusBadRequest)
+		return Submission{}, false
+	}
+	hash := sha256.Sum256(body)
+	sub.bodyHash = hex.EncodeToString(hash[:])
+	return sub, true
+}
+
+// scoreboard builds the current scoreboard with its timestamps in the timezone
+// passed in the "tz" param. It's scoped to the tasks of the problem set passed in
+// the "set" param if any, and to the comma separated users passed in the "users" param
+// if any. It writes the error response and returns false if it fails.
+func (s *Server) scoreboard(w http.ResponseWriter, req *http.Request) (*scoreboard, bool) {
+	// "Local" would expose the timezone of the server, so only the named ones are accepted.
+	tz := req.URL.Query().Get("tz")
+	if tz == "Local" {
+		httpJSONError(w, "Invalid timezone: Local", http.StatusBadRequest)
+		return nil, false
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Invalid timezone: %v", err), http.StatusBadRequest)
+		return nil, false
+	}
 
 	ts := s.tasks.names()
-	sort.Strings(ts)
+	if name := req.URL.Query().Get("set"); name != "" {
+		ps, ok := s.problemSets.get(name)
+		if !ok {
+			httpJSONError(w, fmt.Sprintf("Problem set %v not found", name), http.StatusNotFound)
+			return nil, false
+		}
+		ts = s.visibleTasks(ps)
+	}
+	if s.TaskOrder != nil {
+		s.tasks.sort(ts, s.TaskOrder)
+	} else {
+		sort.Strings(ts)
+	}
 
 	us, err := userQ.usernames(s.db)
 	if err != nil {
 		httpJSONError(w, fmt.Sprintf("Failed to fetch users: %v", err), http.StatusInternalServerError)
-		return
+		return nil, false
 	}
 	sort.Strings(us)
 
-	scoreboard, err := buildScoreboard(s.db, us, ts)
+	var unknown []string
+	if users := req.URL.Query().Get("users"); users != "" {
+		us, unknown = filterUsers(us, strings.Split(users, ","))
+	}
+
+	scoreboard, err := buildScoreboard(s.db, us, ts, s.tasks.points(), s.TieBreaker, s.phase(time.Now()) == ContestFrozen)
 	if err != nil {
 		httpJSONError(w, fmt.Sprintf("Failed to build scoreboard: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+	scoreboard.Unknown = unknown
+	scoreboard.in(loc)
+	scoreboard.Unattempted = s.UnattemptedPlaceholder
+	scoreboard.Detailed = s.DetailedVerdicts
+	return scoreboard, true
+}
+
+// filterUsers returns the users of all that are wanted, and the wanted ones that
+// are not registered.
+func filterUsers(all, wanted []string) ([]string, []string) {
+	var found, unknown []string
+	for _, u := range wanted {
+		if u = strings.TrimSpace(u); u == "" || containsString(found, u) || containsString(unknown, u) {
+			continue
+		}
+		if containsString(all, u) {
+			found = append(found, u)
+		} else {
+			unknown = append(unknown, u)
+		}
+	}
+	return found, unknown
+}
+
+// Handles the submissions history requests of the authenticated user. The
+// submissions can be filtered by tag using the "tag" query param.
+func (s *Server) submissionsHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	u, ok := s.authenticate(req)
+	if !ok {
+		httpJSONError(w, "Wrong username or password", http.StatusUnauthorized)
 		return
 	}
 
-	scoreboardTmpl.Execute(w, map[string]interface{}{
-		"Scoreboard": scoreboard,
-	})
+	subs, err := getSubmissions(s.db, u.Username, req.URL.Query().Get("tag"))
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to fetch submissions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(subs); err != nil {
+		httpJSONError(w, "Failed to encode submissions", http.StatusInternalServerError)
+		return
+	}
+}
+
+// The number of events returned by /activity by default and at most.
+const (
+	defaultActivityLimit = 20
+	maxActivityLimit     = 100
+)
+
+// Handles the requests of the latest submissions of all the users. The number of
+// events can be set using the "limit" query param. Submissions of hidden tasks are excluded.
+func (s *Server) activityHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	limit := defaultActivityLimit
+	if l := req.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			httpJSONError(w, fmt.Sprintf("Invalid limit: %v", l), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if limit > maxActivityLimit {
+		limit = maxActivityLimit
+	}
+
+	events, err := getActivity(s.db, s.tasks.names(), limit, s.phase(time.Now()) == ContestFrozen)
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to fetch activity: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		httpJSONError(w, "Failed to encode activity", http.StatusInternalServerError)
+		return
+	}
 }
 
+// acquireStream reserves one of the streaming slots of the user. It writes the
+// error response and returns false if the user has no free slot.
+func (s *Server) acquireStream(w http.ResponseWriter, username string) bool {
+	if !s.userStreams.acquire(username, s.MaxStreamsPerUser) {
+		httpJSONError(w, fmt.Sprintf("Too many concurrent streams, at most %v are allowed", s.MaxStreamsPerUser), http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// Dispatches the requests of a specific submission (e.g. /submissions/<id>/diff).
+func (s *Server) submissionHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	parts := splitPath(req.URL.Path, "/submissions/")
+	if len(parts) == 2 && parts[1] == "diff" {
+		s.diffHTTPHandler(w, req, parts[0])
+		return
+	}
+	if len(parts) == 2 && parts[1] == "wait" {
+		s.waitHTTPHandler(w, req, parts[0])
+		return
+	}
+	httpJSONError(w, "Not found", http.StatusNotFound)
+}
+
+// Handles the requests of the diffs of the failed sample cases of a submission of
+// the authenticated user.
+func (s *Server) diffHTTPHandler(w http.ResponseWriter, req *http.Request, id string) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	u, ok := s.authenticate(req)
+	if !ok {
+		httpJSONError(w, "Wrong username or password", http.StatusUnauthorized)
+		return
+	}
+
+	owner, err := getSubmissionOwner(s.db, id)
+	if err == sql.ErrNoRows || (err == nil && owner != u.Username) {
+		httpJSONError(w, fmt.Sprintf("Submission %v not found", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to fetch submission: %v", err), http.StatusInternalServerError)
+		return
+	}
+	diffs, err := getSubmissionDiffs(s.db, id)
+	if err != nil {
+		httpJSONError(w, fmt.Sprintf("Failed to fetch diffs: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(diffs); err != nil {
+		httpJSONError(w, "Failed to encode diffs", http.StatusInternalServerError)
+		return
+	}
+}
+
+// UptimeResponse is the response of the uptime request.
+type UptimeResponse struct {
+	StartedAt time.Time `json:"startedAt"`
+	// The elapsed time since the server started in seconds.
+	Uptime float64 `json:"uptime"`
+}
+
+// Handles uptime requests.
+func (s *Server) uptimeHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	resp := UptimeResponse{
+		StartedAt: s.startedAt,
+		Uptime:    time.Since(s.startedAt).Seconds(),
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		httpJSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// proccessDockerEvents forwards the start and die events of the containers to the
+// executors of the running submissions. The listener is registered again whenever
+// the events stream is closed (e.g. the docker daemon restarted).
 func (s *Server) proccessDockerEvents() {
-	listener := make(chan *docker.APIEvents)
-	if err := s.dockerClient.AddEventListener(listener); err != nil {
-		log.Fatal(err)
+	for {
+		listener := make(chan *docker.APIEvents)
+		if err := s.docker().AddEventListener(listener); err != nil {
+			log.Printf("Failed to listen to docker events: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		s.forwardDockerEvents(listener)
+		log.Printf("Docker events stream closed, listening again")
+		time.Sleep(time.Second)
 	}
+}
+
+func (s *Server) forwardDockerEvents(listener chan *docker.APIEvents) {
 	for e := range listener {
 		if e.Type != "container" || (e.Action != "start" && e.Action != "die") {
 			continue
@@ -354,18 +1757,107 @@ func (s *Server) proccessDockerEvents() {
 	}
 }
 
+// compressed wraps the read handler to compress its responses if compression is enabled.
+func (s *Server) compressed(h http.HandlerFunc) http.HandlerFunc {
+	if s.CompressionMinBytes <= 0 {
+		return h
+	}
+	return gzipHandler(h, s.CompressionMinBytes)
+}
+
+// cached sets the Cache-Control header of the GET requests of a public read handler.
+func (s *Server) cached(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet && s.PublicCacheControl != "" {
+			w.Header().Set("Cache-Control", s.PublicCacheControl)
+		}
+		h(w, req)
+	}
+}
+
+// noStore prevents the responses of an authenticated handler from being cached.
+func noStore(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		h(w, req)
+	}
+}
+
 // Start starts the http server and the goroutine responsible for processing
 // the submissions.
 func (s *Server) Start() error {
 	if err := s.initDB(); err != nil {
 		return fmt.Errorf("failed to init the database: %v", err)
 	}
-	go s.processSubmissions()
+	if s.SequentialSubmissionIDs {
+		last, err := getLastSequentialID(s.db)
+		if err != nil {
+			return err
+		}
+		s.submissionIDs.reset(last)
+	}
+	nets, err := parseCIDRs(s.AllowedCIDRs)
+	if err != nil {
+		return fmt.Errorf("failed to parse the allowed CIDRs: %v", err)
+	}
+	s.allowedNets = nets
+	if s.Workers < 1 {
+		return fmt.Errorf("the number of workers must be at least 1, got %v", s.Workers)
+	}
+	s.startedAt = time.Now()
+	s.submissionSizes = newSizeHistogram(s.SubmissionSizeBuckets)
+	s.workers.set(s.Workers, s.processSubmissions)
 	go s.proccessDockerEvents()
+	go s.monitorDocker()
+	go s.deliverWebhooks()
+	if s.MaxWorkers > s.Workers {
+		go s.autoscaleWorkers()
+	}
+	if s.OrphanSweepInterval > 0 {
+		go s.sweepOrphanContainers()
+	}
+	if s.RankSnapshotInterval > 0 {
+		go s.snapshotRanks()
+	}
+	if s.ImageRepullInterval > 0 {
+		go s.repullImages()
+	}
+	return http.ListenAndServe(s.address, s.handler())
+}
+
+// handler returns the handler routing the requests to the server's endpoints.
+func (s *Server) handler() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/submit", s.submitHTTPHandler)
-	mux.HandleFunc("/register", s.registerHTTPHandler)
-	mux.HandleFunc("/tasks", s.tasksHTTPHandler)
-	mux.HandleFunc("/scoreboard", s.scoreboardHTTPHandler)
-	return http.ListenAndServe(s.address, mux)
+	mux.HandleFunc("/submit", noStore(s.submitHTTPHandler))
+	mux.HandleFunc("/register", noStore(s.registerHTTPHandler))
+	mux.HandleFunc("/verify", noStore(s.verifyHTTPHandler))
+	mux.HandleFunc("/tasks", s.cached(s.compressed(s.tasksHTTPHandler)))
+	mux.HandleFunc("/tasks/", noStore(s.taskHTTPHandler))
+	mux.HandleFunc("/sets", s.cached(s.compressed(s.problemSetsHTTPHandler)))
+	mux.HandleFunc("/sets/", s.cached(s.compressed(s.problemSetHTTPHandler)))
+	mux.HandleFunc("/scoreboard", s.cached(s.compressed(s.scoreboardHTTPHandler)))
+	mux.HandleFunc("/scoreboard.json", s.cached(s.compressed(s.scoreboardJSONHTTPHandler)))
+	mux.HandleFunc("/submissions", noStore(s.compressed(s.submissionsHTTPHandler)))
+	mux.HandleFunc("/submissions/", noStore(s.submissionHTTPHandler))
+	mux.HandleFunc("/activity", s.cached(s.compressed(s.activityHTTPHandler)))
+	mux.HandleFunc("/metrics", s.compressed(s.metricsHTTPHandler))
+	mux.HandleFunc("/stats/sizes", s.compressed(s.sizesHTTPHandler))
+	mux.HandleFunc("/uptime", s.uptimeHTTPHandler)
+	mux.HandleFunc("/contest/state", noStore(s.contestStateHTTPHandler))
+	mux.HandleFunc("/nonce", noStore(s.nonceHTTPHandler))
+	mux.HandleFunc("/me/unattempted", noStore(s.compressed(s.unattemptedHTTPHandler)))
+	mux.HandleFunc("/me/password", noStore(s.passwordHTTPHandler))
+	mux.HandleFunc("/me/streak", noStore(s.streakHTTPHandler))
+	mux.HandleFunc("/me/history", noStore(s.compressed(s.historyHTTPHandler)))
+	mux.HandleFunc("/me/entry-token", noStore(s.entryTokenHTTPHandler))
+	mux.HandleFunc("/admin/tasks/", noStore(s.adminTaskHTTPHandler))
+	mux.HandleFunc("/admin/submissions/", noStore(s.adminSubmissionHTTPHandler))
+	mux.HandleFunc("/admin/workers", noStore(s.workersHTTPHandler))
+	mux.HandleFunc("/admin/export", noStore(s.exportHTTPHandler))
+	mux.HandleFunc("/admin/contest/golive", noStore(s.goLiveHTTPHandler))
+	mux.HandleFunc("/admin/scoreboard/preview", noStore(s.scoreboardPreviewHTTPHandler))
+	mux.HandleFunc("/admin/scoreboard/simulate", noStore(s.scoreboardSimulationHTTPHandler))
+	mux.HandleFunc("/admin/users/", noStore(s.adminUserHTTPHandler))
+	mux.HandleFunc("/admin/impersonations/", noStore(s.revokeImpersonationHTTPHandler))
+	return s.blockAbusiveClients(mux)
 }
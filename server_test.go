@@ -0,0 +1,939 @@
+package godge
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeDocker is a docker client whose containers exit right away after printing
+// stdout. The start and die events of the containers are sent to the listeners. Creating a container of the crashImage panics, as do the calls not used
+// by the executors.
+type fakeDocker struct {
+	dockerAPI
+	stdout string
+	// If set, creating containers fails with it.
+	createErr error
+	// If set, pinging the daemon fails with it.
+	pingErr error
+	// The images returned by InspectImage by name. The other images have their name as ID.
+	images map[string]docker.Image
+	// The images missing from the daemon until they are pulled, as repository:tag.
+	missing map[string]bool
+	// The containers returned by ListContainers.
+	listed []docker.APIContainers
+	// Called by WaitContainer to run the container (e.g. runOnHost). The containers
+	// exit right away with code 0 if nil.
+	run func(opts docker.CreateContainerOptions) int
+	// If set, the containers never exit (nor die) until they are killed.
+	hang bool
+	// If set, the stats streamed for each container. The logs of the containers are
+	// only available once their stats are delivered, so they must all be watched.
+	stats []docker.Stats
+	// The exit code of the exited containers reported by InspectContainer.
+	exitCode int
+	// The content of the files of the containers outside of their binds by path,
+	// e.g. the markers written by the executors' scripts.
+	files map[string]string
+
+	mu      sync.Mutex
+	created int
+	// The pulled images as repository:tag.
+	pulled []string
+	// The host dirs bound to the created containers.
+	binds []string
+	// The options of the created containers by ID.
+	containers map[string]docker.CreateContainerOptions
+	// Closed when the container is killed or removed.
+	stopped map[string]chan struct{}
+	// Closed when the stats of the container are delivered.
+	statsSent map[string]chan struct{}
+	killed    []string
+	removed   []string
+	listeners []chan<- *docker.APIEvents
+}
+
+func (d *fakeDocker) AddEventListener(listener chan<- *docker.APIEvents) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.listeners = append(d.listeners, listener)
+	return nil
+}
+
+// emit sends the event of the container to the listeners.
+func (d *fakeDocker) emit(id, action string) {
+	d.mu.Lock()
+	listeners := append([]chan<- *docker.APIEvents(nil), d.listeners...)
+	d.mu.Unlock()
+	for _, l := range listeners {
+		l <- &docker.APIEvents{Type: "container", Action: action, Actor: docker.APIActor{ID: id}}
+	}
+}
+
+func (d *fakeDocker) Ping() error {
+	return d.pingErr
+}
+
+func (d *fakeDocker) PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pulled = append(d.pulled, opts.Repository+":"+opts.Tag)
+	delete(d.missing, opts.Repository+":"+opts.Tag)
+	return nil
+}
+
+// pulledImages returns the pulled images in order.
+func (d *fakeDocker) pulledImages() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.pulled...)
+}
+
+func (d *fakeDocker) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	return d.listed, nil
+}
+
+func (d *fakeDocker) InspectImage(name string) (*docker.Image, error) {
+	d.mu.Lock()
+	missing := d.missing[name]
+	d.mu.Unlock()
+	if missing {
+		return nil, docker.ErrNoSuchImage
+	}
+	if img, ok := d.images[name]; ok {
+		return &img, nil
+	}
+	return &docker.Image{ID: name}, nil
+}
+
+const crashImage = "crash"
+
+func (d *fakeDocker) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	if opts.Config.Image == crashImage {
+		panic("docker client crashed")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.created++
+	if d.createErr != nil {
+		return nil, d.createErr
+	}
+	for _, b := range opts.HostConfig.Binds {
+		d.binds = append(d.binds, strings.Split(b, ":")[0])
+	}
+	id := fmt.Sprintf("container-%v", d.created)
+	if d.containers == nil {
+		d.containers = make(map[string]docker.CreateContainerOptions)
+		d.stopped = make(map[string]chan struct{})
+		d.statsSent = make(map[string]chan struct{})
+	}
+	d.containers[id] = opts
+	d.stopped[id] = make(chan struct{})
+	d.statsSent[id] = make(chan struct{})
+	return &docker.Container{ID: id, Image: opts.Config.Image}, nil
+}
+
+// createOptions returns the options of the created containers in creation order.
+func (d *fakeDocker) createOptions() []docker.CreateContainerOptions {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var ret []docker.CreateContainerOptions
+	for i := 1; i <= d.created; i++ {
+		if opts, ok := d.containers[fmt.Sprintf("container-%v", i)]; ok {
+			ret = append(ret, opts)
+		}
+	}
+	return ret
+}
+
+func (d *fakeDocker) container(id string) (docker.CreateContainerOptions, chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.containers[id], d.stopped[id]
+}
+
+// stop marks the container as stopped, and returns false if it already was.
+func (d *fakeDocker) stop(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ch, ok := d.stopped[id]
+	if !ok {
+		return false
+	}
+	select {
+	case <-ch:
+		return false
+	default:
+		close(ch)
+		return true
+	}
+}
+
+func (d *fakeDocker) WaitContainer(id string) (int, error) {
+	opts, stopped := d.container(id)
+	if d.hang {
+		<-stopped
+		return 137, nil
+	}
+	if d.run != nil {
+		return d.run(opts), nil
+	}
+	return 0, nil
+}
+
+func (d *fakeDocker) KillContainer(opts docker.KillContainerOptions) error {
+	if d.stop(opts.ID) && d.hang {
+		d.emit(opts.ID, "die")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.killed = append(d.killed, opts.ID)
+	return nil
+}
+
+func (d *fakeDocker) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	if d.stop(opts.ID) && d.hang {
+		d.emit(opts.ID, "die")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.removed = append(d.removed, opts.ID)
+	return nil
+}
+
+// killedAndRemoved returns the IDs of the killed and the removed containers.
+func (d *fakeDocker) killedAndRemoved() ([]string, []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.killed...), append([]string(nil), d.removed...)
+}
+
+// hostPath returns the path on the host of the path in the container, if it's in
+// one of the container's binds.
+func hostPath(opts docker.CreateContainerOptions, path string) (string, bool) {
+	for _, b := range opts.HostConfig.Binds {
+		parts := strings.Split(b, ":")
+		if path == parts[1] || strings.HasPrefix(path, parts[1]+"/") {
+			return parts[0] + strings.TrimPrefix(path, parts[1]), true
+		}
+	}
+	return "", false
+}
+
+// runOnHost runs the command of the container on the host in its working dir, and
+// returns 0 if it succeeds or 1 otherwise.
+func runOnHost(opts docker.CreateContainerOptions) int {
+	dir, ok := hostPath(opts, opts.Config.WorkingDir)
+	if !ok {
+		return 1
+	}
+	cmd := exec.Command(opts.Config.Cmd[0], opts.Config.Cmd[1:]...)
+	cmd.Dir = dir
+	if err := cmd.Run(); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// DownloadFromContainer writes the content of the file if it's in one of the
+// container's binds.
+func (d *fakeDocker) DownloadFromContainer(id string, opts docker.DownloadFromContainerOptions) error {
+	c, _ := d.container(id)
+	path, ok := hostPath(c, opts.Path)
+	if !ok {
+		content, ok := d.files[opts.Path]
+		if !ok {
+			return fmt.Errorf("no such file %v", opts.Path)
+		}
+		_, err := opts.OutputStream.Write([]byte(content))
+		return err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = opts.OutputStream.Write(b)
+	return err
+}
+
+func (d *fakeDocker) createdContainers() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.created
+}
+
+func (d *fakeDocker) boundDirs() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.binds...)
+}
+
+func (d *fakeDocker) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	d.emit(id, "start")
+	if !d.hang {
+		d.emit(id, "die")
+	}
+	return nil
+}
+
+func (d *fakeDocker) InspectContainer(id string) (*docker.Container, error) {
+	return &docker.Container{ID: id, State: docker.State{ExitCode: d.exitCode}}, nil
+}
+
+func (d *fakeDocker) Stats(opts docker.StatsOptions) error {
+	defer close(opts.Stats)
+	if d.stats == nil {
+		return nil
+	}
+	// Sending the last stats twice makes sure that the ones before were recorded.
+	stats := append(append([]docker.Stats{}, d.stats...), d.stats[len(d.stats)-1])
+	for i := range stats {
+		opts.Stats <- &stats[i]
+	}
+	d.mu.Lock()
+	close(d.statsSent[opts.ID])
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *fakeDocker) Logs(opts docker.LogsOptions) error {
+	if d.stats != nil {
+		d.mu.Lock()
+		sent := d.statsSent[opts.Container]
+		d.mu.Unlock()
+		<-sent
+	}
+	if opts.Stdout {
+		_, err := opts.OutputStream.Write([]byte(d.stdout))
+		return err
+	}
+	return nil
+}
+
+func (d *fakeDocker) StopContainer(id string, timeout uint) error {
+	return nil
+}
+
+// testServer is a server using a fake docker client and a temp database with a
+// single registered user.
+type testServer struct {
+	*Server
+	dir string
+}
+
+const (
+	testUsername = "alice"
+	testPassword = "secret"
+)
+
+func newTestServer(t *testing.T, dc dockerAPI) *testServer {
+	dir, err := ioutil.TempDir("", "godge-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	db, err := sqlx.Connect("sqlite3", filepath.Join(dir, "godge.db"))
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	ts := startTestServer(t, dc, dir, db)
+	ts.addUser(t, testUsername)
+	return ts
+}
+
+// startTestServer starts a test server using the database in dir, e.g. the one of
+// a previous contest.
+func startTestServer(t *testing.T, dc dockerAPI, dir string, db *sqlx.DB) *testServer {
+	s := newServer("", "", dc, db)
+	s.submissionSizes = newSizeHistogram(s.SubmissionSizeBuckets)
+	if err := s.initDB(); err != nil {
+		t.Fatalf("Failed to init the database: %v", err)
+	}
+	listener := make(chan *docker.APIEvents)
+	if err := dc.AddEventListener(listener); err != nil {
+		t.Fatalf("Failed to listen to docker events: %v", err)
+	}
+	go s.forwardDockerEvents(listener)
+	s.workers.set(1, s.processSubmissions)
+	return &testServer{Server: s, dir: dir}
+}
+
+// addUser registers a verified user with the testPassword.
+func (s *testServer) addUser(t *testing.T, username string) {
+	password, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	u := &user{Username: username, Password: string(password), Verified: true}
+	if err := u.save(s.db); err != nil {
+		t.Fatalf("Failed to save user: %v", err)
+	}
+}
+
+func (s *testServer) close() {
+	s.workers.set(0, s.processSubmissions)
+	s.db.Close()
+	os.RemoveAll(s.dir)
+}
+
+// serve serves the request of the user, or an anonymous one if username is empty,
+// with the handler.
+func serve(h http.HandlerFunc, username, method, url string, body []byte, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, bytes.NewReader(body))
+	if username != "" {
+		req.SetBasicAuth(username, testPassword)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h(w, req)
+	return w
+}
+
+// do serves the request of the testUsername with the handler and decodes the JSON
+// response into resp, unless it's nil. It returns the status code of the response.
+func (s *testServer) do(t *testing.T, h http.HandlerFunc, method, url string, body []byte, header http.Header, resp interface{}) int {
+	return s.doAs(t, testUsername, h, method, url, body, header, resp)
+}
+
+// doAs is do for the given user.
+func (s *testServer) doAs(t *testing.T, username string, h http.HandlerFunc, method, url string, body []byte, header http.Header, resp interface{}) int {
+	w := serve(h, username, method, url, body, header)
+	if resp != nil && w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(resp); err != nil {
+			t.Fatalf("Failed to decode response of %v %v: %v", method, url, err)
+		}
+	}
+	return w.Code
+}
+
+// errorOf returns the error message of the JSON error response.
+func errorOf(t *testing.T, w *httptest.ResponseRecorder) string {
+	var e ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		t.Fatalf("Failed to decode error response %q: %v", w.Body.String(), err)
+	}
+	return e.Error
+}
+
+// submit submits the body as the user and returns the status code and the response.
+func (s *testServer) submit(t *testing.T, username string, body []byte) (int, SubmissionResponse) {
+	var resp SubmissionResponse
+	code := s.doAs(t, username, s.submitHTTPHandler, http.MethodPost, "/submit", body, nil, &resp)
+	return code, resp
+}
+
+// submissions waits for the testUsername's n submissions to be reported and
+// returns the ones tagged with tag, see getSubmissions.
+func (s *testServer) submissions(t *testing.T, n int, tag string) []SubmissionRecord {
+	return s.submissionsOf(t, testUsername, n, tag)
+}
+
+// submissionsOf is submissions for the given user.
+func (s *testServer) submissionsOf(t *testing.T, username string, n int, tag string) []SubmissionRecord {
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		all, err := getSubmissions(s.db, username, "")
+		if err != nil {
+			t.Fatalf("Failed to get submissions: %v", err)
+		}
+		if len(all) >= n {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Got %v submissions, want %v", len(all), n)
+		}
+	}
+	subs, err := getSubmissions(s.db, username, tag)
+	if err != nil {
+		t.Fatalf("Failed to get submissions: %v", err)
+	}
+	return subs
+}
+
+// waitFor waits for the condition to hold, or fails the test after 5 seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for start := time.Now(); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Timed out waiting for %v", what)
+		}
+	}
+}
+
+// count returns the number of rows of the table.
+func (s *testServer) count(t *testing.T, table string) int {
+	var n int
+	if err := s.db.Get(&n, "SELECT COUNT(*) FROM "+table); err != nil {
+		t.Fatalf("Failed to count the rows of %v: %v", table, err)
+	}
+	return n
+}
+
+// submission returns the body of a go submission to the task.
+func submission(t *testing.T, task string, tags ...string) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	f, err := zw.Create("main.go")
+	if err != nil {
+		t.Fatalf("Failed to create zip entry: %v", err)
+	}
+	if _, err := f.Write([]byte("package main\n\nfunc main() {}\n")); err != nil {
+		t.Fatalf("Failed to write zip entry: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to close zip: %v", err)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"language": "go",
+		"taskName": task,
+		"tags":     tags,
+		"submission": map[string]interface{}{
+			"packageArchive": buf.Bytes(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal submission: %v", err)
+	}
+	return body
+}
+
+// outputTest is a test executing the submission and comparing its stdout.
+func outputTest(name, want string) Test {
+	return Test{
+		Name: name,
+		Func: func(sub *Submission) error {
+			if err := sub.Executor.Execute(nil); err != nil {
+				return err
+			}
+			got, err := sub.Executor.Stdout()
+			if err != nil {
+				return err
+			}
+			if got != want {
+				return fmt.Errorf("want: %v, got: %v", want, got)
+			}
+			return nil
+		},
+	}
+}
+
+func TestSubmitRetriesInfrastructureErrors(t *testing.T) {
+	dc := &fakeDocker{createErr: fmt.Errorf("daemon unavailable")}
+	s := newTestServer(t, dc)
+	defer s.close()
+	s.MaxExecutionAttempts = 3
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	var resp SubmissionResponse
+	if code := s.do(t, s.submitHTTPHandler, http.MethodPost, "/submit", submission(t, "echo"), nil, &resp); code != http.StatusOK {
+		t.Fatalf("Submit returned %v, want %v", code, http.StatusOK)
+	}
+	if resp.Passed {
+		t.Errorf("Submission passed, want it to fail")
+	}
+	if got := dc.createdContainers(); got != 3 {
+		t.Errorf("Created %v containers, want one per attempt: 3", got)
+	}
+	if subs := s.submissions(t, 1, ""); len(subs) != 1 || subs[0].Verdict != infraErrorVerdict {
+		t.Errorf("Got submissions %+v, want a single one with verdict %v", subs, infraErrorVerdict)
+	}
+}
+
+func TestSubmissionsFilteredByTag(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	for _, tags := range [][]string{{"v1"}, {"v2", "optimized"}} {
+		var resp SubmissionResponse
+		if code := s.do(t, s.submitHTTPHandler, http.MethodPost, "/submit", submission(t, "echo", tags...), nil, &resp); code != http.StatusOK {
+			t.Fatalf("Submit returned %v, want %v", code, http.StatusOK)
+		}
+		if !resp.Passed {
+			t.Fatalf("Submission failed: %v", resp.Error)
+		}
+	}
+	s.submissions(t, 2, "")
+
+	var subs []SubmissionRecord
+	if code := s.do(t, s.submissionsHTTPHandler, http.MethodGet, "/submissions?tag=optimized", nil, nil, &subs); code != http.StatusOK {
+		t.Fatalf("Submissions returned %v, want %v", code, http.StatusOK)
+	}
+	if len(subs) != 1 || !reflect.DeepEqual(subs[0].Tags, []string{"v2", "optimized"}) {
+		t.Errorf("Got submissions %+v, want the one tagged [v2 optimized]", subs)
+	}
+}
+
+func TestRunSamplesOnly(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	sample := outputTest("sample", "hello")
+	sample.Sample = true
+	var hiddenRan bool
+	hidden := Test{Name: "hidden", Func: func(*Submission) error {
+		hiddenRan = true
+		return nil
+	}}
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{sample, hidden}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	var resp RunResponse
+	if code := s.do(t, s.taskHTTPHandler, http.MethodPost, "/tasks/echo/run", submission(t, "echo"), nil, &resp); code != http.StatusOK {
+		t.Fatalf("Run returned %v, want %v", code, http.StatusOK)
+	}
+	want := []TestResult{{Name: "sample", Passed: true, Stdout: "hello"}}
+	if !reflect.DeepEqual(resp.Results, want) {
+		t.Errorf("Got results %+v, want %+v", resp.Results, want)
+	}
+	if hiddenRan {
+		t.Errorf("The hidden test ran, want only the samples to run")
+	}
+	var results int
+	if err := s.db.Get(&results, "SELECT COUNT(*) FROM scoreboard"); err != nil {
+		t.Fatalf("Failed to count the results: %v", err)
+	}
+	if results != 0 {
+		t.Errorf("Got %v results on the scoreboard, want none", results)
+	}
+}
+
+func TestSubmitRecoversExecutorPanics(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	for _, task := range []Task{
+		{Name: "crash", Image: crashImage, Tests: []Test{outputTest("hello", "hello")}},
+		{Name: "echo", Tests: []Test{outputTest("hello", "hello")}},
+	} {
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+
+	var resp SubmissionResponse
+	if code := s.do(t, s.submitHTTPHandler, http.MethodPost, "/submit", submission(t, "crash"), nil, &resp); code != http.StatusOK {
+		t.Fatalf("Submit returned %v, want %v", code, http.StatusOK)
+	}
+	if resp.Passed || !strings.Contains(resp.Error, "internal error") {
+		t.Errorf("Got response %+v, want an internal error", resp)
+	}
+	if subs := s.submissions(t, 1, ""); len(subs) != 1 || subs[0].Verdict != internalErrorVerdict {
+		t.Errorf("Got submissions %+v, want a single one with verdict %v", subs, internalErrorVerdict)
+	}
+
+	// The same worker judges the next submission.
+	resp = SubmissionResponse{}
+	if code := s.do(t, s.submitHTTPHandler, http.MethodPost, "/submit", submission(t, "echo"), nil, &resp); code != http.StatusOK {
+		t.Fatalf("Submit returned %v, want %v", code, http.StatusOK)
+	}
+	if !resp.Passed {
+		t.Errorf("Submission failed after the panic: %v", resp.Error)
+	}
+}
+
+func TestSubmitRejectsReplayedNonce(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.RequireNonce = true
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	var nonce NonceResponse
+	if code := s.do(t, s.nonceHTTPHandler, http.MethodPost, "/nonce", nil, nil, &nonce); code != http.StatusOK {
+		t.Fatalf("Nonce returned %v, want %v", code, http.StatusOK)
+	}
+	header := http.Header{NonceHeader: {nonce.Nonce}}
+	if code := s.do(t, s.submitHTTPHandler, http.MethodPost, "/submit", submission(t, "echo"), header, nil); code != http.StatusOK {
+		t.Errorf("Submit with a fresh nonce returned %v, want %v", code, http.StatusOK)
+	}
+	if code := s.do(t, s.submitHTTPHandler, http.MethodPost, "/submit", submission(t, "echo"), header, nil); code != http.StatusConflict {
+		t.Errorf("Submit with a replayed nonce returned %v, want %v", code, http.StatusConflict)
+	}
+}
+
+func TestSubmissionBudget(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.SubmissionBudget = 1
+	s.Admins = []string{"admin"}
+	s.addUser(t, "admin")
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	// The async submission being judged uses the budget already.
+	if w := serve(s.submitHTTPHandler, testUsername, http.MethodPost, "/submit?async=true", submission(t, "echo"), nil); w.Code != http.StatusAccepted {
+		t.Fatalf("Async submit returned %v, want %v", w.Code, http.StatusAccepted)
+	}
+	for i := 0; i < 2; i++ {
+		w := serve(s.submitHTTPHandler, testUsername, http.MethodPost, "/submit?async=true", submission(t, "echo"), nil)
+		if w.Code != http.StatusForbidden || errorOf(t, w) != "Submission budget exhausted" {
+			t.Errorf("Submit over the budget returned %v %q, want %v", w.Code, w.Body.String(), http.StatusForbidden)
+		}
+	}
+	s.submissions(t, 1, "")
+	if code, _ := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusForbidden {
+		t.Errorf("Submit over the budget returned %v, want %v", code, http.StatusForbidden)
+	}
+
+	if code := s.doAs(t, "admin", s.adminUserHTTPHandler, http.MethodPost, "/admin/users/alice/budget", []byte(`{"budget": 2}`), nil, nil); code != http.StatusOK {
+		t.Fatalf("Overriding the budget returned %v, want %v", code, http.StatusOK)
+	}
+	if code, resp := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK || !resp.Passed {
+		t.Errorf("Submit within the overridden budget returned %v %+v, want a passed submission", code, resp)
+	}
+	s.submissions(t, 2, "")
+	if code, _ := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusForbidden {
+		t.Errorf("Submit over the overridden budget returned %v, want %v", code, http.StatusForbidden)
+	}
+}
+
+func TestUptimeIncreases(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	s.startedAt = time.Now().Add(-time.Minute)
+
+	var first, second UptimeResponse
+	if code := s.do(t, s.uptimeHTTPHandler, http.MethodGet, "/uptime", nil, nil, &first); code != http.StatusOK {
+		t.Fatalf("Uptime returned %v, want %v", code, http.StatusOK)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if code := s.do(t, s.uptimeHTTPHandler, http.MethodGet, "/uptime", nil, nil, &second); code != http.StatusOK {
+		t.Fatalf("Uptime returned %v, want %v", code, http.StatusOK)
+	}
+	if first.Uptime < 60 || second.Uptime <= first.Uptime {
+		t.Errorf("Got the uptimes %v then %v, want an increasing uptime of at least a minute", first.Uptime, second.Uptime)
+	}
+	if !first.StartedAt.Equal(s.startedAt) || !second.StartedAt.Equal(s.startedAt) {
+		t.Errorf("Got the start times %v and %v, want %v", first.StartedAt, second.StartedAt, s.startedAt)
+	}
+}
+
+func TestRoutesRejectWrongMethods(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	h := s.handler()
+	for _, r := range []struct{ path, allow string }{
+		{"/submit", "POST"},
+		{"/register", "POST"},
+		{"/verify", "POST"},
+		{"/tasks", "GET"},
+		{"/tasks/echo/run", "POST"},
+		{"/tasks/echo/languages", "GET"},
+		{"/sets", "GET"},
+		{"/sets/basics", "GET"},
+		{"/scoreboard", "GET"},
+		{"/scoreboard.json", "GET"},
+		{"/submissions", "GET"},
+		{"/submissions/1/diff", "GET"},
+		{"/submissions/1/wait", "GET"},
+		{"/activity", "GET"},
+		{"/metrics", "GET"},
+		{"/stats/sizes", "GET"},
+		{"/uptime", "GET"},
+		{"/contest/state", "GET"},
+		{"/nonce", "POST"},
+		{"/me/unattempted", "GET"},
+		{"/me/password", "POST"},
+		{"/me/streak", "GET"},
+		{"/me/history", "GET"},
+		{"/me/entry-token", "POST"},
+		{"/admin/tasks/echo/state", "POST"},
+		{"/admin/tasks/echo/points", "POST"},
+		{"/admin/submissions/1/source", "GET"},
+		{"/admin/submissions/1/logs", "GET"},
+		{"/admin/workers", "GET, POST"},
+		{"/admin/export", "GET"},
+		{"/admin/contest/golive", "POST"},
+		{"/admin/scoreboard/preview", "GET"},
+		{"/admin/scoreboard/simulate", "POST"},
+		{"/admin/users/alice/stats", "GET"},
+		{"/admin/users/alice/impersonate", "POST"},
+		{"/admin/impersonations/1", "DELETE"},
+	} {
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPatch} {
+			if strings.Contains(r.allow, method) {
+				continue
+			}
+			w := serve(h.ServeHTTP, testUsername, method, r.path, nil, nil)
+			if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != r.allow {
+				t.Errorf("%v %v returned %v with Allow %q, want %v with Allow %q", method, r.path, w.Code, w.Header().Get("Allow"), http.StatusMethodNotAllowed, r.allow)
+			}
+		}
+	}
+}
+
+func TestAllowedClients(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	nets, err := parseCIDRs([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("Failed to parse CIDRs: %v", err)
+	}
+	s.allowedNets = nets
+
+	for _, c := range []struct {
+		remoteAddr, forwardedFor string
+		trust                    bool
+		want                     bool
+	}{
+		{"10.1.2.3:1234", "", false, true},
+		{"192.168.1.1:1234", "", false, false},
+		{"192.168.1.1:1234", "10.0.0.5", false, false},
+		{"192.168.1.1:1234", "10.0.0.5", true, true},
+		{"10.1.2.3:1234", "8.8.8.8, 10.0.0.1", true, false},
+		{"10.1.2.3:1234", "", true, true},
+		{"invalid", "", false, false},
+	} {
+		s.TrustForwardedFor = c.trust
+		req := httptest.NewRequest(http.MethodPost, "/submit", nil)
+		req.RemoteAddr = c.remoteAddr
+		if c.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", c.forwardedFor)
+		}
+		if got := s.isAllowedClient(req); got != c.want {
+			t.Errorf("Got %v for %v forwarded for %q (trusted: %v), want %v", got, c.remoteAddr, c.forwardedFor, c.trust, c.want)
+		}
+	}
+
+	// The recorded requests come from 192.0.2.1.
+	s.TrustForwardedFor = false
+	if w := serve(s.submitHTTPHandler, testUsername, http.MethodPost, "/submit", submission(t, "echo"), nil); w.Code != http.StatusForbidden {
+		t.Errorf("Submit from a disallowed network returned %v, want %v", w.Code, http.StatusForbidden)
+	}
+	s.allowedNets = nil
+	if !s.isAllowedClient(httptest.NewRequest(http.MethodPost, "/submit", nil)) {
+		t.Errorf("Got a disallowed client without allowed networks, want all the clients allowed")
+	}
+}
+
+func TestCacheControlOfPublicAndAuthenticatedRoutes(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.PublicCacheControl = "max-age=5"
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	h := s.handler()
+	for _, c := range []struct {
+		method, path string
+		body         []byte
+		want         string
+	}{
+		{http.MethodGet, "/scoreboard", nil, "max-age=5"},
+		{http.MethodGet, "/scoreboard.json", nil, "max-age=5"},
+		{http.MethodGet, "/tasks", nil, "max-age=5"},
+		{http.MethodPost, "/submit", submission(t, "echo"), "no-store"},
+		{http.MethodGet, "/tasks/echo/languages", nil, "no-store"},
+		{http.MethodPost, "/tasks/echo/run", submission(t, "echo"), "no-store"},
+	} {
+		w := serve(h.ServeHTTP, testUsername, c.method, c.path, c.body, nil)
+		if got := w.Header().Get("Cache-Control"); got != c.want {
+			t.Errorf("%v %v returned Cache-Control %q, want %q", c.method, c.path, got, c.want)
+		}
+	}
+
+	s.PublicCacheControl = ""
+	if got := serve(h.ServeHTTP, "", http.MethodGet, "/scoreboard", nil, nil).Header().Get("Cache-Control"); got != "" {
+		t.Errorf("Got Cache-Control %q on the scoreboard without PublicCacheControl, want none", got)
+	}
+}
+
+func TestXMLResponses(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	accept := http.Header{"Accept": {"application/xml"}}
+
+	w := serve(s.submitHTTPHandler, testUsername, http.MethodPost, "/submit", submission(t, "echo"), accept)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/xml" || !strings.HasPrefix(w.Body.String(), xml.Header) {
+		t.Fatalf("Submit returned %v %v %q, want an XML document", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	var sub SubmissionResponse
+	if err := xml.Unmarshal(w.Body.Bytes(), &sub); err != nil {
+		t.Fatalf("Failed to decode the XML submission: %v", err)
+	}
+	if sub.XMLName.Local != "submission" || sub.ID == "" || !sub.Passed {
+		t.Errorf("Got the XML submission %+v, want a passed submission", sub)
+	}
+	s.submissions(t, 1, "")
+
+	w = serve(s.scoreboardHTTPHandler, "", http.MethodGet, "/scoreboard", nil, accept)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/xml" || !strings.HasPrefix(w.Body.String(), xml.Header) {
+		t.Fatalf("Scoreboard returned %v %v %q, want an XML document", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	for _, elem := range []string{"<scoreboard>", "<users>", "<user>alice</user>", "<task>echo</task>", "<row>", "<cell>", "<status>Succeeded</status>"} {
+		if !strings.Contains(w.Body.String(), elem) {
+			t.Errorf("Got the XML scoreboard %v, want the element %v", w.Body.String(), elem)
+		}
+	}
+	var sb ScoreboardResponse
+	if err := xml.Unmarshal(w.Body.Bytes(), &sb); err != nil {
+		t.Fatalf("Failed to decode the XML scoreboard: %v", err)
+	}
+	if len(sb.Rows) != 1 || sb.Rows[0].Username != testUsername || len(sb.Rows[0].Cells) != 1 || sb.Rows[0].Cells[0].Attempts != 1 {
+		t.Errorf("Got the XML scoreboard %+v, want a row with the attempt of %v", sb, testUsername)
+	}
+}
+
+func TestSubmitRejectedWhenLowOnDisk(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.MinFreeDiskBytes = 1 << 30
+	s.DiskCheckPath = "/staging"
+	var free uint64
+	var checked []string
+	s.freeDiskBytes = func(path string) (uint64, error) {
+		checked = append(checked, path)
+		return free, nil
+	}
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	free = 1<<30 - 1
+	if code, _ := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusServiceUnavailable {
+		t.Errorf("Submit below the free disk space returned %v, want %v", code, http.StatusServiceUnavailable)
+	}
+	free = 1 << 30
+	if code, resp := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK || !resp.Passed {
+		t.Errorf("Submit with enough free disk space returned %v %+v, want a passed submission", code, resp)
+	}
+	if want := []string{"/staging", "/staging"}; !reflect.DeepEqual(checked, want) {
+		t.Errorf("Got the free disk space checked in %v, want %v", checked, want)
+	}
+	s.submissions(t, 1, "")
+
+	// Failing to check doesn't reject the submissions.
+	s.freeDiskBytes = func(string) (uint64, error) { return 0, fmt.Errorf("unsupported") }
+	if code, _ := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK {
+		t.Errorf("Submit failing to check the free disk space returned %v, want %v", code, http.StatusOK)
+	}
+	s.submissions(t, 2, "")
+}
@@ -0,0 +1,58 @@
+package godge
+
+import (
+	"log"
+	"time"
+)
+
+// Outcome is the outcome of a judged submission, reported to the ResultSinks.
+type Outcome struct {
+	SubmissionID string
+	Username     string
+	TaskName     string
+	Language     string
+	Tags         []string
+	// One of "Passed", "Failed", "Infrastructure Error", "Internal Error" or
+	// "Environment Error".
+	Verdict string
+	// The category of the failure (e.g. "Wrong Answer") if the submission failed.
+	Category string
+	// The error of the submission if it didn't pass.
+	Error    string
+	Result   Result
+	Subtasks []SubtaskResult
+	Seed     int64
+	JudgedAt time.Time
+	// Whether the submission was judged while the scoreboard was frozen.
+	Frozen bool
+}
+
+// ResultSink persists the outcomes of the judged submissions (e.g. in the database
+// of another system). The sinks are called by the workers after the outcome is saved
+// to the scoreboard, so slow sinks delay the judging of the next submissions.
+type ResultSink interface {
+	SaveOutcome(Outcome) error
+}
+
+// ResultSinkFunc is an adapter to use ordinary functions as ResultSinks.
+type ResultSinkFunc func(Outcome) error
+
+// SaveOutcome calls f(o).
+func (f ResultSinkFunc) SaveOutcome(o Outcome) error {
+	return f(o)
+}
+
+// saveToSinks reports the outcome of the submission to the server's and the task's
+// sinks. Failures are logged and don't affect the submission.
+func (s *Server) saveToSinks(sub *Submission, o Outcome) {
+	var sinks []ResultSink
+	sinks = append(sinks, s.ResultSinks...)
+	if t, ok := s.tasks.get(sub.TaskName); ok {
+		sinks = append(sinks, t.ResultSinks...)
+	}
+	for _, sink := range sinks {
+		if err := sink.SaveOutcome(o); err != nil {
+			log.Printf("Failed to save the outcome of submission %v to a result sink: %v", sub.id, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package godge
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestResultSinksReceiveOutcomes(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	outcomes := make(chan Outcome, 4)
+	taskOutcomes := make(chan Outcome, 4)
+	s.ResultSinks = []ResultSink{
+		// A failing sink doesn't keep the others from receiving the outcomes.
+		ResultSinkFunc(func(Outcome) error { return fmt.Errorf("unavailable") }),
+		ResultSinkFunc(func(o Outcome) error {
+			outcomes <- o
+			return nil
+		}),
+	}
+	taskSink := ResultSinkFunc(func(o Outcome) error {
+		taskOutcomes <- o
+		return nil
+	})
+	for _, task := range []Task{
+		{Name: "echo", Tests: []Test{outputTest("hello", "hello")}, ResultSinks: []ResultSink{taskSink}},
+		{Name: "bye", Cases: []Case{{Name: "bye", Accepted: []string{"bye"}}}},
+	} {
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+
+	for _, c := range []struct {
+		task, verdict, category string
+	}{
+		{"echo", passedVerdict, ""},
+		{"bye", failedVerdict, wrongAnswerCategory},
+	} {
+		code, resp := s.submit(t, testUsername, submission(t, c.task, "v1"))
+		if code != http.StatusOK {
+			t.Fatalf("Submit to %v returned %v, want %v", c.task, code, http.StatusOK)
+		}
+		select {
+		case o := <-outcomes:
+			if o.SubmissionID != resp.ID || o.Username != testUsername || o.TaskName != c.task || o.Verdict != c.verdict || o.Category != c.category {
+				t.Errorf("Got the outcome %+v, want the %v outcome of %v to %v with the category %q", o, c.verdict, resp.ID, c.task, c.category)
+			}
+			if len(o.Tags) != 1 || o.Tags[0] != "v1" || o.JudgedAt.IsZero() {
+				t.Errorf("Got the outcome %+v, want it tagged v1 and judged", o)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("The outcome of the submission to %v wasn't saved", c.task)
+		}
+	}
+
+	// The task's sinks only receive the outcomes of the task.
+	select {
+	case o := <-taskOutcomes:
+		if o.TaskName != "echo" {
+			t.Errorf("Got the outcome %+v in the sink of echo, want the outcome of echo", o)
+		}
+	default:
+		t.Errorf("The sink of echo didn't receive its outcome")
+	}
+	if n := len(taskOutcomes); n != 0 {
+		t.Errorf("Got %v more outcomes in the sink of echo, want none", n)
+	}
+}
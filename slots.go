@@ -0,0 +1,34 @@
+package godge
+
+import (
+	"sync"
+)
+
+// slots counts the slots in use for each key, e.g. the in flight submissions of
+// each user group or the open streams of each user.
+type slots struct {
+	sync.Mutex
+	m map[string]int
+}
+
+// acquire reserves a slot for the key. It returns false if the key already
+// uses quota slots. A non positive quota is unlimited.
+func (g *slots) acquire(key string, quota int) bool {
+	g.Lock()
+	defer g.Unlock()
+	if quota > 0 && g.m[key] >= quota {
+		return false
+	}
+	g.m[key]++
+	return true
+}
+
+// release frees a slot reserved by acquire.
+func (g *slots) release(key string) {
+	g.Lock()
+	defer g.Unlock()
+	g.m[key]--
+	if g.m[key] <= 0 {
+		delete(g.m, key)
+	}
+}
@@ -0,0 +1,115 @@
+package godge
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var errSlowClient = errors.New("the client can't keep up with the stream")
+
+// streamBuffer is a bounded buffer between the producer of a stream (e.g. the logs
+// of a container) and a possibly slow client, so that the producer never blocks on
+// the client for longer than the write timeout.
+type streamBuffer struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	max     int
+	timeout time.Duration
+	closed  bool
+	err     error
+	// Signalled when data is written or the buffer is closed.
+	ready chan struct{}
+	// Signalled when the buffered data is consumed.
+	space chan struct{}
+}
+
+func newStreamBuffer(max int, timeout time.Duration) *streamBuffer {
+	return &streamBuffer{
+		max:     max,
+		timeout: timeout,
+		ready:   make(chan struct{}, 1),
+		space:   make(chan struct{}, 1),
+	}
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// Write buffers p, waiting up to the write timeout for the client to consume enough
+// of the buffer. It fails with errSlowClient if the client doesn't, in which case the
+// buffer is closed. Writes larger than the buffer are accepted when it's empty.
+func (b *streamBuffer) Write(p []byte) (int, error) {
+	timer := time.NewTimer(b.timeout)
+	defer timer.Stop()
+	for {
+		b.mu.Lock()
+		if b.closed {
+			err := b.err
+			b.mu.Unlock()
+			if err == nil {
+				err = io.ErrClosedPipe
+			}
+			return 0, err
+		}
+		if b.buf.Len() == 0 || b.buf.Len()+len(p) <= b.max {
+			b.buf.Write(p)
+			b.mu.Unlock()
+			signal(b.ready)
+			return len(p), nil
+		}
+		b.mu.Unlock()
+		select {
+		case <-b.space:
+		case <-timer.C:
+			b.close(errSlowClient)
+			return 0, errSlowClient
+		}
+	}
+}
+
+// close stops the stream. The buffered data is still consumed unless err is set.
+func (b *streamBuffer) close(err error) {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		b.err = err
+	}
+	b.mu.Unlock()
+	signal(b.ready)
+}
+
+// consume writes the buffered data to w until the buffer is closed. It returns the
+// error the buffer was closed with, or the error of writing to w.
+func (b *streamBuffer) consume(w io.Writer) error {
+	for range b.ready {
+		b.mu.Lock()
+		if b.err != nil {
+			b.mu.Unlock()
+			return b.err
+		}
+		p := make([]byte, b.buf.Len())
+		copy(p, b.buf.Bytes())
+		b.buf.Reset()
+		closed := b.closed
+		b.mu.Unlock()
+		signal(b.space)
+
+		if len(p) > 0 {
+			if _, err := w.Write(p); err != nil {
+				b.close(err)
+				return err
+			}
+		}
+		if closed {
+			return nil
+		}
+	}
+	return nil
+}
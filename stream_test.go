@@ -0,0 +1,89 @@
+package godge
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingWriter blocks the writes until unblock is closed.
+type blockingWriter struct {
+	unblock chan struct{}
+}
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	return len(p), nil
+}
+
+// syncBuffer is a buffer safe to write and read concurrently.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestStreamBufferDisconnectsSlowClient(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	slow, fast := newStreamBuffer(8, timeout), newStreamBuffer(8, timeout)
+	unblock := make(chan struct{})
+	slowDone, fastDone := make(chan error, 1), make(chan error, 1)
+	received := new(syncBuffer)
+	go func() { slowDone <- slow.consume(blockingWriter{unblock}) }()
+	go func() { fastDone <- fast.consume(received) }()
+
+	var slowErr error
+	var writes int
+	for ; writes < 100 && slowErr == nil; writes++ {
+		start := time.Now()
+		if _, slowErr = slow.Write([]byte("ab")); slowErr != nil {
+			if slowErr != errSlowClient {
+				t.Errorf("Got %v writing to the slow client, want %v", slowErr, errSlowClient)
+			}
+			if d := time.Since(start); d < timeout || d > 20*timeout {
+				t.Errorf("The write to the slow client failed after %v, want after the %v timeout", d, timeout)
+			}
+		}
+		if _, err := fast.Write([]byte("ab")); err != nil {
+			t.Fatalf("Failed to write to the fast client: %v", err)
+		}
+	}
+	if slowErr == nil {
+		t.Fatalf("Wrote %v times to the slow client, want it disconnected", writes)
+	}
+	if _, err := slow.Write([]byte("ab")); err != errSlowClient {
+		t.Errorf("Got %v writing to the disconnected client, want %v", err, errSlowClient)
+	}
+
+	// The fast client keeps receiving the stream.
+	for i := 0; i < 10; i++ {
+		if _, err := fast.Write([]byte("ab")); err != nil {
+			t.Fatalf("Failed to write to the fast client after the slow one was disconnected: %v", err)
+		}
+	}
+	fast.close(nil)
+	if err := <-fastDone; err != nil {
+		t.Errorf("The fast client's stream ended with %v, want nil", err)
+	}
+	if got, want := received.String(), strings.Repeat("ab", writes+10); got != want {
+		t.Errorf("The fast client received %q, want %q", got, want)
+	}
+
+	close(unblock)
+	if err := <-slowDone; err != errSlowClient {
+		t.Errorf("The slow client's stream ended with %v, want %v", err, errSlowClient)
+	}
+}
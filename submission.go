@@ -1,21 +1,195 @@
 package godge
 
 import (
+	"archive/zip"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
+// submissionIDs numbers the submissions sequentially when the server doesn't give
+// them random IDs.
+type submissionIDs struct {
+	sync.Mutex
+	last int64
+	// The number of numbered submissions not yet judged.
+	judging int
+}
+
+// next returns the ID of the next submission. done must be called once the
+// submission is judged.
+func (s *submissionIDs) next() string {
+	s.Lock()
+	defer s.Unlock()
+	s.last++
+	s.judging++
+	return strconv.FormatInt(s.last, 10)
+}
+
+// done marks a numbered submission as judged.
+func (s *submissionIDs) done() {
+	s.Lock()
+	defer s.Unlock()
+	s.judging--
+}
+
+// restart runs f and makes the numbering start over if it succeeds, unless a
+// numbered submission is still being judged, in which case it returns false. No
+// submission is numbered meanwhile.
+func (s *submissionIDs) restart(f func() error) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.judging > 0 {
+		return false, nil
+	}
+	if err := f(); err != nil {
+		return true, err
+	}
+	s.last = 0
+	return true, nil
+}
+
+// reset makes the numbering continue after the given ID.
+func (s *submissionIDs) reset(last int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.last = last
+}
+
 // Submission is the input of the user defined task tests.
 type Submission struct {
 	id string
-	// The language of the submission.
+	// The hex encoded sha256 of the raw submission request body.
+	bodyHash string
+	// Whether the submission holds a reservation of the user's budget, see
+	// reserveBudget.
+	budgetReserved bool
+	// Identifies the identical submissions whose verdict is reused, set if the
+	// server caches verdicts (see verdictKey).
+	verdictKey string
+	// The tests passed and the fraction of the task's points earned by the submission,
+	// set by the execution.
+	result Result
+	// The results of the task's subtasks, set by the execution.
+	subtasks []SubtaskResult
+	// The diffs of the failed sample cases, set by the execution.
+	diffs []CaseDiff
+	// The category of the failure of the submission (e.g. Wrong Answer), set by the execution.
+	category string
+	// The language of the submission. If empty, it's detected from the extensions of the submitted files.
 	Language string `json:"language"`
 	// The task this submission is sent to.
 	TaskName string `json:"taskName"`
 	// The username of the submitter.
 	Username string `json:"username"`
+	// Optional tags used by the submitter to organize their submissions (e.g. "v2").
+	Tags []string `json:"tags"`
 	// The executor interface to deal with the submission.
 	Executor Executor `json:"submission"`
+	// The seed of the randomized inputs of the tests, set by the server. It's the
+	// server's Seed if set, or a random seed saved with the submission otherwise.
+	Seed int64 `json:"-"`
+}
+
+// Rand returns a new random generator seeded with the submission's seed. Tests
+// generating their inputs with it get the same inputs for the same seed.
+func (s *Submission) Rand() *rand.Rand {
+	return rand.New(rand.NewSource(s.Seed))
+}
+
+// The languages of the source files by extension, used to detect the language of
+// the submissions that don't specify it. The detected languages without an executor
+// (e.g. python) are rejected as unsupported.
+var languageExtensions = map[string]string{
+	".go": "go",
+	".py": "python",
+}
+
+// detectLanguage infers the language of a submission from the extensions of the
+// files of its archive. It fails if the files belong to none or several of the
+// known languages.
+func detectLanguage(submission json.RawMessage) (string, error) {
+	var archive struct {
+		PackageArchive []byte `json:"packageArchive"`
+	}
+	if err := json.Unmarshal(submission, &archive); err != nil {
+		return "", fmt.Errorf("failed to unmarshal the archive: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(archive.PackageArchive), int64(len(archive.PackageArchive)))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the archive: %v", err)
+	}
+
+	found := make(map[string]bool)
+	for _, f := range zr.File {
+		if lang, ok := languageExtensions[strings.ToLower(filepath.Ext(f.Name))]; ok {
+			found[lang] = true
+		}
+	}
+	var langs []string
+	for l := range found {
+		langs = append(langs, l)
+	}
+	sort.Strings(langs)
+	switch len(langs) {
+	case 0:
+		return "", fmt.Errorf("no files of a known language")
+	case 1:
+		return langs[0], nil
+	default:
+		return "", fmt.Errorf("ambiguous language, files of %v found", strings.Join(langs, ", "))
+	}
+}
+
+// countSourceFiles returns the number of files of the archive, without reading them.
+func countSourceFiles(archive []byte) (int, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the archive: %v", err)
+	}
+	var files int
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			files++
+		}
+	}
+	return files, nil
+}
+
+// countSourceLines returns the number of lines of all the files of the archive.
+func countSourceLines(archive []byte) (int, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the archive: %v", err)
+	}
+	var lines int
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return 0, fmt.Errorf("failed to open %v: %v", f.Name, err)
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read %v: %v", f.Name, err)
+		}
+		lines += bytes.Count(content, []byte("\n"))
+		// The last line isn't always terminated.
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			lines++
+		}
+	}
+	return lines, nil
 }
 
 // UnmarshalJSON is a custom JSON unmarshaller. It's used mainly to create
@@ -25,6 +199,7 @@ func (s *Submission) UnmarshalJSON(d []byte) error {
 		Language   string          `json:"language"`
 		TaskName   string          `json:"taskName"`
 		Username   string          `json:"username"`
+		Tags       []string        `json:"tags"`
 		Submission json.RawMessage `json:"submission"`
 	}{}
 
@@ -37,6 +212,21 @@ func (s *Submission) UnmarshalJSON(d []byte) error {
 	s.Language = metadata.Language
 	s.TaskName = metadata.TaskName
 	s.Username = metadata.Username
+	s.Tags = metadata.Tags
+
+	if s.Language == "" {
+		lang, err := detectLanguage(metadata.Submission)
+		if err != nil {
+			return fmt.Errorf("failed to detect the language of the submission: %v", err)
+		}
+		s.Language = lang
+	}
+
+	for _, t := range s.Tags {
+		if t == "" || strings.Contains(t, ",") {
+			return fmt.Errorf("invalid tag %q: tags must be non empty and can't contain commas", t)
+		}
+	}
 
 	switch s.Language {
 	case "go":
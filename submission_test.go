@@ -0,0 +1,380 @@
+package godge
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestConcurrentSubmissionsGetSequentialIDs(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.SequentialSubmissionIDs = true
+	s.workers.set(4, s.processSubmissions)
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	const n = 20
+	var mu sync.Mutex
+	var ids []int
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var resp SubmissionResponse
+			if code := s.do(t, s.submitHTTPHandler, http.MethodPost, "/submit", submission(t, "echo"), nil, &resp); code != http.StatusOK {
+				t.Errorf("Submit returned %v, want %v", code, http.StatusOK)
+				return
+			}
+			id, err := strconv.Atoi(resp.ID)
+			if err != nil {
+				t.Errorf("Got non sequential ID %q: %v", resp.ID, err)
+				return
+			}
+			mu.Lock()
+			ids = append(ids, id)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.Ints(ids)
+	for i, id := range ids {
+		if id != i+1 {
+			t.Fatalf("Got IDs %v, want 1 to %v", ids, n)
+		}
+	}
+	if len(ids) != n {
+		t.Errorf("Got %v IDs, want %v", len(ids), n)
+	}
+	for _, sub := range s.submissions(t, n, "") {
+		if id, err := strconv.Atoi(sub.ID); err != nil || id < 1 || id > n {
+			t.Errorf("Got stored submission ID %q, want 1 to %v", sub.ID, n)
+		}
+	}
+}
+
+func TestReadSubmissionReadsBodyOnce(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	body := submission(t, "echo")
+
+	// The request body can only be consumed once, one byte at a time.
+	req := httptest.NewRequest(http.MethodPost, "/submit", iotest.OneByteReader(bytes.NewReader(body)))
+	w := httptest.NewRecorder()
+	sub, ok := s.readSubmission(w, req)
+	if !ok {
+		t.Fatalf("Reading the submission failed with %v: %v", w.Code, w.Body.String())
+	}
+	hash := sha256.Sum256(body)
+	if want := hex.EncodeToString(hash[:]); sub.bodyHash != want {
+		t.Errorf("Got the body hash %v, want %v", sub.bodyHash, want)
+	}
+	if sub.TaskName != "echo" || sub.Language != "go" || sub.Executor == nil {
+		t.Errorf("Got the submission %+v, want the decoded echo go submission", sub)
+	}
+
+	s.MaxSubmissionBytes = int64(len(body) - 1)
+	req = httptest.NewRequest(http.MethodPost, "/submit", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	if _, ok := s.readSubmission(w, req); ok || w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Reading an over limit submission returned %v, want %v", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestGroupQuotasLimitInFlightSubmissions(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	s.addUser(t, "bob")
+	s.addUser(t, "carol")
+	s.UserGroups = map[string]string{testUsername: "a", "bob": "a", "carol": "b"}
+	s.GroupQuotas = map[string]int{"a": 1, "b": 1}
+	gate := make(chan struct{})
+	blocked := Test{Name: "blocked", Func: func(*Submission) error {
+		<-gate
+		return nil
+	}}
+	if err := s.RegisterTask(Task{Name: "slow", Tests: []Test{blocked}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	for _, c := range []struct {
+		username string
+		want     int
+	}{
+		{testUsername, http.StatusAccepted},
+		{"bob", http.StatusTooManyRequests},
+		{"carol", http.StatusAccepted},
+	} {
+		if code := s.doAs(t, c.username, s.submitHTTPHandler, http.MethodPost, "/submit?async=true", submission(t, "slow"), nil, nil); code != c.want {
+			t.Errorf("Submit of %v returned %v, want %v", c.username, code, c.want)
+		}
+	}
+	close(gate)
+	waitFor(t, "the quotas to be released", func() bool {
+		s.groupSubmissions.Lock()
+		defer s.groupSubmissions.Unlock()
+		return len(s.groupSubmissions.m) == 0
+	})
+	if code, resp := s.submit(t, "bob", submission(t, "slow")); code != http.StatusOK || !resp.Passed {
+		t.Errorf("Submit of bob once the quota is released returned %v %+v, want a passed submission", code, resp)
+	}
+}
+
+// archive returns a zip archive of the files with the given names.
+func archive(t *testing.T, names ...string) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, name := range names {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create zip entry: %v", err)
+		}
+		if _, err := f.Write([]byte("package main\n\nfunc main() {}\n")); err != nil {
+			t.Fatalf("Failed to write zip entry: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// rawArchive returns the language specific JSON of a submission of the archive.
+func rawArchive(t *testing.T, archive []byte) json.RawMessage {
+	raw, err := json.Marshal(map[string]interface{}{"packageArchive": archive})
+	if err != nil {
+		t.Fatalf("Failed to marshal archive: %v", err)
+	}
+	return raw
+}
+
+func TestLanguageDetectedFromExtensions(t *testing.T) {
+	for _, c := range []struct {
+		files []string
+		want  string
+		err   string
+	}{
+		{[]string{"main.go", "util.go", "README"}, "go", ""},
+		{[]string{"solution.py"}, "python", ""},
+		{[]string{"main.go", "solution.py"}, "", "ambiguous language, files of go, python found"},
+		{[]string{"notes.txt"}, "", "no files of a known language"},
+	} {
+		got, err := detectLanguage(rawArchive(t, archive(t, c.files...)))
+		if got != c.want || (err == nil) != (c.err == "") || err != nil && err.Error() != c.err {
+			t.Errorf("Got the language %q (%v) of %v, want %q (%v)", got, err, c.files, c.want, c.err)
+		}
+	}
+
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	for _, c := range []struct {
+		files []string
+		want  int
+	}{
+		{[]string{"main.go"}, http.StatusOK},
+		{[]string{"main.go", "solution.py"}, http.StatusBadRequest},
+		{[]string{"notes.txt"}, http.StatusBadRequest},
+	} {
+		body, err := json.Marshal(map[string]interface{}{
+			"taskName":   "echo",
+			"submission": rawArchive(t, archive(t, c.files...)),
+		})
+		if err != nil {
+			t.Fatalf("Failed to marshal submission: %v", err)
+		}
+		if code, _ := s.submit(t, testUsername, body); code != c.want {
+			t.Errorf("Submit of %v without a language returned %v, want %v", c.files, code, c.want)
+		}
+	}
+}
+
+func TestDiffOfFailedSampleCase(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "1\n5\n3"})
+	defer s.close()
+	s.addUser(t, "bob")
+	task := Task{Name: "count", Cases: []Case{
+		{Name: "sample", Accepted: []string{"1\n2\n3"}, Sample: true},
+		{Name: "hidden", Accepted: []string{"1\n2\n3"}},
+	}}
+	if err := s.RegisterTask(task); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	code, resp := s.submit(t, testUsername, submission(t, "count"))
+	if code != http.StatusOK || resp.Passed {
+		t.Fatalf("Submit returned %v %+v, want a failed submission", code, resp)
+	}
+	s.submissions(t, 1, "")
+
+	var diffs []CaseDiff
+	if code := s.do(t, s.submissionHTTPHandler, http.MethodGet, "/submissions/"+resp.ID+"/diff", nil, nil, &diffs); code != http.StatusOK {
+		t.Fatalf("Diff returned %v, want %v", code, http.StatusOK)
+	}
+	want := []CaseDiff{{Case: "sample", Diff: "--- want\n+++ got\n@@ -1,3 +1,3 @@\n 1\n-2\n+5\n 3\n"}}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Errorf("Got the diffs %+v, want %+v", diffs, want)
+	}
+
+	// The diffs of the submissions of the other users aren't exposed.
+	if code := s.doAs(t, "bob", s.submissionHTTPHandler, http.MethodGet, "/submissions/"+resp.ID+"/diff", nil, nil, nil); code != http.StatusNotFound {
+		t.Errorf("Diff of another user's submission returned %v, want %v", code, http.StatusNotFound)
+	}
+}
+
+func TestSeedReproducesInputs(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.addUser(t, "bob")
+	var mu sync.Mutex
+	inputs := make(map[int64][][]int)
+	generate := func(r *rand.Rand) []int {
+		var ret []int
+		for i := 0; i < 5; i++ {
+			ret = append(ret, r.Intn(1000))
+		}
+		return ret
+	}
+	test := Test{Name: "random", Func: func(sub *Submission) error {
+		mu.Lock()
+		defer mu.Unlock()
+		inputs[sub.Seed] = append(inputs[sub.Seed], generate(sub.Rand()))
+		return nil
+	}}
+	if err := s.RegisterTask(Task{Name: "random", Tests: []Test{test}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	submit := func(username string) int64 {
+		code, resp := s.submit(t, username, submission(t, "random"))
+		if code != http.StatusOK || !resp.Passed || resp.Seed == 0 {
+			t.Fatalf("Submit returned %v %+v, want a passed submission with its seed", code, resp)
+		}
+		return resp.Seed
+	}
+
+	// Each submission gets its own seed, which reproduces its inputs.
+	seeds := []int64{submit(testUsername), submit("bob")}
+	if seeds[0] == seeds[1] {
+		t.Errorf("Got the same seed %v for both submissions, want one per submission", seeds[0])
+	}
+	for _, seed := range seeds {
+		want := generate(rand.New(rand.NewSource(seed)))
+		if got := inputs[seed]; len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+			t.Errorf("Got the inputs %v with the seed %v, want %v", got, seed, want)
+		}
+	}
+
+	// With the server's seed, all the submissions get the same inputs.
+	s.Seed = 42
+	for _, username := range []string{testUsername, "bob"} {
+		if seed := submit(username); seed != 42 {
+			t.Errorf("Got the seed %v, want the server's seed 42", seed)
+		}
+	}
+	if got, want := inputs[42], generate(rand.New(rand.NewSource(42))); len(got) != 2 || !reflect.DeepEqual(got[0], want) || !reflect.DeepEqual(got[1], want) {
+		t.Errorf("Got the inputs %v with the server's seed, want %v for both submissions", got, want)
+	}
+}
+
+func TestMaxFilesPerSubmission(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.MaxFilesPerSubmission = 2
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	for _, c := range []struct {
+		files []string
+		want  int
+	}{
+		{[]string{"main.go", "util.go"}, http.StatusOK},
+		{[]string{"main.go", "util.go", "more.go"}, http.StatusBadRequest},
+	} {
+		body, err := json.Marshal(map[string]interface{}{
+			"language":   "go",
+			"taskName":   "echo",
+			"submission": rawArchive(t, archive(t, c.files...)),
+		})
+		if err != nil {
+			t.Fatalf("Failed to marshal submission: %v", err)
+		}
+		w := serve(s.submitHTTPHandler, testUsername, http.MethodPost, "/submit", body, nil)
+		if w.Code != c.want {
+			t.Errorf("Submit of %v files returned %v, want %v", len(c.files), w.Code, c.want)
+		}
+		if w.Code == http.StatusBadRequest {
+			if got, want := errorOf(t, w), "Submission has 3 files, at most 2 are allowed"; got != want {
+				t.Errorf("Submit of too many files failed with %q, want %q", got, want)
+			}
+		}
+	}
+	if subs := s.submissions(t, 1, ""); len(subs) != 1 {
+		t.Errorf("Got %v submissions, want only the one within the files limit", len(subs))
+	}
+}
+
+func TestIdenticalResubmissionsReuseVerdict(t *testing.T) {
+	dc := &fakeDocker{stdout: "hello"}
+	s := newTestServer(t, dc)
+	defer s.close()
+	s.addUser(t, "bob")
+	s.VerdictCacheWindow = time.Minute
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	code, first := s.submit(t, testUsername, submission(t, "echo"))
+	if code != http.StatusOK || !first.Passed || first.Cached {
+		t.Fatalf("Submit returned %v %+v, want a judged passed submission", code, first)
+	}
+	containers := dc.createdContainers()
+	changed, err := json.Marshal(map[string]interface{}{
+		"language":   "go",
+		"taskName":   "echo",
+		"submission": rawArchive(t, archive(t, "main.go", "util.go")),
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal submission: %v", err)
+	}
+
+	code, again := s.submit(t, testUsername, submission(t, "echo"))
+	if code != http.StatusOK || !again.Cached || again.ID != first.ID || !again.Passed {
+		t.Errorf("Identical resubmission returned %v %+v, want the cached verdict of %v", code, again, first.ID)
+	}
+	if got := dc.createdContainers(); got != containers {
+		t.Errorf("Got %v containers after the identical resubmission, want %v", got, containers)
+	}
+
+	for _, c := range []struct {
+		name     string
+		username string
+		body     []byte
+	}{
+		{"changed source", testUsername, changed},
+		{"other user", "bob", submission(t, "echo")},
+	} {
+		code, resp := s.submit(t, c.username, c.body)
+		if code != http.StatusOK || resp.Cached || !resp.Passed {
+			t.Errorf("Submit of the %v returned %v %+v, want a judged passed submission", c.name, code, resp)
+		}
+		if got := dc.createdContainers(); got != containers+1 {
+			t.Errorf("Got %v containers after the submission of the %v, want %v", got, c.name, containers+1)
+		}
+		containers = dc.createdContainers()
+	}
+}
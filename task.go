@@ -1,6 +1,16 @@
 package godge
 
-import "fmt"
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
 
 // Test defines on of the tests of a certain task.
 type Test struct {
@@ -10,27 +20,433 @@ type Test struct {
 	// The actuall test. It takes a submission as an input (along with its excutor)
 	// and should return a descriptive error when the submission don't pass the test.
 	Func func(*Submission) error
+	// Whether the test is a public sample. Sample tests can be run by the users
+	// through the run endpoint without affecting the scoreboard.
+	Sample bool
+}
+
+// run runs the test against the submission. Panics are recovered and returned as
+// internal errors so that a broken test doesn't crash the judge.
+func (t *Test) run(s *Submission) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Test %v panicked: %v\n%s", t.Name, r, debug.Stack())
+			err = &internalError{recovered: r}
+		}
+	}()
+	return t.Func(s)
+}
+
+// Case is an output comparison test. The submission is executed with the case's
+// arguments and passes if its stdout exactly matches any of the accepted outputs.
+type Case struct {
+	// The name of the case, which will be returned to the user when the case fails.
+	Name string
+	// The arguments the submission is executed with.
+	Args []string
+	// The acceptable outputs of the submission.
+	Accepted []string
+	// A regular expression the whole output of the submission can match instead of
+	// being one of the accepted outputs (e.g. [0-9a-f]{64} for a sha256 hash).
+	// Defaults to the task's OutputPattern if the case has no accepted outputs.
+	Pattern string
+	// Whether the case is a public sample, see Test.Sample.
+	Sample bool
+}
+
+// presentationError is returned by the cases whose output only differs from an
+// accepted one in whitespace.
+type presentationError struct {
+	got string
+}
+
+func (e *presentationError) Error() string {
+	return fmt.Sprintf("output differs from the accepted one in whitespace only, got: %q", e.got)
+}
+
+// normalizeSpaces collapses the whitespace of each of the outputs into single spaces.
+func normalizeSpaces(outputs []string) []string {
+	var ret []string
+	for _, o := range outputs {
+		ret = append(ret, strings.Join(strings.Fields(o), " "))
+	}
+	return ret
+}
+
+// matchOutput compiles the pattern so that it matches whole outputs only.
+func matchOutput(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// test converts the case into a test running the submission and comparing its output.
+// The case's pattern must be valid.
+func (c Case) test() Test {
+	return Test{
+		Name:   c.Name,
+		Sample: c.Sample,
+		Func: func(sub *Submission) error {
+			if err := sub.Executor.Execute(c.Args); err != nil {
+				return err
+			}
+			defer sub.Executor.Stop()
+			<-sub.Executor.DieEvent()
+			got, err := sub.Executor.Stdout()
+			if err != nil {
+				return err
+			}
+			if containsString(c.Accepted, got) {
+				return nil
+			}
+			if c.Pattern != "" {
+				re, err := matchOutput(c.Pattern)
+				if err != nil {
+					return err
+				}
+				if re.MatchString(got) {
+					return nil
+				}
+				if len(c.Accepted) == 0 {
+					return fmt.Errorf("want output matching: %v, got: %v", c.Pattern, got)
+				}
+			}
+			if len(c.Accepted) > 0 && containsString(normalizeSpaces(c.Accepted), strings.Join(strings.Fields(got), " ")) {
+				return &presentationError{got: got}
+			}
+			// Only the diffs of the public samples are exposed to the users.
+			if c.Sample {
+				sub.diffs = append(sub.diffs, CaseDiff{Case: c.Name, Diff: unifiedDiff(c.Accepted[0], got)})
+			}
+			if len(c.Accepted) == 1 {
+				return fmt.Errorf("want: %v, got: %v", c.Accepted[0], got)
+			}
+			return fmt.Errorf("want any of: %v, got: %v", strings.Join(c.Accepted, " | "), got)
+		},
+	}
+}
+
+// Limits are the resource limits of the containers running the submissions of a
+// task. Zero values mean no limit.
+type Limits struct {
+	// The maximum wall clock time of a single execution. The container is killed and
+	// the execution fails with "time limit exceeded" once it elapses.
+	Timeout time.Duration
+	// The maximum memory of the container in bytes.
+	Memory int64
+	// The relative CPU weight of the container.
+	CPUShares int64
+	// The maximum number of processes in the container, containing fork bombs.
+	// Defaults to the server's PidsLimit.
+	Pids int64
+}
+
+// Subtask is a group of tests worth some points. Subtasks are scored independently,
+// the submission gets the points of a subtask only if it passes all of its tests.
+type Subtask struct {
+	// The name of the subtask, which will be returned to the user along with its score.
+	Name string
+	// The points the subtask is worth.
+	Points int
+	// The tests that a submission needs to pass in order to get the subtask's points.
+	Tests []Test
+}
+
+// SubtaskResult is the score of a submission in a single subtask. It's exposed to
+// be used by the command line client.
+type SubtaskResult struct {
+	Name   string `json:"name" xml:"name"`
+	Passed bool   `json:"passed" xml:"passed"`
+	// The points earned by the subtask. Zero if the submission failed the task's
+	// tests and cases, even if the subtask passed.
+	Points    int `json:"points" xml:"points"`
+	MaxPoints int `json:"maxPoints" xml:"maxPoints"`
+}
+
+// Result is the outcome of the execution of a submission. It's exposed to be used
+// by the command line client.
+type Result struct {
+	// The number of tests, including the cases and the subtasks' tests, the
+	// submission passed out of the executed ones.
+	Passed int `json:"passed" xml:"passed"`
+	Total  int `json:"total" xml:"total"`
+	// The fraction of the task's points earned by the submission.
+	Score float64 `json:"score" xml:"score"`
+}
+
+// TestResult is the detailed result of running a single sample test. It's exposed
+// to be used by the command line client.
+type TestResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error"`
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+}
+
+// TaskState is the state of a task. Submissions are only accepted for open tasks.
+type TaskState string
+
+const (
+	// TaskDraft tasks are hidden and don't accept submissions.
+	TaskDraft TaskState = "draft"
+	// TaskOpen tasks accept submissions. Tasks without a state are open.
+	TaskOpen TaskState = "open"
+	// TaskClosed tasks are visible but don't accept submissions anymore.
+	TaskClosed TaskState = "closed"
+)
+
+// The allowed transitions between the task states.
+var taskStateTransitions = map[TaskState][]TaskState{
+	TaskDraft:  {TaskOpen},
+	TaskOpen:   {TaskClosed},
+	TaskClosed: {TaskOpen},
 }
 
 // Task defines a group of related tests. The user needs to pass all the tests to pass
-// the task and get its point on the scoreboard.
+// the task and get its point on the scoreboard, or its points if it has subtasks.
 type Task struct {
 	// The name of the task that the user will use to submit their submission.
 	Name string `json:"name"`
 	// A description of what's required in order to pass the task.
 	Desc string `json:"desc"`
+	// The position of the task's column in the scoreboard when the server orders
+	// the tasks ByOrder (e.g. from the easiest to the hardest).
+	Order int `json:"order,omitempty"`
 	// A group of tests that a submission needs to pass in order to pass the task.
 	Tests []Test `json:"-"`
+	// Extra docker host config (e.g. CapAdd or Devices) applied to the containers
+	// running the submissions of this task. Only the fields allowed by the server's
+	// AllowedHostConfigFields can be set.
+	HostConfig *docker.HostConfig `json:"-"`
+	// The image used to run the submissions of this task, overriding the server's
+	// LanguageImages. The image can be pinned by digest (e.g. golang@sha256:...),
+	// in which case the image of the container is verified before running.
+	Image string `json:"-"`
+	// The state of the task. Defaults to TaskOpen.
+	State TaskState `json:"state"`
+	// An optional command (e.g. seeding a database or generating input files) run in
+	// a separate container sharing the submission's workspace before every execution.
+	// If it fails, the submission is marked as an environment error.
+	Setup []string `json:"-"`
+	// Output comparison cases run after the tests. Each case is handled as a test.
+	Cases []Case `json:"-"`
+	// Groups of tests worth points, run after the tests and cases. A submission passing
+	// the tests and cases gets partial points for the subtasks it passes, and passes
+	// the task only if it passes all of them.
+	Subtasks []Subtask `json:"-"`
+	// The time window in which the task accepts submissions, overriding the server's
+	// StartAt and EndAt. Zero values fall back to the server's window.
+	OpenAt  time.Time `json:"-"`
+	CloseAt time.Time `json:"-"`
+	// The names of the tasks a user must have solved before submitting to this task.
+	// The solves are looked up in the database, so the prerequisites can be tasks of
+	// a previous contest that used the same database and are not registered anymore.
+	Prerequisites []string `json:"prerequisites,omitempty"`
+	// The resource limits of the containers running the submissions of this task.
+	Limits Limits `json:"-"`
+	// If true and the task has no subtasks, the task is worth a point per test and
+	// the submissions get the points of the tests they pass instead of all or nothing.
+	PartialCredit bool `json:"partialCredit,omitempty"`
+	// The points the task is worth, overriding the points derived from its subtasks
+	// or tests. The submissions keep the same fraction of the points if it's changed
+	// through /admin/tasks/<name>/points.
+	Points int `json:"points,omitempty"`
+	// The pattern the outputs of the cases without accepted outputs must match, see
+	// Case.Pattern.
+	OutputPattern string `json:"-"`
+	// The maximum number of lines of all the files of a submission together.
+	// Longer submissions are rejected before being judged. Unlimited if zero.
+	MaxSourceLines int `json:"maxSourceLines,omitempty"`
+	// The webhooks notified of the solves of this task, in addition to the server's
+	// WebhookURL. They receive the same SolveEvent.
+	WebhookURLs []string `json:"-"`
+	// The languages the submissions of this task can be written in. All the languages
+	// of the server's LanguageImages are accepted if empty.
+	AllowedLanguages []string `json:"allowedLanguages,omitempty"`
+	// Receive the outcomes of the submissions of this task, in addition to the
+	// server's ResultSinks.
+	ResultSinks []ResultSink `json:"-"`
+}
+
+// languages returns the sorted languages accepted by the task out of the given
+// languages of the server.
+func (t *Task) languages(images map[string]string) []string {
+	var ret []string
+	if len(t.AllowedLanguages) > 0 {
+		ret = append(ret, t.AllowedLanguages...)
+	} else {
+		for l := range images {
+			ret = append(ret, l)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// acceptsLanguage returns true if the task accepts submissions in the language.
+func (t *Task) acceptsLanguage(language string) bool {
+	if len(t.AllowedLanguages) == 0 {
+		return true
+	}
+	for _, l := range t.AllowedLanguages {
+		if l == language {
+			return true
+		}
+	}
+	return false
+}
+
+// maxPoints returns the points the task is worth. Unless set by Points, tasks without
+// subtasks are worth a point, or a point per test if they give partial credit.
+func (t *Task) maxPoints() int {
+	if t.Points > 0 {
+		return t.Points
+	}
+	return t.derivedPoints()
+}
+
+// derivedPoints returns the points the task is worth according to its subtasks or tests.
+func (t *Task) derivedPoints() int {
+	if len(t.Subtasks) == 0 && t.PartialCredit {
+		return len(t.tests())
+	}
+	if len(t.Subtasks) == 0 {
+		return 1
+	}
+	var ret int
+	for _, st := range t.Subtasks {
+		ret += st.Points
+	}
+	return ret
+}
+
+// tests returns the tests of the task followed by its cases.
+func (t *Task) tests() []Test {
+	ret := append([]Test{}, t.Tests...)
+	for _, c := range t.Cases {
+		if len(c.Accepted) == 0 && c.Pattern == "" {
+			c.Pattern = t.OutputPattern
+		}
+		ret = append(ret, c.test())
+	}
+	return ret
+}
+
+func (t *Task) state() TaskState {
+	if t.State == "" {
+		return TaskOpen
+	}
+	return t.State
+}
+
+// window returns the time window in which the task accepts submissions given the
+// window of the contest. Zero times mean that the window is unbounded.
+func (t *Task) window(startAt, endAt time.Time) (time.Time, time.Time) {
+	if !t.OpenAt.IsZero() {
+		startAt = t.OpenAt
+	}
+	if !t.CloseAt.IsZero() {
+		endAt = t.CloseAt
+	}
+	return startAt, endAt
+}
+
+// transition moves the task to the given state if the transition is allowed.
+func (t *Task) transition(to TaskState) error {
+	for _, s := range taskStateTransitions[t.state()] {
+		if s == to {
+			t.State = to
+			return nil
+		}
+	}
+	return fmt.Errorf("can't move task %v from %v to %v", t.Name, t.state(), to)
 }
 
-// Execute runs the submission against all the tests. The error returned is the error
-// retured by all the tests.
+// Execute runs the submission against all the tests and subtasks. The error returned is
+// the error retured by all the tests. If any of the tests fails because of the judge (e.g. an
+// InfrastructureError or a panic), the execution is aborted and this error is returned as is.
+// The score of the submission and its subtask results are recorded in the submission.
 func (t *Task) execute(s *Submission) error {
+	s.result = Result{}
+	s.subtasks = nil
+	s.diffs = nil
+	s.category = ""
+	tests := t.tests()
+	errs, err := runTests(tests, s)
+	if err != nil {
+		return err
+	}
+	passedTests := len(errs) == 0
+	s.result.Total = len(tests)
+	s.result.Passed = len(tests) - len(errs)
+
+	var points int
+	for _, st := range t.Subtasks {
+		stErrs, err := runTests(st.Tests, s)
+		if err != nil {
+			return err
+		}
+		s.result.Total += len(st.Tests)
+		s.result.Passed += len(st.Tests) - len(stErrs)
+		r := SubtaskResult{Name: st.Name, Passed: len(stErrs) == 0, MaxPoints: st.Points}
+		// The subtasks' points are only granted if the tests and cases pass.
+		if r.Passed && passedTests {
+			r.Points = st.Points
+		}
+		for _, e := range stErrs {
+			errs = append(errs, fmt.Errorf("subtask '%v': %v", st.Name, e))
+		}
+		points += r.Points
+		s.subtasks = append(s.subtasks, r)
+	}
+
+	switch {
+	case len(errs) == 0:
+		s.result.Score = 1
+	case len(t.Subtasks) == 0 && t.PartialCredit && s.result.Total > 0:
+		s.result.Score = float64(s.result.Passed) / float64(s.result.Total)
+	case passedTests && t.derivedPoints() > 0:
+		s.result.Score = float64(points) / float64(t.derivedPoints())
+	}
+	return errs.ErrorOrNil()
+}
+
+// runTests runs the tests against the submission and returns the errors of the failed
+// ones. If any of the tests fails because of the judge, it's returned as the second error.
+func runTests(tests []Test, s *Submission) (Errors, error) {
 	var errs Errors
-	for _, test := range t.Tests {
-		if err := test.Func(s); err != nil {
+	for _, test := range tests {
+		if err := test.run(s); err != nil {
+			if isJudgeError(err) {
+				return nil, err
+			}
+			// The submission's failure is categorized by its first failed test.
+			if s.category == "" {
+				s.category = failureCategory(err, s.Executor.exitCode())
+			}
 			errs = append(errs, fmt.Errorf("test '%v' failed: %v", test.Name, err))
 		}
 	}
-	return errs.ErrorOrNil()
+	return errs, nil
+}
+
+// runSamples runs the submission against the sample tests only and returns the
+// detailed result of each of them.
+func (t *Task) runSamples(s *Submission) []TestResult {
+	ret := []TestResult{}
+	for _, test := range t.tests() {
+		if !test.Sample {
+			continue
+		}
+		r := TestResult{Name: test.Name, Passed: true}
+		if err := test.run(s); err != nil {
+			r.Passed = false
+			r.Error = err.Error()
+		}
+		if s.Executor.containerID() != "" {
+			r.Stdout, _ = s.Executor.Stdout()
+			r.Stderr, _ = s.Executor.Stderr()
+		}
+		ret = append(ret, r)
+	}
+	return ret
 }
@@ -0,0 +1,242 @@
+package godge
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// judgeOutput judges a submission to the task printing stdout.
+func judgeOutput(t *testing.T, task Task, stdout string) SubmissionResponse {
+	s := newTestServer(t, &fakeDocker{stdout: stdout})
+	defer s.close()
+	if err := s.RegisterTask(task); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	code, resp := s.submit(t, testUsername, submission(t, task.Name))
+	if code != http.StatusOK {
+		t.Fatalf("Submit returned %v, want %v", code, http.StatusOK)
+	}
+	return resp
+}
+
+func TestRegisterTaskLimitsTests(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	s.MaxTestsPerTask = 3
+	task := Task{
+		Name:     "big",
+		Tests:    []Test{outputTest("a", "a")},
+		Cases:    []Case{{Name: "b", Accepted: []string{"b"}}},
+		Subtasks: []Subtask{{Name: "c", Points: 1, Tests: []Test{outputTest("c", "c")}}},
+	}
+	if err := s.RegisterTask(task); err != nil {
+		t.Errorf("Registering a task with %v tests returned %v, want it registered", s.MaxTestsPerTask, err)
+	}
+
+	task.Name = "bigger"
+	task.Cases = append(task.Cases, Case{Name: "d", Accepted: []string{"d"}})
+	err := s.RegisterTask(task)
+	if err == nil || !strings.Contains(err.Error(), "it has 4 tests, at most 3 are allowed") {
+		t.Errorf("Registering a task with 4 tests returned %v, want the tests limit error", err)
+	}
+	if _, ok := s.tasks.get("bigger"); ok {
+		t.Errorf("The task over the tests limit was registered")
+	}
+}
+
+func TestCaseAcceptsAnyAcceptedOutput(t *testing.T) {
+	task := Task{Name: "answer", Cases: []Case{{Name: "answer", Accepted: []string{"yes", "YES"}}}}
+	for _, out := range []string{"yes", "YES"} {
+		if resp := judgeOutput(t, task, out); !resp.Passed {
+			t.Errorf("Got %+v for the output %q, want a passed submission", resp, out)
+		}
+	}
+	if resp := judgeOutput(t, task, "no"); resp.Passed || !strings.Contains(resp.Error, "want any of: yes | YES, got: no") {
+		t.Errorf("Got %+v for the output %q, want a failed submission", resp, "no")
+	}
+}
+
+func TestCasePatternMatchesWholeOutput(t *testing.T) {
+	hash := Task{Name: "hash", Cases: []Case{{Name: "hash", Pattern: "[0-9a-f]{8}"}}}
+	if resp := judgeOutput(t, hash, "deadbeef"); !resp.Passed {
+		t.Errorf("Got %+v for an output matching the pattern, want a passed submission", resp)
+	}
+	// The pattern must match the whole output.
+	for _, out := range []string{"deadbeef0", "xdeadbeef", "DEADBEEF"} {
+		if resp := judgeOutput(t, hash, out); resp.Passed || !strings.Contains(resp.Error, "want output matching: [0-9a-f]{8}, got: "+out) {
+			t.Errorf("Got %+v for the output %q, want a failed submission", resp, out)
+		}
+	}
+
+	// The pattern is an alternative to the accepted outputs, and the task's
+	// OutputPattern is used by the cases without either.
+	number := Task{Name: "number", Cases: []Case{{Name: "number", Accepted: []string{"none"}, Pattern: "[0-9]+"}}}
+	for _, out := range []string{"none", "42"} {
+		if resp := judgeOutput(t, number, out); !resp.Passed {
+			t.Errorf("Got %+v for the output %q, want a passed submission", resp, out)
+		}
+	}
+	if resp := judgeOutput(t, number, "many"); resp.Passed || !strings.Contains(resp.Error, "want: none, got: many") {
+		t.Errorf("Got %+v for an output matching neither, want a failed submission", resp)
+	}
+	task := Task{Name: "task", OutputPattern: "[0-9]+", Cases: []Case{{Name: "task"}}}
+	if resp := judgeOutput(t, task, "42"); !resp.Passed {
+		t.Errorf("Got %+v for an output matching the task's pattern, want a passed submission", resp)
+	}
+	if resp := judgeOutput(t, task, "many"); resp.Passed || !strings.Contains(resp.Error, "want output matching: [0-9]+, got: many") {
+		t.Errorf("Got %+v for an output not matching the task's pattern, want a failed submission", resp)
+	}
+
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	if err := s.RegisterTask(Task{Name: "broken", Cases: []Case{{Name: "broken", Pattern: "[0-9"}}}); err == nil {
+		t.Errorf("Registering a task with an invalid pattern succeeded, want an error")
+	}
+}
+
+func TestSubtasksGrantPartialPoints(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	task := Task{
+		Name:  "subtasks",
+		Tests: []Test{outputTest("hello", "hello")},
+		Subtasks: []Subtask{
+			{Name: "easy", Points: 3, Tests: []Test{outputTest("hello", "hello")}},
+			{Name: "hard", Points: 7, Tests: []Test{outputTest("bye", "bye"), outputTest("hello", "hello")}},
+		},
+	}
+	if err := s.RegisterTask(task); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	code, resp := s.submit(t, testUsername, submission(t, "subtasks"))
+	if code != http.StatusOK || resp.Passed {
+		t.Fatalf("Submit returned %v %+v, want a failed submission", code, resp)
+	}
+	want := []SubtaskResult{{Name: "easy", Passed: true, Points: 3, MaxPoints: 3}, {Name: "hard", MaxPoints: 7}}
+	if !reflect.DeepEqual(resp.Subtasks, want) {
+		t.Errorf("Got the subtasks %+v, want %+v", resp.Subtasks, want)
+	}
+	if want := (Result{Passed: 3, Total: 4, Score: 0.3}); resp.Result != want {
+		t.Errorf("Got the result %+v, want %+v", resp.Result, want)
+	}
+	s.waitForAttempts(t, testUsername, 1)
+	if cell := s.scoreboardOf(t, "").Rows[0].Cells[0]; cell.Points != 3 || cell.MaxPoints != 10 {
+		t.Errorf("Got %v/%v points on the scoreboard, want 3/10", cell.Points, cell.MaxPoints)
+	}
+}
+
+func TestBestScoreKept(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	// The submissions pass as many of the tests as the number they are tagged with.
+	var tests []Test
+	for i := 0; i < 10; i++ {
+		i := i
+		tests = append(tests, Test{Name: fmt.Sprintf("test%v", i), Func: func(sub *Submission) error {
+			if passing, _ := strconv.Atoi(sub.Tags[0]); i >= passing {
+				return fmt.Errorf("failed as tagged")
+			}
+			return nil
+		}})
+	}
+	if err := s.RegisterTask(Task{Name: "partial", PartialCredit: true, Tests: tests}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	for i, c := range []struct {
+		passing string
+		want    Result
+	}{
+		{"7", Result{Passed: 7, Total: 10, Score: 0.7}},
+		{"3", Result{Passed: 3, Total: 10, Score: 0.3}},
+	} {
+		code, resp := s.submit(t, testUsername, submission(t, "partial", c.passing))
+		if code != http.StatusOK || resp.Passed || resp.Result != c.want {
+			t.Errorf("Submit passing %v tests returned %v %+v, want the result %+v", c.passing, code, resp, c.want)
+		}
+		s.waitForAttempts(t, testUsername, i+1)
+	}
+
+	cell := s.scoreboardOf(t, "").Rows[0].Cells[0]
+	if cell.Points != 7 || cell.PassedTests != 7 || cell.TotalTests != 10 {
+		t.Errorf("Got the cell %+v after a worse submission, want the best 7/10 kept", cell)
+	}
+	w := serve(s.scoreboardHTTPHandler, "", http.MethodGet, "/scoreboard", nil, nil)
+	if body := w.Body.String(); !strings.Contains(body, "7/10 tests") {
+		t.Errorf("Got the scoreboard %v, want the best 7/10 tests", body)
+	}
+}
+
+func TestMaxSourceLinesRejectsLongerSubmissions(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	// The submitted main.go has 3 lines.
+	for _, task := range []Task{
+		{Name: "short", MaxSourceLines: 2, Tests: []Test{outputTest("hello", "hello")}},
+		{Name: "exact", MaxSourceLines: 3, Tests: []Test{outputTest("hello", "hello")}},
+	} {
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+
+	w := serve(s.submitHTTPHandler, testUsername, http.MethodPost, "/submit", submission(t, "short"), nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Submit over the lines limit returned %v, want %v", w.Code, http.StatusBadRequest)
+	}
+	if got, want := errorOf(t, w), "Submission has 3 lines, task short allows at most 2"; got != want {
+		t.Errorf("Submit over the lines limit failed with %q, want %q", got, want)
+	}
+	if code, resp := s.submit(t, testUsername, submission(t, "exact")); code != http.StatusOK || !resp.Passed {
+		t.Errorf("Submit at the lines limit returned %v %+v, want a passed submission", code, resp)
+	}
+	if subs := s.submissions(t, 1, ""); len(subs) != 1 || subs[0].TaskName != "exact" {
+		t.Errorf("Got submissions %+v, want only the one to exact", subs)
+	}
+}
+
+func TestTaskLanguages(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.LanguageImages = map[string]string{"go": "golang", "python": "python", "rust": "rust"}
+	for _, task := range []Task{
+		{Name: "restricted", AllowedLanguages: []string{"rust", "python"}},
+		{Name: "any"},
+		{Name: "draft", State: TaskDraft},
+	} {
+		task.Tests = []Test{outputTest("hello", "hello")}
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task %v: %v", task.Name, err)
+		}
+	}
+
+	for _, c := range []struct {
+		task string
+		want []string
+	}{
+		{"restricted", []string{"python", "rust"}},
+		{"any", []string{"go", "python", "rust"}},
+	} {
+		var resp TaskLanguagesResponse
+		if code := s.do(t, s.taskHTTPHandler, http.MethodGet, "/tasks/"+c.task+"/languages", nil, nil, &resp); code != http.StatusOK {
+			t.Errorf("Languages of %v returned %v, want %v", c.task, code, http.StatusOK)
+			continue
+		}
+		if resp.Task != c.task || !reflect.DeepEqual(resp.Languages, c.want) {
+			t.Errorf("Got the languages %+v, want %v accepting %v", resp, c.task, c.want)
+		}
+	}
+	for _, task := range []string{"draft", "missing"} {
+		if code := s.do(t, s.taskHTTPHandler, http.MethodGet, "/tasks/"+task+"/languages", nil, nil, nil); code != http.StatusNotFound {
+			t.Errorf("Languages of the %v task returned %v, want %v", task, code, http.StatusNotFound)
+		}
+	}
+	if code, _ := s.submit(t, testUsername, submission(t, "restricted")); code != http.StatusBadRequest {
+		t.Errorf("Submit of a go submission to the restricted task returned %v, want %v", code, http.StatusBadRequest)
+	}
+}
@@ -9,10 +9,26 @@ type user struct {
 	ID       int    `db:"id"`
 	Username string `db:"username"`
 	Password string `db:"password"`
+	Email    string `db:"email"`
+	// Unverified users can't submit when the server requires email verification.
+	Verified          bool   `db:"verified"`
+	VerificationToken string `db:"verification_token"`
 }
 
 func (u *user) save(db *sqlx.DB) error {
-	_, err := db.NamedExec("INSERT INTO users (username, password) VALUES (:username, :password)", u)
+	_, err := db.NamedExec("INSERT INTO users (username, password, email, verified, verification_token) VALUES (:username, :password, :email, :verified, :verification_token)", u)
+	return err
+}
+
+func (u *user) verify(db *sqlx.DB) error {
+	u.Verified = true
+	u.VerificationToken = ""
+	_, err := db.NamedExec("UPDATE users SET verified=:verified, verification_token=:verification_token WHERE id=:id", u)
+	return err
+}
+
+func (u *user) updatePassword(db *sqlx.DB) error {
+	_, err := db.NamedExec("UPDATE users SET password=:password WHERE id=:id", u)
 	return err
 }
 
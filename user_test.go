@@ -0,0 +1,179 @@
+package godge
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// register registers the user with the password through /register.
+func (s *testServer) register(t *testing.T, username, password string) *httptest.ResponseRecorder {
+	body, err := json.Marshal(RegisterRequest{Username: username, Password: password})
+	if err != nil {
+		t.Fatalf("Failed to encode the register request: %v", err)
+	}
+	return serve(s.registerHTTPHandler, "", http.MethodPost, "/register", body, nil)
+}
+
+func TestRegisterRejectsReservedUsernames(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	s.ReservedUsernames = []string{"admin"}
+
+	for _, username := range []string{"admin", "Admin"} {
+		if w := s.register(t, username, testPassword); w.Code != http.StatusBadRequest {
+			t.Errorf("Registering %v returned %v, want %v", username, w.Code, http.StatusBadRequest)
+		}
+		if _, err := userQ.find(s.db, username); err == nil {
+			t.Errorf("Reserved username %v was registered", username)
+		}
+	}
+	if w := s.register(t, "bob", testPassword); w.Code != http.StatusCreated {
+		t.Errorf("Registering bob returned %v, want %v", w.Code, http.StatusCreated)
+	}
+}
+
+func TestSubmitRequiresEmailVerification(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.RequireEmailVerification = true
+	var token string
+	s.SendVerification = func(username, email, tok string) error {
+		if username != "bob" || email != "bob@example.com" {
+			t.Errorf("Got the verification of %v at %v, want bob at bob@example.com", username, email)
+		}
+		token = tok
+		return nil
+	}
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	body, err := json.Marshal(RegisterRequest{Username: "bob", Password: testPassword, Email: "bob@example.com"})
+	if err != nil {
+		t.Fatalf("Failed to encode the register request: %v", err)
+	}
+	if w := serve(s.registerHTTPHandler, "", http.MethodPost, "/register", body, nil); w.Code != http.StatusCreated || token == "" {
+		t.Fatalf("Registering bob returned %v with the token %q, want %v and a token sent", w.Code, token, http.StatusCreated)
+	}
+
+	if code, _ := s.submit(t, "bob", submission(t, "echo")); code != http.StatusForbidden {
+		t.Errorf("Submit of an unverified user returned %v, want %v", code, http.StatusForbidden)
+	}
+	verify := func(token string) int {
+		body, err := json.Marshal(VerifyRequest{Username: "bob", Token: token})
+		if err != nil {
+			t.Fatalf("Failed to encode the verify request: %v", err)
+		}
+		return serve(s.verifyHTTPHandler, "", http.MethodPost, "/verify", body, nil).Code
+	}
+	if code := verify("wrong"); code != http.StatusBadRequest {
+		t.Errorf("Verifying with a wrong token returned %v, want %v", code, http.StatusBadRequest)
+	}
+	if code := verify(token); code != http.StatusOK {
+		t.Fatalf("Verifying returned %v, want %v", code, http.StatusOK)
+	}
+	if code := verify(token); code != http.StatusBadRequest {
+		t.Errorf("Verifying again returned %v, want %v", code, http.StatusBadRequest)
+	}
+	if code, resp := s.submit(t, "bob", submission(t, "echo")); code != http.StatusOK || !resp.Passed {
+		t.Errorf("Submit of a verified user returned %v %+v, want a passed submission", code, resp)
+	}
+	s.submissionsOf(t, "bob", 1, "")
+}
+
+func TestRegisterRejectsConfusableUsernames(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	s.addUser(t, "modern")
+
+	// The look-alikes can be registered unless they are rejected.
+	if w := s.register(t, "mоdern", testPassword); w.Code != http.StatusCreated {
+		t.Errorf("Registering a look-alike without rejecting them returned %v, want %v", w.Code, http.StatusCreated)
+	}
+	s.RejectConfusableUsernames = true
+	for _, username := range []string{
+		"MODERN",
+		// A Cyrillic "е" and a Greek "ο".
+		"modеrn", "mοdern",
+		// A fullwidth "ｍ", a combining accent and "rn" for "m".
+		"ｍodern", "modérn", "rnodern",
+		// A digit for the "o".
+		"m0dern",
+	} {
+		w := s.register(t, username, testPassword)
+		if w.Code != http.StatusBadRequest || !strings.Contains(errorOf(t, w), "too similar to the registered username") {
+			t.Errorf("Registering %q returned %v %q, want it rejected as too similar", username, w.Code, w.Body.String())
+		}
+		if _, err := userQ.find(s.db, username); err == nil {
+			t.Errorf("Look-alike username %q was registered", username)
+		}
+	}
+	if w := s.register(t, "modest", testPassword); w.Code != http.StatusCreated {
+		t.Errorf("Registering a distinct username returned %v, want %v", w.Code, http.StatusCreated)
+	}
+}
+
+func TestRegisterVerifiesCaptcha(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{})
+	defer s.close()
+	var ips []string
+	s.VerifyCaptcha = func(token, remoteIP string) error {
+		ips = append(ips, remoteIP)
+		if token != "solved" {
+			return fmt.Errorf("unsolved captcha")
+		}
+		return nil
+	}
+	register := func(username, captcha string) int {
+		body, err := json.Marshal(RegisterRequest{Username: username, Password: testPassword, Captcha: captcha})
+		if err != nil {
+			t.Fatalf("Failed to encode the register request: %v", err)
+		}
+		return serve(s.registerHTTPHandler, "", http.MethodPost, "/register", body, nil).Code
+	}
+
+	for _, c := range []struct {
+		username, captcha string
+		want              int
+	}{
+		{"bob", "", http.StatusBadRequest},
+		{"carl", "wrong", http.StatusForbidden},
+		{"dan", "solved", http.StatusCreated},
+	} {
+		if code := register(c.username, c.captcha); code != c.want {
+			t.Errorf("Registering %v with the captcha %q returned %v, want %v", c.username, c.captcha, code, c.want)
+		}
+		if _, err := userQ.find(s.db, c.username); (err == nil) != (c.want == http.StatusCreated) {
+			t.Errorf("Got the user %v registered: %v, want %v", c.username, err == nil, c.want == http.StatusCreated)
+		}
+	}
+	// The missing captcha isn't verified.
+	if want := []string{"192.0.2.1", "192.0.2.1"}; !reflect.DeepEqual(ips, want) {
+		t.Errorf("Got the captchas verified from %v, want %v", ips, want)
+	}
+}
+
+func TestSiteVerifyCaptcha(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.FormValue("secret") != "secret" || req.FormValue("remoteip") != "192.0.2.1" {
+			t.Errorf("Got the verification form %v, want the secret and the remote IP", req.Form)
+		}
+		if req.FormValue("response") == "solved" {
+			fmt.Fprint(w, `{"success": true}`)
+			return
+		}
+		fmt.Fprint(w, `{"success": false, "error-codes": ["invalid-input-response"]}`)
+	}))
+	defer srv.Close()
+	verify := SiteVerifyCaptcha(srv.URL, "secret")
+	if err := verify("solved", "192.0.2.1"); err != nil {
+		t.Errorf("Verifying a solved captcha failed: %v", err)
+	}
+	if err := verify("wrong", "192.0.2.1"); err == nil || !strings.Contains(err.Error(), "invalid-input-response") {
+		t.Errorf("Verifying a wrong captcha returned %v, want it rejected", err)
+	}
+}
@@ -3,14 +3,19 @@ package godge
 import (
 	"archive/zip"
 	"bytes"
+	crand "crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -27,11 +32,48 @@ func randomString(n int) string {
 	return string(b)
 }
 
-func unzipToTmpDir(b []byte) (string, error) {
+// secureToken returns a hex encoded random token of n bytes from crypto/rand, to be
+// used where the tokens must not be predictable (e.g. credentials).
+func secureToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := crand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPath returns the non empty segments of the path after trimming the prefix.
+func splitPath(path, prefix string) []string {
+	var ret []string
+	for _, p := range strings.Split(strings.TrimPrefix(path, prefix), "/") {
+		if p != "" {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// unzipToTmpDir extracts the archive to a new unique temp dir. The dir is removed
+// if the extraction fails.
+func unzipToTmpDir(b []byte) (_ string, err error) {
 	tdir, err := ioutil.TempDir("", "godge")
 	if err != nil {
 		return "", fmt.Errorf("failed to create a tmp dir: %v", err)
 	}
+	defer func(dir string) {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}(tdir)
 	tdir, err = filepath.EvalSymlinks(tdir)
 	if err != nil {
 		return "", fmt.Errorf("failed to eval symlinks: %v", err)
@@ -91,6 +133,12 @@ type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
+// httpMethodNotAllowed responds with 405 and sets the Allow header to the allowed methods.
+func httpMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	httpJSONError(w, fmt.Sprintf("Only %v requests are allowed", strings.Join(allowed, ", ")), http.StatusMethodNotAllowed)
+}
+
 func httpJSONError(w http.ResponseWriter, msg string, code int) {
 	e := ErrorResponse{
 		Error: msg,
@@ -99,3 +147,70 @@ func httpJSONError(w http.ResponseWriter, msg string, code int) {
 	b, _ := json.Marshal(e)
 	http.Error(w, string(b), code)
 }
+
+// clientIP returns the IP of the client sending the request. If trustForwardedFor
+// is true, the first address of the X-Forwarded-For header is used when present.
+func clientIP(req *http.Request, trustForwardedFor bool) net.IP {
+	if trustForwardedFor {
+		if fwd := req.Header.Get("X-Forwarded-For"); fwd != "" {
+			return net.ParseIP(strings.TrimSpace(strings.Split(fwd, ",")[0]))
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// parseCIDRs parses a list of CIDRs (e.g. 10.0.0.0/8).
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var ret []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %v: %v", c, err)
+		}
+		ret = append(ret, n)
+	}
+	return ret, nil
+}
+
+// flushWriter flushes the response after every write so that streamed content
+// reaches the client immediately.
+type flushWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+func newFlushWriter(w http.ResponseWriter) *flushWriter {
+	f, _ := w.(http.Flusher)
+	return &flushWriter{w: w, f: f}
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if fw.f != nil {
+		fw.f.Flush()
+	}
+	return n, err
+}
+
+// wantsXML reports whether the client asked for an XML response through the Accept header.
+func wantsXML(req *http.Request) bool {
+	accept := req.Header.Get("Accept")
+	return strings.Contains(accept, "application/xml") || strings.Contains(accept, "text/xml")
+}
+
+// writeXML writes v as an XML document.
+func writeXML(w http.ResponseWriter, v interface{}) {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		httpJSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(b)
+}
@@ -0,0 +1,61 @@
+package godge
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type cachedVerdict struct {
+	resp     SubmissionResponse
+	judgedAt time.Time
+}
+
+// verdictCache holds the responses of the recently judged submissions by their
+// verdictKey, so that identical resubmissions are not judged again.
+type verdictCache struct {
+	sync.Mutex
+	m map[string]cachedVerdict
+}
+
+// put caches the response of the submission and drops the entries older than the window.
+func (v *verdictCache) put(key string, resp SubmissionResponse, window time.Duration) {
+	v.Lock()
+	defer v.Unlock()
+	now := time.Now()
+	for k, c := range v.m {
+		if now.Sub(c.judgedAt) > window {
+			delete(v.m, k)
+		}
+	}
+	v.m[key] = cachedVerdict{resp: resp, judgedAt: now}
+}
+
+// get returns the cached response of the key if it was judged within the window.
+func (v *verdictCache) get(key string, window time.Duration) (SubmissionResponse, bool) {
+	v.Lock()
+	defer v.Unlock()
+	c, ok := v.m[key]
+	if !ok || time.Since(c.judgedAt) > window {
+		return SubmissionResponse{}, false
+	}
+	return c.resp, true
+}
+
+// clear drops all the cached verdicts.
+func (v *verdictCache) clear() {
+	v.Lock()
+	defer v.Unlock()
+	v.m = make(map[string]cachedVerdict)
+}
+
+// verdictKey identifies the submissions of the same source by the same user to
+// the same task.
+func verdictKey(username string, sub *Submission) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%v\x00%v\x00%v\x00", username, sub.TaskName, sub.Language)
+	h.Write(sub.Executor.source())
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,124 @@
+package godge
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The duration a finished submission's result can still be waited for.
+const submissionResultMaxAge = 10 * time.Minute
+
+type submissionWait struct {
+	username string
+	// Closed once the submission is judged and resp is set.
+	done       chan struct{}
+	resp       SubmissionResponse
+	finishedAt time.Time
+}
+
+// submissionWaits holds the results of the recent submissions for the clients
+// long polling them.
+type submissionWaits struct {
+	sync.Mutex
+	m map[string]*submissionWait
+}
+
+// add registers the submission with the given id and drops the expired results.
+func (sw *submissionWaits) add(id, username string) {
+	sw.Lock()
+	defer sw.Unlock()
+	now := time.Now()
+	for k, v := range sw.m {
+		if !v.finishedAt.IsZero() && now.Sub(v.finishedAt) > submissionResultMaxAge {
+			delete(sw.m, k)
+		}
+	}
+	sw.m[id] = &submissionWait{username: username, done: make(chan struct{})}
+}
+
+// finish sets the result of the submission and wakes up its waiters.
+func (sw *submissionWaits) finish(id string, resp SubmissionResponse) {
+	sw.Lock()
+	defer sw.Unlock()
+	w, ok := sw.m[id]
+	if !ok {
+		return
+	}
+	w.resp = resp
+	w.finishedAt = time.Now()
+	close(w.done)
+}
+
+// get returns the submission with the given id if it was submitted by the user.
+func (sw *submissionWaits) get(id, username string) (*submissionWait, bool) {
+	sw.Lock()
+	defer sw.Unlock()
+	w, ok := sw.m[id]
+	if !ok || w.username != username {
+		return nil, false
+	}
+	return w, true
+}
+
+// PendingResponse is the response returned back by the server for a submission
+// that is not judged yet, either in response to an async submit request or when
+// a wait request times out. It's exposed to be used by the command line client.
+type PendingResponse struct {
+	ID string `json:"id"`
+}
+
+// Handles the long poll requests blocking until the submission of the authenticated
+// user is judged, or the timeout (capped at the server's MaxWaitTimeout) elapses.
+func (s *Server) waitHTTPHandler(w http.ResponseWriter, req *http.Request, id string) {
+	if req.Method != http.MethodGet {
+		httpMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	u, ok := s.authenticate(req)
+	if !ok {
+		httpJSONError(w, "Wrong username or password", http.StatusUnauthorized)
+		return
+	}
+	sw, ok := s.submissionWaits.get(id, u.Username)
+	if !ok {
+		httpJSONError(w, fmt.Sprintf("Submission %v not found", id), http.StatusNotFound)
+		return
+	}
+	if !s.acquireStream(w, u.Username) {
+		return
+	}
+	defer s.userStreams.release(u.Username)
+
+	timeout := s.MaxWaitTimeout
+	if t := req.URL.Query().Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil || d <= 0 {
+			httpJSONError(w, fmt.Sprintf("Invalid timeout %q", t), http.StatusBadRequest)
+			return
+		}
+		if d < timeout {
+			timeout = d
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-sw.done:
+	case <-timer.C:
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(PendingResponse{ID: id})
+		return
+	case <-req.Context().Done():
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(sw.resp); err != nil {
+		httpJSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,107 @@
+package godge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWaitForAsyncSubmission(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.addUser(t, "bob")
+	release := make(chan struct{})
+	test := Test{Name: "slow", Func: func(*Submission) error {
+		<-release
+		return nil
+	}}
+	if err := s.RegisterTask(Task{Name: "slow", Tests: []Test{test}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	w := serve(s.submitHTTPHandler, testUsername, http.MethodPost, "/submit?async=true", submission(t, "slow"), nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Async submit returned %v, want %v", w.Code, http.StatusAccepted)
+	}
+	var pending PendingResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &pending); err != nil || pending.ID == "" {
+		t.Fatalf("Failed to decode the pending submission %q: %v", w.Body.String(), err)
+	}
+	url := "/submissions/" + pending.ID + "/wait"
+
+	w = serve(s.submissionHTTPHandler, testUsername, http.MethodGet, url+"?timeout=50ms", nil, nil)
+	var stillPending PendingResponse
+	if w.Code != http.StatusAccepted || json.Unmarshal(w.Body.Bytes(), &stillPending) != nil || stillPending.ID != pending.ID {
+		t.Errorf("Wait for the submission being judged returned %v %q, want %v and its id", w.Code, w.Body.String(), http.StatusAccepted)
+	}
+	if code := s.doAs(t, "bob", s.submissionHTTPHandler, http.MethodGet, url+"?timeout=50ms", nil, nil, nil); code != http.StatusNotFound {
+		t.Errorf("Wait for another user's submission returned %v, want %v", code, http.StatusNotFound)
+	}
+
+	close(release)
+	var resp SubmissionResponse
+	if code := s.do(t, s.submissionHTTPHandler, http.MethodGet, url+"?timeout=5s", nil, nil, &resp); code != http.StatusOK || resp.ID != pending.ID || !resp.Passed {
+		t.Errorf("Wait for the judged submission returned %v %+v, want its passed result", code, resp)
+	}
+}
+
+func TestMaxStreamsPerUser(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.MaxStreamsPerUser = 2
+	release := make(chan struct{})
+	defer close(release)
+	test := Test{Name: "slow", Func: func(*Submission) error {
+		<-release
+		return nil
+	}}
+	if err := s.RegisterTask(Task{Name: "slow", Tests: []Test{test}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	w := serve(s.submitHTTPHandler, testUsername, http.MethodPost, "/submit?async=true", submission(t, "slow"), nil)
+	var pending PendingResponse
+	if w.Code != http.StatusAccepted || json.Unmarshal(w.Body.Bytes(), &pending) != nil {
+		t.Fatalf("Async submit returned %v %q, want %v", w.Code, w.Body.String(), http.StatusAccepted)
+	}
+	url := "/submissions/" + pending.ID + "/wait"
+
+	streams := func() int {
+		s.userStreams.Lock()
+		defer s.userStreams.Unlock()
+		return s.userStreams.m[testUsername]
+	}
+	var cancels []context.CancelFunc
+	done := make(chan struct{}, s.MaxStreamsPerUser)
+	for i := 0; i < s.MaxStreamsPerUser; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancels = append(cancels, cancel)
+		req := httptest.NewRequest(http.MethodGet, url+"?timeout=5s", nil).WithContext(ctx)
+		req.SetBasicAuth(testUsername, testPassword)
+		go func() {
+			s.submissionHTTPHandler(httptest.NewRecorder(), req)
+			done <- struct{}{}
+		}()
+	}
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+	waitFor(t, "the streams to start", func() bool { return streams() == s.MaxStreamsPerUser })
+
+	if code := s.do(t, s.submissionHTTPHandler, http.MethodGet, url+"?timeout=10ms", nil, nil, nil); code != http.StatusTooManyRequests {
+		t.Errorf("Wait over the streams limit returned %v, want %v", code, http.StatusTooManyRequests)
+	}
+
+	// Disconnecting frees the slot.
+	cancels[0]()
+	<-done
+	if n := streams(); n != s.MaxStreamsPerUser-1 {
+		t.Errorf("Got %v streams after a disconnection, want %v", n, s.MaxStreamsPerUser-1)
+	}
+	if code := s.do(t, s.submissionHTTPHandler, http.MethodGet, url+"?timeout=10ms", nil, nil, nil); code != http.StatusAccepted {
+		t.Errorf("Wait after a disconnection returned %v, want %v", code, http.StatusAccepted)
+	}
+}
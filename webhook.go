@@ -0,0 +1,138 @@
+package godge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// The delay before retrying a failed webhook delivery for the first time. It's
+	// doubled after every failed attempt up to maxWebhookBackoff.
+	initialWebhookBackoff = time.Second
+	maxWebhookBackoff     = 5 * time.Minute
+	// How often the queue is checked for due deliveries.
+	webhookPollInterval = time.Second
+)
+
+// SolveEvent is the payload that is POSTed to the webhook when a user solves a task.
+type SolveEvent struct {
+	Username string    `json:"username"`
+	TaskName string    `json:"taskName"`
+	SolvedAt time.Time `json:"solvedAt"`
+}
+
+type webhookDelivery struct {
+	ID            int       `db:"id"`
+	URL           string    `db:"url"`
+	Payload       string    `db:"payload"`
+	Attempts      int       `db:"attempts"`
+	NextAttemptAt time.Time `db:"next_attempt_at"`
+	CreatedAt     time.Time `db:"created_at"`
+}
+
+// enqueueSolveEvent queues the solve event for delivery to the server's webhook
+// and to the webhooks of the solved task. The queue is persisted so the events
+// survive restarts. The solves of the frozen contest are only delivered once it
+// ends, or never if it has no end.
+func (s *Server) enqueueSolveEvent(sub *Submission) error {
+	var urls []string
+	if s.WebhookURL != "" {
+		urls = append(urls, s.WebhookURL)
+	}
+	if t, ok := s.tasks.get(sub.TaskName); ok {
+		urls = append(urls, t.WebhookURLs...)
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(SolveEvent{
+		Username: sub.Username,
+		TaskName: sub.TaskName,
+		SolvedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal solve event: %v", err)
+	}
+	sendAt := time.Now()
+	if s.phase(sendAt) == ContestFrozen {
+		_, _, endAt := s.contestWindow()
+		if endAt.IsZero() {
+			return nil
+		}
+		sendAt = endAt
+	}
+	// The deliveries are created once due, so that the deferred ones aren't dropped
+	// for their age (see WebhookMaxAge) before their first attempt.
+	for _, url := range urls {
+		_, err = s.db.Exec("INSERT INTO webhook_deliveries (url, payload, attempts, next_attempt_at, created_at) VALUES (?,?,?,?,?)", url, string(payload), 0, sendAt, sendAt)
+		if err != nil {
+			return fmt.Errorf("failed to queue webhook delivery: %v", err)
+		}
+	}
+	return nil
+}
+
+// deliverWebhooks periodically delivers the due events in the webhook queue. Failed
+// deliveries are retried with an exponential backoff until they are older than
+// WebhookMaxAge.
+func (s *Server) deliverWebhooks() {
+	client := &http.Client{Timeout: 10 * time.Second}
+	for now := range time.Tick(webhookPollInterval) {
+		s.deliverDueWebhooks(client, now)
+	}
+}
+
+// deliverDueWebhooks attempts the deliveries in the webhook queue that are due at now.
+func (s *Server) deliverDueWebhooks(client *http.Client, now time.Time) {
+	var ds []webhookDelivery
+	if err := s.db.Select(&ds, "SELECT * FROM webhook_deliveries WHERE next_attempt_at <= ? ORDER BY id", now); err != nil {
+		log.Printf("Failed to fetch webhook deliveries: %v", err)
+		return
+	}
+	for _, d := range ds {
+		s.deliverWebhook(client, d, now)
+	}
+}
+
+func (s *Server) deliverWebhook(client *http.Client, d webhookDelivery, now time.Time) {
+	err := postWebhook(client, d.URL, d.Payload)
+	if err == nil {
+		if _, err := s.db.Exec("DELETE FROM webhook_deliveries WHERE id=?", d.ID); err != nil {
+			log.Printf("Failed to delete delivered webhook %v: %v", d.ID, err)
+		}
+		return
+	}
+
+	if now.Sub(d.CreatedAt) > s.WebhookMaxAge {
+		log.Printf("Dropping webhook delivery %v to %v after %v attempts: %v", d.ID, d.URL, d.Attempts+1, err)
+		if _, err := s.db.Exec("DELETE FROM webhook_deliveries WHERE id=?", d.ID); err != nil {
+			log.Printf("Failed to delete dropped webhook %v: %v", d.ID, err)
+		}
+		return
+	}
+
+	backoff := initialWebhookBackoff << uint(d.Attempts)
+	if backoff > maxWebhookBackoff || backoff <= 0 {
+		backoff = maxWebhookBackoff
+	}
+	log.Printf("Webhook delivery %v to %v failed, retrying in %v: %v", d.ID, d.URL, backoff, err)
+	if _, err := s.db.Exec("UPDATE webhook_deliveries SET attempts=?, next_attempt_at=? WHERE id=?", d.Attempts+1, now.Add(backoff), d.ID); err != nil {
+		log.Printf("Failed to reschedule webhook %v: %v", d.ID, err)
+	}
+}
+
+func postWebhook(client *http.Client, url, payload string) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader([]byte(payload)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %v", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package godge
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFrozenSolvesDeliveredOnceEnded(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.WebhookURL = "http://example.com/hook"
+	now := time.Now()
+	s.StartAt, s.FreezeAt, s.EndAt = now.Add(-time.Hour), now.Add(-time.Minute), now.Add(time.Hour)
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	if code, resp := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK || !resp.Passed {
+		t.Fatalf("Submit returned %v %+v, want a passed submission", code, resp)
+	}
+	s.submissions(t, 1, "")
+	var ds []webhookDelivery
+	if err := s.db.Select(&ds, "SELECT * FROM webhook_deliveries"); err != nil {
+		t.Fatalf("Failed to fetch webhook deliveries: %v", err)
+	}
+	if len(ds) != 1 || !ds[0].NextAttemptAt.Equal(s.EndAt) {
+		t.Errorf("Got deliveries %+v, want a single one due at the end %v", ds, s.EndAt)
+	}
+}
+
+func TestWebhookRetriedUntilDelivered(t *testing.T) {
+	var mu sync.Mutex
+	var events []SolveEvent
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var e SolveEvent
+		if err := json.NewDecoder(req.Body).Decode(&e); err != nil {
+			t.Errorf("Failed to decode the solve event: %v", err)
+		}
+		events = append(events, e)
+		if len(events) <= 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+	received := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(events)
+	}
+
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.WebhookURL = receiver.URL
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{outputTest("hello", "hello")}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+	if code, resp := s.submit(t, testUsername, submission(t, "echo")); code != http.StatusOK || !resp.Passed {
+		t.Fatalf("Submit returned %v %+v, want a passed submission", code, resp)
+	}
+	waitFor(t, "the solve event", func() bool { return s.count(t, "webhook_deliveries") == 1 })
+
+	client := &http.Client{Timeout: time.Second}
+	now := time.Now()
+	for _, c := range []struct {
+		at       time.Time
+		received int
+		queued   int
+	}{
+		{now, 1, 1},
+		// The retry is not due until the backoff elapses.
+		{now, 1, 1},
+		{now.Add(initialWebhookBackoff), 2, 1},
+		{now.Add(2 * initialWebhookBackoff), 2, 1},
+		{now.Add(3 * initialWebhookBackoff), 3, 0},
+	} {
+		s.deliverDueWebhooks(client, c.at)
+		if n := received(); n != c.received {
+			t.Errorf("Got %v deliveries at %v, want %v", n, c.at.Sub(now), c.received)
+		}
+		if n := s.count(t, "webhook_deliveries"); n != c.queued {
+			t.Errorf("Got %v queued deliveries at %v, want %v", n, c.at.Sub(now), c.queued)
+		}
+	}
+	if e := events[len(events)-1]; e.Username != testUsername || e.TaskName != "echo" {
+		t.Errorf("Got the solve event %+v, want the solve of echo by %v", e, testUsername)
+	}
+}
+
+func TestTaskWebhooksReceiveOnlyTheirSolves(t *testing.T) {
+	var mu sync.Mutex
+	events := make(map[string][]string)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var e SolveEvent
+		if err := json.NewDecoder(req.Body).Decode(&e); err != nil {
+			t.Errorf("Failed to decode the solve event: %v", err)
+		}
+		events[req.URL.Path] = append(events[req.URL.Path], e.TaskName)
+	}))
+	defer receiver.Close()
+
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.WebhookURL = receiver.URL + "/all"
+	for _, name := range []string{"echo", "hi"} {
+		task := Task{Name: name, WebhookURLs: []string{receiver.URL + "/" + name}, Tests: []Test{outputTest("hello", "hello")}}
+		if err := s.RegisterTask(task); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+	for i, name := range []string{"echo", "hi"} {
+		if code, resp := s.submit(t, testUsername, submission(t, name)); code != http.StatusOK || !resp.Passed {
+			t.Fatalf("Submit to %v returned %v %+v, want a passed submission", name, code, resp)
+		}
+		waitFor(t, "the solve events of "+name, func() bool { return s.count(t, "webhook_deliveries") == 2*(i+1) })
+	}
+
+	s.deliverDueWebhooks(&http.Client{Timeout: time.Second}, time.Now())
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string][]string{
+		"/all":  {"echo", "hi"},
+		"/echo": {"echo"},
+		"/hi":   {"hi"},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Got the solve events %v by webhook, want %v", events, want)
+	}
+}
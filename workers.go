@@ -0,0 +1,201 @@
+package godge
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// workerPool keeps track of the goroutines processing the pending submissions
+// so that it can be scaled at runtime.
+type workerPool struct {
+	sync.Mutex
+	// The stop channel of each of the running workers.
+	stops []chan struct{}
+	// The number of workers the auto scaler doesn't scale below, set by Start and
+	// by the admins through /admin/workers.
+	floor int
+}
+
+// size returns the number of running workers.
+func (p *workerPool) size() int {
+	p.Lock()
+	defer p.Unlock()
+	return len(p.stops)
+}
+
+// min returns the number of workers the auto scaler doesn't scale below.
+func (p *workerPool) min() int {
+	p.Lock()
+	defer p.Unlock()
+	return p.floor
+}
+
+// set scales the pool to n workers and makes it the floor of the auto scaler.
+func (p *workerPool) set(n int, work func(stop chan struct{})) {
+	p.Lock()
+	defer p.Unlock()
+	p.floor = n
+	p.resize(n, work)
+}
+
+// scale starts or stops workers until n workers are running. Stopped workers
+// exit after finishing the submission they are currently processing.
+func (p *workerPool) scale(n int, work func(stop chan struct{})) {
+	p.Lock()
+	defer p.Unlock()
+	p.resize(n, work)
+}
+
+// resize is scale with the lock held.
+func (p *workerPool) resize(n int, work func(stop chan struct{})) {
+	for len(p.stops) < n {
+		stop := make(chan struct{})
+		p.stops = append(p.stops, stop)
+		go work(stop)
+	}
+	for len(p.stops) > n {
+		close(p.stops[len(p.stops)-1])
+		p.stops = p.stops[:len(p.stops)-1]
+	}
+}
+
+// queueStats records the waits of the submissions in the queue since the last
+// time they were read by the auto scaler.
+type queueStats struct {
+	sync.Mutex
+	nextID int
+	// The times the submissions still in the queue were queued at.
+	waiting    map[int]time.Time
+	maxWait    time.Duration
+	lastQueued time.Time
+}
+
+// start records that a submission entered the queue and returns its id in the stats.
+func (q *queueStats) start() int {
+	q.Lock()
+	defer q.Unlock()
+	if q.waiting == nil {
+		q.waiting = make(map[int]time.Time)
+	}
+	q.nextID++
+	q.waiting[q.nextID] = time.Now()
+	return q.nextID
+}
+
+// done records that the submission with the given id left the queue.
+func (q *queueStats) done(id int) {
+	q.Lock()
+	defer q.Unlock()
+	if wait := time.Since(q.waiting[id]); wait > q.maxWait {
+		q.maxWait = wait
+	}
+	delete(q.waiting, id)
+	q.lastQueued = time.Now()
+}
+
+// reset returns the maximum wait of the submissions, including the ones still in
+// the queue at now, since the last reset and the time the last submission left the
+// queue.
+func (q *queueStats) reset(now time.Time) (time.Duration, time.Time) {
+	q.Lock()
+	defer q.Unlock()
+	maxWait := q.maxWait
+	for _, t := range q.waiting {
+		if wait := now.Sub(t); wait > maxWait {
+			maxWait = wait
+		}
+	}
+	q.maxWait = 0
+	return maxWait, q.lastQueued
+}
+
+// enqueue sends the submission request to the workers. The submissions of a user
+// to a task are sent one at a time in the order they are enqueued, the next one
+// once the previous one is reported (see userTaskQueues), so that they don't hold
+// the workers meanwhile. The sample runs are sent right away.
+func (s *Server) enqueue(sreq submissionRequest) {
+	if sreq.submission.Seed == 0 {
+		sreq.submission.Seed = s.Seed
+	}
+	if sreq.submission.Seed == 0 {
+		sreq.submission.Seed = rand.Int63()
+	}
+	if sreq.samples == nil && !s.userTaskQueues.push(sreq) {
+		return
+	}
+	s.dispatch(sreq)
+}
+
+// dispatch sends the submission request to the workers and records how long it
+// waited for a free worker.
+func (s *Server) dispatch(sreq submissionRequest) {
+	id := s.queueStats.start()
+	s.pendingSubmissions <- sreq
+	s.queueStats.done(id)
+}
+
+// userTaskQueues holds the submissions of each user to each task waiting to be
+// judged, in the order they were enqueued. The first submission of each queue is
+// the one sent to the workers.
+type userTaskQueues struct {
+	sync.Mutex
+	m map[string][]submissionRequest
+}
+
+func userTaskKey(sub *Submission) string {
+	return sub.Username + "/" + sub.TaskName
+}
+
+// push appends the submission to the queue of its user and task. It returns true
+// if the submission is the first of the queue and must be sent to the workers.
+func (q *userTaskQueues) push(sreq submissionRequest) bool {
+	q.Lock()
+	defer q.Unlock()
+	key := userTaskKey(sreq.submission)
+	q.m[key] = append(q.m[key], sreq)
+	return len(q.m[key]) == 1
+}
+
+// pop removes the judged first submission of the queue of its user and task, and
+// returns the next one to send to the workers if any.
+func (q *userTaskQueues) pop(sub *Submission) (submissionRequest, bool) {
+	q.Lock()
+	defer q.Unlock()
+	key := userTaskKey(sub)
+	queue := q.m[key][1:]
+	if len(queue) == 0 {
+		delete(q.m, key)
+		return submissionRequest{}, false
+	}
+	q.m[key] = queue
+	return queue[0], true
+}
+
+// autoscaleWorkers scales the workers between the pool's floor (Workers, unless
+// changed by an admin) and MaxWorkers according to the waits of the submissions
+// in the queue.
+func (s *Server) autoscaleWorkers() {
+	for now := range time.Tick(time.Second) {
+		s.scaleWorkers(now)
+	}
+}
+
+// scaleWorkers starts or stops one worker according to the waits of the submissions
+// in the queue at now.
+func (s *Server) scaleWorkers(now time.Time) {
+	maxWait, lastQueued := s.queueStats.reset(now)
+	n := s.workers.size()
+	switch {
+	case maxWait > s.ScaleUpQueueWait:
+		// The workers aren't scaled down while the submissions wait, even at MaxWorkers.
+		if n < s.MaxWorkers {
+			s.workers.scale(n+1, s.processSubmissions)
+			log.Printf("Submissions waited for %v, workers scaled up to %v", maxWait, n+1)
+		}
+	case now.Sub(lastQueued) > s.ScaleDownIdle && n > s.workers.min():
+		s.workers.scale(n-1, s.processSubmissions)
+		log.Printf("No submissions for %v, workers scaled down to %v", s.ScaleDownIdle, n-1)
+	}
+}
@@ -0,0 +1,190 @@
+package godge
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolConcurrentScaling(t *testing.T) {
+	var p workerPool
+	var mu sync.Mutex
+	running := 0
+	work := func(stop chan struct{}) {
+		mu.Lock()
+		running++
+		mu.Unlock()
+		<-stop
+		mu.Lock()
+		running--
+		mu.Unlock()
+	}
+
+	p.set(2, work)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			p.scale(n, work)
+			if p.size() < 0 {
+				t.Errorf("Got a negative number of workers")
+			}
+		}(i % 5)
+	}
+	wg.Wait()
+	p.set(3, work)
+	if got := p.size(); got != 3 {
+		t.Errorf("Got %v workers, want 3", got)
+	}
+	if got := p.min(); got != 3 {
+		t.Errorf("Got a floor of %v workers, want 3", got)
+	}
+	// The stopped workers exit asynchronously.
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		n := running
+		mu.Unlock()
+		if n == 3 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Got %v running workers, want 3", n)
+		}
+	}
+	p.set(0, work)
+}
+
+func TestAutoscaleWorkersBetweenAdminFloorAndMax(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.Admins = []string{"admin"}
+	s.addUser(t, "admin")
+	s.MaxWorkers = 4
+	s.ScaleUpQueueWait = time.Minute
+	s.ScaleDownIdle = time.Minute
+	release := make(chan struct{})
+	const n = 6
+	for i := 0; i < n; i++ {
+		test := Test{Name: "blocked", Func: func(*Submission) error {
+			<-release
+			return nil
+		}}
+		if err := s.RegisterTask(Task{Name: fmt.Sprintf("task%v", i), Tests: []Test{test}}); err != nil {
+			t.Fatalf("Failed to register task: %v", err)
+		}
+	}
+	if code := s.doAs(t, "admin", s.workersHTTPHandler, http.MethodPost, "/admin/workers", []byte(`{"count": 2}`), nil, nil); code != http.StatusOK {
+		t.Fatalf("Scaling the workers returned %v, want %v", code, http.StatusOK)
+	}
+
+	for i := 0; i < n; i++ {
+		if w := serve(s.submitHTTPHandler, testUsername, http.MethodPost, "/submit?async=true", submission(t, fmt.Sprintf("task%v", i)), nil); w.Code != http.StatusAccepted {
+			t.Fatalf("Async submit returned %v, want %v", w.Code, http.StatusAccepted)
+		}
+	}
+	queued := func(want int) func() bool {
+		return func() bool {
+			s.queueStats.Lock()
+			defer s.queueStats.Unlock()
+			return len(s.queueStats.waiting) == want
+		}
+	}
+	waitFor(t, "the submissions to queue up", queued(n-2))
+
+	// The submissions are still waiting for a free worker a while later, the workers
+	// are scaled up one at a time until MaxWorkers.
+	later := time.Now().Add(2 * time.Minute)
+	for _, want := range []int{3, 4, 4} {
+		s.scaleWorkers(later)
+		if got := s.workers.size(); got != want {
+			t.Errorf("Got %v workers after a long wait, want %v", got, want)
+		}
+	}
+	close(release)
+	s.submissions(t, n, "")
+	waitFor(t, "the queue to drain", queued(0))
+
+	// The workers aren't scaled down before ScaleDownIdle without submissions.
+	s.scaleWorkers(time.Now())
+	if got := s.workers.size(); got != 4 {
+		t.Errorf("Got %v workers right after the burst, want 4", got)
+	}
+	later = time.Now().Add(2 * time.Minute)
+	for _, want := range []int{3, 2, 2} {
+		s.scaleWorkers(later)
+		if got := s.workers.size(); got != want {
+			t.Errorf("Got %v idle workers, want %v down to the admin floor", got, want)
+		}
+	}
+}
+
+func TestConcurrentSubmissionsOfUserTaskJudgedInTurn(t *testing.T) {
+	s := newTestServer(t, &fakeDocker{stdout: "hello"})
+	defer s.close()
+	s.workers.set(4, s.processSubmissions)
+	var mu sync.Mutex
+	var judged []string
+	running, overlapping := 0, false
+	test := Test{Name: "tagged", Func: func(sub *Submission) error {
+		mu.Lock()
+		running++
+		overlapping = overlapping || running > 1
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		judged = append(judged, sub.Tags[0])
+		mu.Unlock()
+		if sub.Tags[1] == "fail" {
+			return fmt.Errorf("failed as tagged")
+		}
+		return nil
+	}}
+	if err := s.RegisterTask(Task{Name: "echo", Tests: []Test{test}}); err != nil {
+		t.Fatalf("Failed to register task: %v", err)
+	}
+
+	const n = 8
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result := "pass"
+			if i%2 == 0 {
+				result = "fail"
+			}
+			if code, _ := s.submit(t, testUsername, submission(t, "echo", fmt.Sprint(i), result)); code != http.StatusOK {
+				t.Errorf("Submit returned %v, want %v", code, http.StatusOK)
+			}
+		}(i)
+	}
+	wg.Wait()
+	subs := s.submissions(t, n, "")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if overlapping {
+		t.Errorf("Submissions of the same user and task were judged at the same time")
+	}
+	// The submissions are stored in the order they were judged, newest first.
+	var stored []string
+	for i := len(subs) - 1; i >= 0; i-- {
+		stored = append(stored, subs[i].Tags[0])
+	}
+	if !reflect.DeepEqual(stored, judged) {
+		t.Errorf("Got the stored submissions %v, want them in the judged order %v", stored, judged)
+	}
+	last := subs[0]
+	want := passedVerdict
+	if last.Tags[1] == "fail" {
+		want = failedVerdict
+	}
+	if last.Verdict != want {
+		t.Errorf("Got the final verdict %v of the submission tagged %v, want %v", last.Verdict, last.Tags, want)
+	}
+}